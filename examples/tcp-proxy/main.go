@@ -40,61 +40,29 @@ func main() {
 	nodeA := connectNode(switcherAddr, "node-a", password)
 	defer nodeA.Close()
 
-	flexLn, _ := nodeA.Listen(80)
-	go func() {
-		for {
-			flexConn, err := flexLn.Accept()
-			if err != nil {
-				return
-			}
-			go func() {
-				local, err := net.Dial("tcp", httpLn.Addr().String())
-				if err != nil {
-					flexConn.Close()
-					return
-				}
-				go io.Copy(local, flexConn)
-				io.Copy(flexConn, local)
-				local.Close()
-				flexConn.Close()
-			}()
-		}
-	}()
+	exposed, _ := nodeA.ForwardRemote(80, httpLn.Addr().String())
+	defer exposed.Close()
 
 	// ---- 4. node-b：在本地开 TCP 端口，转发到 node-a:80 ----
 	nodeB := connectNode(switcherAddr, "node-b", password)
 	defer nodeB.Close()
 
-	proxyLn, _ := net.Listen("tcp", "127.0.0.1:0")
-	log.Printf("proxy listening on %s -> node-a:80", proxyLn.Addr())
-	go func() {
-		for {
-			conn, err := proxyLn.Accept()
-			if err != nil {
-				return
-			}
-			go func() {
-				flexConn, err := nodeB.Dial("node-a:80")
-				if err != nil {
-					conn.Close()
-					return
-				}
-				go io.Copy(flexConn, conn)
-				io.Copy(conn, flexConn)
-				conn.Close()
-				flexConn.Close()
-			}()
-		}
-	}()
+	proxy, err := nodeB.ForwardLocal("127.0.0.1:0", "node-a:80")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer proxy.Close()
+	log.Printf("proxy listening on %s -> node-a:80", proxy.Addr())
 
 	// ---- 5. 通过 proxy 端口访问内网服务 ----
-	resp, err := http.Get(fmt.Sprintf("http://%s/", proxyLn.Addr()))
+	resp, err := http.Get(fmt.Sprintf("http://%s/", proxy.Addr()))
 	if err != nil {
 		log.Fatal(err)
 	}
 	body, _ := io.ReadAll(resp.Body)
 	resp.Body.Close()
 	fmt.Printf("response via proxy: %s", body)
+	fmt.Printf("proxy stats: %+v\n", proxy.Stats())
 }
 
 func connectNode(addr, domain, password string) *node.Node {
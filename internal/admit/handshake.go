@@ -6,6 +6,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/net-agent/flex/v3/internal/selector"
 	"github.com/net-agent/flex/v3/packet"
 )
 
@@ -22,36 +23,55 @@ var (
 )
 
 func Handshake(pc packet.Conn, domain, mac, password string) (uint16, error) {
+	return HandshakeWithLabels(pc, domain, mac, password, nil)
+}
+
+// HandshakeWithLabels 与 Handshake 相同，同时向 switcher 声明节点的标签
+func HandshakeWithLabels(pc packet.Conn, domain, mac, password string, labels map[string]string) (uint16, error) {
+	resp, err := handshake(pc, &Request{Domain: domain, Mac: mac, Labels: labels}, password)
+	if err != nil {
+		return 0, err
+	}
+	return resp.IP, nil
+}
+
+// HandshakePeer 以 switcher 对等节点的身份完成握手，返回对端 switcher 的名称。
+func HandshakePeer(pc packet.Conn, name, password string) (string, error) {
+	resp, err := handshake(pc, &Request{Domain: name, Peer: true}, password)
+	if err != nil {
+		return "", err
+	}
+	return resp.Name, nil
+}
+
+func handshake(pc packet.Conn, req *Request, password string) (*Response, error) {
 	pc.SetWriteTimeout(handshakeTimeout)
 	pc.SetReadTimeout(handshakeTimeout)
 	defer pc.SetWriteTimeout(0)
 	defer pc.SetReadTimeout(0)
 
-	var req Request
 	req.Version = packet.VERSION
-	req.Domain = domain
-	req.Mac = mac
 	req.Timestamp = time.Now().UnixNano()
 	req.Sum = req.CalcSum(password)
 
 	if err := req.WriteTo(pc, password); err != nil {
-		return 0, fmt.Errorf("handshake: write request: %w", err)
+		return nil, fmt.Errorf("handshake: write request: %w", err)
 	}
 
 	var resp Response
 	if err := resp.ReadFrom(pc, password); err != nil {
-		return 0, fmt.Errorf("handshake: read response: %w", err)
+		return nil, fmt.Errorf("handshake: read response: %w", err)
 	}
 
 	if resp.ErrCode != 0 {
-		return 0, fmt.Errorf("handshake: server rejected: %v", resp.ErrMsg)
+		return nil, fmt.Errorf("handshake: server rejected: %v", resp.ErrMsg)
 	}
 
 	if resp.Version != packet.VERSION {
-		return 0, fmt.Errorf("handshake: %w: local=%v remote=%v", ErrVersionMismatch, packet.VERSION, resp.Version)
+		return nil, fmt.Errorf("handshake: %w: local=%v remote=%v", ErrVersionMismatch, packet.VERSION, resp.Version)
 	}
 
-	return resp.IP, nil
+	return &resp, nil
 }
 
 func Accept(pc packet.Conn, pswd string) (*Request, error) {
@@ -87,6 +107,10 @@ func Accept(pc packet.Conn, pswd string) (*Request, error) {
 	}
 	req.Domain = normalized
 
+	if err := selector.ValidateLabels(req.Labels); err != nil {
+		return nil, err
+	}
+
 	return req, nil
 }
 
@@ -112,6 +136,22 @@ func NormalizeDomain(domain string) (string, error) {
 	return domain, nil
 }
 
+// NormalizeName 规范化可能带有层级的名称（如经由中继注册的 "device.gateway"），
+// 以 '.' 分隔的每一段都需要满足 NormalizeDomain 的规则。
+func NormalizeName(name string) (string, error) {
+	name = strings.TrimSpace(strings.ToLower(name))
+	if name == "" || len(name) > 253 {
+		return "", ErrInvalidDomain
+	}
+	for _, label := range strings.Split(name, ".") {
+		normalized, err := NormalizeDomain(label)
+		if err != nil || normalized != label {
+			return "", ErrInvalidDomain
+		}
+	}
+	return name, nil
+}
+
 // IsInvalidDomain 判断名称是否合法（兼容旧调用方）。
 func IsInvalidDomain(domain string) bool {
 	_, err := NormalizeDomain(domain)
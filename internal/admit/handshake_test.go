@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/net-agent/flex/v3/internal/selector"
 	"github.com/net-agent/flex/v3/packet"
 )
 
@@ -226,3 +227,138 @@ func TestAccept_TimestampExpired(t *testing.T) {
 		t.Errorf("unexpected err=%v, want ErrTimestampExpired\n", err)
 	}
 }
+
+func TestHandshakePeer(t *testing.T) {
+	pswd := "testpswd"
+	pc1, pc2 := packet.Pipe()
+
+	go func() {
+		req, err := Accept(pc2, pswd)
+		if err != nil || !req.Peer {
+			NewErrResponse(-1, "not a peer").WriteTo(pc2, pswd)
+			return
+		}
+		NewPeerResponse("region-b").WriteTo(pc2, pswd)
+	}()
+
+	name, err := HandshakePeer(pc1, "region-a", pswd)
+	if err != nil {
+		t.Errorf("unexpected err=%v\n", err)
+		return
+	}
+	if name != "region-b" {
+		t.Errorf("unexpected peer name=%v\n", name)
+	}
+}
+
+func TestAccept_PeerFlagIsSigned(t *testing.T) {
+	pswd := "testpswd"
+	pc1, pc2 := packet.Pipe()
+
+	// 签名时不带 Peer 标记，发送时再打开，服务端应当拒绝
+	go func() {
+		var req Request
+		req.Version = packet.VERSION
+		req.Domain = "region-a"
+		req.Timestamp = time.Now().UnixNano()
+		req.Sum = req.CalcSum(pswd)
+		req.Peer = true
+		req.WriteTo(pc1, pswd)
+	}()
+
+	_, err := Accept(pc2, pswd)
+	if !errors.Is(err, ErrInvalidPassword) {
+		t.Errorf("unexpected err=%v\n", err)
+	}
+}
+
+func TestNormalizeName(t *testing.T) {
+	valid := map[string]string{
+		"gw":               "gw",
+		"Device.GW":        "device.gw",
+		" a.b-c.d_e ":      "a.b-c.d_e",
+		"sensor-1.edge.hq": "sensor-1.edge.hq",
+	}
+	for in, want := range valid {
+		got, err := NormalizeName(in)
+		if err != nil || got != want {
+			t.Errorf("NormalizeName(%q) = %q, %v", in, got, err)
+		}
+	}
+
+	invalid := []string{"", ".", "a.", ".a", "a..b", "a.local", "a. b", "a.-b"}
+	for _, in := range invalid {
+		if _, err := NormalizeName(in); err == nil {
+			t.Errorf("NormalizeName(%q) should fail", in)
+		}
+	}
+}
+
+func TestHandshakeWithLabels(t *testing.T) {
+	pswd := "testpswd"
+	pc1, pc2 := packet.Pipe()
+
+	labels := map[string]string{"role": "db", "region": "hq"}
+	got := make(chan map[string]string, 1)
+	go func() {
+		req, err := Accept(pc2, pswd)
+		if err != nil {
+			got <- nil
+			NewErrResponse(-1, err.Error()).WriteTo(pc2, pswd)
+			return
+		}
+		got <- req.Labels
+		NewOKResponse(1).WriteTo(pc2, pswd)
+	}()
+
+	_, err := HandshakeWithLabels(pc1, "test", "", pswd, labels)
+	if err != nil {
+		t.Errorf("unexpected err=%v\n", err)
+		return
+	}
+	if l := <-got; len(l) != 2 || l["role"] != "db" || l["region"] != "hq" {
+		t.Errorf("unexpected labels=%v\n", l)
+	}
+}
+
+func TestAccept_LabelsAreSigned(t *testing.T) {
+	pswd := "testpswd"
+	pc1, pc2 := packet.Pipe()
+
+	// 签名后再篡改标签，服务端应当拒绝
+	go func() {
+		var req Request
+		req.Version = packet.VERSION
+		req.Domain = "test"
+		req.Timestamp = time.Now().UnixNano()
+		req.Labels = map[string]string{"role": "web"}
+		req.Sum = req.CalcSum(pswd)
+		req.Labels["role"] = "db"
+		req.WriteTo(pc1, pswd)
+	}()
+
+	_, err := Accept(pc2, pswd)
+	if !errors.Is(err, ErrInvalidPassword) {
+		t.Errorf("unexpected err=%v\n", err)
+	}
+}
+
+func TestAccept_InvalidLabels(t *testing.T) {
+	pswd := "testpswd"
+	pc1, pc2 := packet.Pipe()
+
+	go func() {
+		var req Request
+		req.Version = packet.VERSION
+		req.Domain = "test"
+		req.Timestamp = time.Now().UnixNano()
+		req.Labels = map[string]string{"bad key": "v"}
+		req.Sum = req.CalcSum(pswd)
+		req.WriteTo(pc1, pswd)
+	}()
+
+	_, err := Accept(pc2, pswd)
+	if !errors.Is(err, selector.ErrInvalidLabel) {
+		t.Errorf("unexpected err=%v\n", err)
+	}
+}
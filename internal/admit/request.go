@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"fmt"
 
+	"github.com/net-agent/flex/v3/internal/selector"
 	"github.com/net-agent/flex/v3/packet"
 )
 
@@ -22,11 +23,19 @@ type Request struct {
 	Mac       string
 	Timestamp int64
 	Sum       string
+	Peer      bool              `json:",omitempty"` // true when the caller is a federated switcher
+	Labels    map[string]string `json:",omitempty"` // key/value labels used by selectors
 }
 
 func (req *Request) CalcSum(password string) string {
 	h := hmac.New(sha256.New, []byte(password))
 	fmt.Fprintf(h, "%v,%v,%v", req.Domain, req.Mac, req.Timestamp)
+	if req.Peer {
+		h.Write([]byte(",peer"))
+	}
+	if len(req.Labels) > 0 {
+		fmt.Fprintf(h, ",labels:%v", selector.Format(req.Labels))
+	}
 	return base64.StdEncoding.EncodeToString(h.Sum(nil))
 }
 
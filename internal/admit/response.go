@@ -11,12 +11,18 @@ type Response struct {
 	ErrMsg  string
 	IP      uint16
 	Version int
+	Name    string `json:",omitempty"` // switcher name, only set for peer handshakes
 }
 
 func NewOKResponse(ip uint16) *Response {
 	return &Response{Version: packet.VERSION, IP: ip}
 }
 
+// NewPeerResponse 构造对等 switcher 握手成功时的应答
+func NewPeerResponse(name string) *Response {
+	return &Response{Version: packet.VERSION, Name: name}
+}
+
 func NewErrResponse(code int, msg string) *Response {
 	return &Response{Version: packet.VERSION, ErrCode: code, ErrMsg: msg}
 }
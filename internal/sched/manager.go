@@ -187,15 +187,26 @@ func (fw *FairWriter) loop() {
 			return
 		case buf := <-fw.controlCh:
 			fw.writer.WriteBuffer(buf)
-			continue
 		case sid := <-fw.readyQueue:
 			// Preemption: drain control channel first
 			fw.drainControl()
 			fw.processStream(sid)
 		}
+		fw.flushIfIdle()
 	}
 }
 
+// flushIfIdle flushes a coalescing writer (packet.Flusher) once nothing is
+// queued, so frames written in one scheduling burst share a syscall without
+// waiting for the writer's idle timer.
+func (fw *FairWriter) flushIfIdle() {
+	f, ok := fw.writer.(packet.Flusher)
+	if !ok || len(fw.controlCh) > 0 || len(fw.readyQueue) > 0 {
+		return
+	}
+	f.Flush()
+}
+
 func (fw *FairWriter) drainControl() {
 	for {
 		select {
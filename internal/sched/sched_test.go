@@ -1,11 +1,13 @@
 package sched
 
 import (
+	"net"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/net-agent/flex/v3/packet"
+	"github.com/stretchr/testify/assert"
 )
 
 type MockWriter struct {
@@ -136,3 +138,31 @@ func TestFairness(t *testing.T) {
 		t.Errorf("fairness failure? max run length %d is too high", maxRun)
 	}
 }
+
+// FairWriter 在队列空闲时 flush，不需要等待写合并的定时器
+func TestFlushOnIdle(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	fc := NewFairConn(packet.NewBufferedConn(c1, packet.CoalesceConfig{IdleDelay: time.Hour}))
+	defer fc.Close()
+	r := packet.NewConnReader(c2)
+
+	for i := 0; i < 3; i++ {
+		buf := packet.NewBufferWithCmd(packet.CmdPushStreamData)
+		buf.SetSrcPort(uint16(i))
+		buf.SetPayload([]byte("data"))
+		assert.Nil(t, fc.WriteBuffer(buf))
+	}
+
+	r.SetReadTimeout(time.Second)
+	for i := 0; i < 3; i++ {
+		buf, err := r.ReadBuffer()
+		assert.Nil(t, err)
+		if err != nil {
+			return
+		}
+		assert.Equal(t, uint16(i), buf.SrcPort())
+	}
+}
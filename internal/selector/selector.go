@@ -0,0 +1,189 @@
+// Package selector implements node labels and the selector syntax used to
+// pick nodes by label, e.g. "role=db,region!=us,gpu,!legacy".
+package selector
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrInvalidLabel    = errors.New("invalid label")
+	ErrInvalidSelector = errors.New("invalid selector")
+)
+
+const (
+	maxKeyLen   = 63
+	maxValueLen = 255
+	maxLabels   = 64
+)
+
+type op int
+
+const (
+	opEqual op = iota
+	opNotEqual
+	opExists
+	opNotExists
+)
+
+type requirement struct {
+	key   string
+	op    op
+	value string
+}
+
+func (r requirement) matches(labels map[string]string) bool {
+	v, ok := labels[r.key]
+	switch r.op {
+	case opEqual:
+		return ok && v == r.value
+	case opNotEqual:
+		return !ok || v != r.value
+	case opExists:
+		return ok
+	default:
+		return !ok
+	}
+}
+
+func (r requirement) String() string {
+	switch r.op {
+	case opEqual:
+		return r.key + "=" + r.value
+	case opNotEqual:
+		return r.key + "!=" + r.value
+	case opExists:
+		return r.key
+	default:
+		return "!" + r.key
+	}
+}
+
+// Selector is a conjunction of label requirements. The zero value matches
+// every label set.
+type Selector struct {
+	reqs []requirement
+}
+
+// Parse parses a comma separated list of requirements:
+//
+//	key=value  key==value  key!=value  key  !key
+func Parse(s string) (Selector, error) {
+	var sel Selector
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return sel, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		r, err := parseRequirement(strings.TrimSpace(part))
+		if err != nil {
+			return Selector{}, err
+		}
+		sel.reqs = append(sel.reqs, r)
+	}
+	return sel, nil
+}
+
+func parseRequirement(s string) (requirement, error) {
+	var r requirement
+	switch {
+	case strings.HasPrefix(s, "!"):
+		r = requirement{key: strings.TrimSpace(s[1:]), op: opNotExists}
+	case strings.Contains(s, "!="):
+		k, v, _ := strings.Cut(s, "!=")
+		r = requirement{key: strings.TrimSpace(k), op: opNotEqual, value: strings.TrimSpace(v)}
+	case strings.Contains(s, "="):
+		k, v, _ := strings.Cut(s, "=")
+		r = requirement{key: strings.TrimSpace(k), op: opEqual, value: strings.TrimSpace(strings.TrimPrefix(v, "="))}
+	default:
+		r = requirement{key: s, op: opExists}
+	}
+
+	if !validKey(r.key) {
+		return r, ErrInvalidSelector
+	}
+	if (r.op == opEqual || r.op == opNotEqual) && !validValue(r.value) {
+		return r, ErrInvalidSelector
+	}
+	return r, nil
+}
+
+// Empty reports whether the selector has no requirement.
+func (sel Selector) Empty() bool { return len(sel.reqs) == 0 }
+
+// Matches reports whether labels satisfy every requirement.
+func (sel Selector) Matches(labels map[string]string) bool {
+	for _, r := range sel.reqs {
+		if !r.matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+func (sel Selector) String() string {
+	parts := make([]string, 0, len(sel.reqs))
+	for _, r := range sel.reqs {
+		parts = append(parts, r.String())
+	}
+	return strings.Join(parts, ",")
+}
+
+// ValidateLabels checks label keys and values. Keys may contain letters,
+// digits and "-_./"; values may contain letters, digits and "-_.".
+func ValidateLabels(labels map[string]string) error {
+	if len(labels) > maxLabels {
+		return ErrInvalidLabel
+	}
+	for k, v := range labels {
+		if !validKey(k) || !validValue(v) {
+			return ErrInvalidLabel
+		}
+	}
+	return nil
+}
+
+// Format returns labels as "k1=v1,k2=v2" with keys sorted, which is also a
+// selector matching exactly those labels.
+func Format(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, k+"="+labels[k])
+	}
+	return strings.Join(parts, ",")
+}
+
+func validKey(k string) bool {
+	if k == "" || len(k) > maxKeyLen {
+		return false
+	}
+	for _, c := range k {
+		if !isAlnum(c) && c != '-' && c != '_' && c != '.' && c != '/' {
+			return false
+		}
+	}
+	return true
+}
+
+func validValue(v string) bool {
+	if len(v) > maxValueLen {
+		return false
+	}
+	for _, c := range v {
+		if !isAlnum(c) && c != '-' && c != '_' && c != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+func isAlnum(c rune) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
@@ -0,0 +1,70 @@
+package selector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAndMatch(t *testing.T) {
+	labels := map[string]string{"role": "db", "region": "eu", "gpu": ""}
+
+	cases := []struct {
+		sel   string
+		match bool
+	}{
+		{"", true},
+		{"role=db", true},
+		{"role==db", true},
+		{"role=db,region=eu", true},
+		{" role = db , region = us ", false},
+		{"region!=us", true},
+		{"region!=eu", false},
+		{"zone!=a", true},
+		{"gpu", true},
+		{"ssd", false},
+		{"!legacy", true},
+		{"!gpu", false},
+		{"gpu=", true},
+	}
+	for _, c := range cases {
+		sel, err := Parse(c.sel)
+		if !assert.Nil(t, err, c.sel) {
+			continue
+		}
+		assert.Equal(t, c.match, sel.Matches(labels), c.sel)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, s := range []string{"=db", "role=d b", "a,,b", "!", "role=db,", "k/é=v", "a=b=c"} {
+		_, err := Parse(s)
+		assert.Equal(t, ErrInvalidSelector, err, s)
+	}
+}
+
+func TestSelectorString(t *testing.T) {
+	sel, err := Parse("role==db, region!=us,gpu , !legacy")
+	assert.Nil(t, err)
+	assert.Equal(t, "role=db,region!=us,gpu,!legacy", sel.String())
+	assert.False(t, sel.Empty())
+	assert.True(t, Selector{}.Empty())
+}
+
+func TestValidateLabels(t *testing.T) {
+	assert.Nil(t, ValidateLabels(nil))
+	assert.Nil(t, ValidateLabels(map[string]string{"app.kubernetes.io/name": "db-1", "gpu": ""}))
+	assert.Equal(t, ErrInvalidLabel, ValidateLabels(map[string]string{"": "x"}))
+	assert.Equal(t, ErrInvalidLabel, ValidateLabels(map[string]string{"role": "a,b"}))
+	assert.Equal(t, ErrInvalidLabel, ValidateLabels(map[string]string{"role": "a=b"}))
+}
+
+func TestFormat(t *testing.T) {
+	labels := map[string]string{"region": "eu", "role": "db"}
+	assert.Equal(t, "region=eu,role=db", Format(labels))
+
+	sel, err := Parse(Format(labels))
+	assert.Nil(t, err)
+	assert.True(t, sel.Matches(labels))
+	assert.Equal(t, "", Format(nil))
+}
@@ -0,0 +1,206 @@
+package node
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/net-agent/flex/v3/internal/idpool"
+	"github.com/net-agent/flex/v3/packet"
+	"github.com/net-agent/flex/v3/stream"
+)
+
+var (
+	ErrInvalidConfig = errors.New("invalid node config")
+)
+
+// Config holds the tunables of a Node. Zero fields take the value of
+// DefaultConfig, so a Config only needs the fields that differ. ReadTimeout
+// and AckDelay are the exceptions: zero is a valid setting for them (no read
+// deadline, ack on every read), so they take the default when negative.
+type Config struct {
+	// 本地端口范围，Dial 从中分配源端口
+	PortMin uint16
+	PortMax uint16
+
+	DialTimeout       time.Duration // 等待 open-stream 应答的时间
+	ReadTimeout       time.Duration // 底层连接无数据的最长时间，为 0 时不设超时，为负数时使用默认值
+	HeartbeatInterval time.Duration // 无写入超过该时间后探活
+	HeartbeatTimeout  time.Duration // 探活 ping 的超时时间
+
+	ListenBacklog int           // 每个 Listener 等待 Accept 的 stream 数
+	AcceptTimeout time.Duration // backlog 满时等待的时间，超时拒绝连接
+
+	CmdQueueSize  int // 无序控制包（OpenStream、DataAck）的分发队列长度
+	DataQueueSize int // 有序数据包的分发队列长度
+
+	CloseAckTimeout time.Duration // stream 关闭时等待对端确认的时间
+
+	// 数据确认的合并：累计读取 AckThreshold 字节或者经过 AckDelay 后发送一个 ACK。
+	// AckThreshold 为 1 或 AckDelay 为 0 时每次 Read 都立即确认，AckDelay 为负数时使用默认值
+	AckThreshold int32
+	AckDelay     time.Duration
+
+	Flow FlowConfig // 窗口大小，见 GetWindowSize
+
+	Logger *slog.Logger
+}
+
+// DefaultConfig returns the configuration used by New.
+func DefaultConfig() Config {
+	return Config{
+		PortMin:           1000,
+		PortMax:           0xffff,
+		DialTimeout:       15 * time.Second,
+		ReadTimeout:       15 * time.Minute,
+		HeartbeatInterval: DefaultHeartbeatInterval,
+		HeartbeatTimeout:  2 * time.Second,
+		ListenBacklog:     32,
+		AcceptTimeout:     listenerEnqueueTimeout,
+		CmdQueueSize:      4096,
+		DataQueueSize:     1024,
+		CloseAckTimeout:   2 * time.Second,
+		AckThreshold:      stream.DefaultAckThreshold,
+		AckDelay:          stream.DefaultAckDelay,
+	}
+}
+
+// withDefaults 用默认值补齐零值字段
+func (c Config) withDefaults() Config {
+	def := DefaultConfig()
+	if c.PortMin == 0 && c.PortMax == 0 {
+		c.PortMin, c.PortMax = def.PortMin, def.PortMax
+	}
+	setDefault(&c.DialTimeout, def.DialTimeout)
+	setUnset(&c.ReadTimeout, def.ReadTimeout)
+	setDefault(&c.HeartbeatInterval, def.HeartbeatInterval)
+	setDefault(&c.HeartbeatTimeout, def.HeartbeatTimeout)
+	setDefault(&c.ListenBacklog, def.ListenBacklog)
+	setDefault(&c.AcceptTimeout, def.AcceptTimeout)
+	setDefault(&c.CmdQueueSize, def.CmdQueueSize)
+	setDefault(&c.DataQueueSize, def.DataQueueSize)
+	setDefault(&c.CloseAckTimeout, def.CloseAckTimeout)
+	setDefault(&c.AckThreshold, def.AckThreshold)
+	setUnset(&c.AckDelay, def.AckDelay)
+	if c.Logger == nil {
+		c.Logger = slog.Default()
+	}
+	return c
+}
+
+func setDefault[T int | int32 | time.Duration](v *T, def T) {
+	if *v == 0 {
+		*v = def
+	}
+}
+
+// setUnset 用于 0 有意义的字段，负数表示未设置
+func setUnset(v *time.Duration, def time.Duration) {
+	if *v < 0 {
+		*v = def
+	}
+}
+
+// Validate reports whether the configuration can be used. Zero fields are
+// valid and take their defaults.
+func (c Config) Validate() error {
+	c = c.withDefaults()
+	switch {
+	case c.PortMin == 0 || c.PortMin > c.PortMax:
+		return errors.Join(ErrInvalidConfig, errors.New("port range"))
+	case c.DialTimeout < 0, c.HeartbeatInterval < 0,
+		c.HeartbeatTimeout < 0, c.AcceptTimeout < 0, c.CloseAckTimeout < 0:
+		return errors.Join(ErrInvalidConfig, errors.New("negative timeout"))
+	case c.ListenBacklog < 0, c.CmdQueueSize < 0, c.DataQueueSize < 0, c.AckThreshold < 0:
+		return errors.Join(ErrInvalidConfig, errors.New("negative size"))
+	case c.Flow.MaxWindowSize < 0, c.Flow.MinWindowSize < 0, c.Flow.Bandwidth < 0, c.Flow.RTT < 0,
+		c.Flow.AutoTune && c.Flow.MaxWindowSize > 0 && c.Flow.MinWindowSize > c.Flow.MaxWindowSize:
+		return errors.Join(ErrInvalidConfig, errors.New("flow config"))
+	}
+	return nil
+}
+
+// Option changes one field of a Config.
+type Option func(*Config)
+
+// WithPortRange sets the range local ports are allocated from.
+func WithPortRange(min, max uint16) Option {
+	return func(c *Config) { c.PortMin, c.PortMax = min, max }
+}
+
+// WithDialTimeout sets how long a dial waits for the open-stream ack.
+func WithDialTimeout(d time.Duration) Option {
+	return func(c *Config) { c.DialTimeout = d }
+}
+
+// WithReadTimeout sets how long the connection may stay without data. Zero
+// disables the read deadline.
+func WithReadTimeout(d time.Duration) Option {
+	return func(c *Config) { c.ReadTimeout = d }
+}
+
+// WithHeartbeat sets the idle interval before a ping and the ping timeout.
+func WithHeartbeat(interval, timeout time.Duration) Option {
+	return func(c *Config) { c.HeartbeatInterval, c.HeartbeatTimeout = interval, timeout }
+}
+
+// WithListenBacklog sets the streams queued per listener and how long a new
+// stream waits for room before it is rejected.
+func WithListenBacklog(backlog int, timeout time.Duration) Option {
+	return func(c *Config) { c.ListenBacklog, c.AcceptTimeout = backlog, timeout }
+}
+
+// WithQueueSizes sets the lengths of the dispatcher queues.
+func WithQueueSizes(cmd, data int) Option {
+	return func(c *Config) { c.CmdQueueSize, c.DataQueueSize = cmd, data }
+}
+
+// WithCloseAckTimeout sets how long closing a stream waits for the peer.
+func WithCloseAckTimeout(d time.Duration) Option {
+	return func(c *Config) { c.CloseAckTimeout = d }
+}
+
+// WithAckCoalescing sets how many bytes a stream reads, or how long it waits,
+// before it sends one data ack. A threshold of 1 or a delay of 0 acks every
+// read.
+func WithAckCoalescing(threshold int32, delay time.Duration) Option {
+	return func(c *Config) { c.AckThreshold, c.AckDelay = threshold, delay }
+}
+
+// WithFlowConfig sets the window size negotiation of new streams.
+func WithFlowConfig(flow FlowConfig) Option {
+	return func(c *Config) { c.Flow = flow }
+}
+
+// WithWindowSize sets a fixed maximum window size for new streams.
+func WithWindowSize(size int32) Option {
+	return func(c *Config) { c.Flow = FlowConfig{MaxWindowSize: size} }
+}
+
+// WithWindowAutoTune makes new streams tune their receive window between
+// minSize and maxSize. Zero sizes take stream.DefaultMinWindowSize and
+// stream.DefaultMaxWindowSize.
+func WithWindowAutoTune(minSize, maxSize int32) Option {
+	return func(c *Config) {
+		c.Flow = FlowConfig{AutoTune: true, MinWindowSize: minSize, MaxWindowSize: maxSize}
+	}
+}
+
+// WithLogger sets the logger of the node.
+func WithLogger(l *slog.Logger) Option {
+	return func(c *Config) { c.Logger = l }
+}
+
+// NewWithConfig creates a node on conn with cfg. Zero fields of cfg take
+// their defaults.
+func NewWithConfig(conn packet.Conn, cfg Config) (*Node, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	cfg = cfg.withDefaults()
+	portm, err := idpool.New(cfg.PortMin, cfg.PortMax)
+	if err != nil {
+		return nil, err
+	}
+	return newNode(conn, portm, cfg), nil
+}
@@ -0,0 +1,191 @@
+package node
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/net-agent/flex/v3/packet"
+	"github.com/net-agent/flex/v3/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigDefaults(t *testing.T) {
+	cfg := Config{ReadTimeout: -1, AckDelay: -1}.withDefaults()
+	def := DefaultConfig()
+	def.Logger = cfg.Logger
+	assert.Equal(t, def, cfg)
+	assert.Nil(t, Config{}.Validate())
+	assert.Nil(t, DefaultConfig().Validate())
+
+	cfg = Config{DialTimeout: time.Second, ReadTimeout: -1}.withDefaults()
+	assert.Equal(t, time.Second, cfg.DialTimeout)
+	assert.Equal(t, def.ReadTimeout, cfg.ReadTimeout)
+
+	// ReadTimeout 和 AckDelay 的 0 是有效的设置
+	cfg = Config{}.withDefaults()
+	assert.Equal(t, time.Duration(0), cfg.ReadTimeout)
+	assert.Equal(t, time.Duration(0), cfg.AckDelay)
+}
+
+func TestConfigValidate(t *testing.T) {
+	cases := []Config{
+		{PortMin: 2000, PortMax: 1000},
+		{PortMin: 0, PortMax: 1000},
+		{DialTimeout: -1},
+		{ListenBacklog: -1},
+		{CmdQueueSize: -1},
+		{Flow: FlowConfig{MaxWindowSize: -1}},
+		{Flow: FlowConfig{AutoTune: true, MinWindowSize: 2 << 20, MaxWindowSize: 1 << 20}},
+		{AckThreshold: -1},
+	}
+	for _, c := range cases {
+		err := c.Validate()
+		assert.True(t, errors.Is(err, ErrInvalidConfig), "%+v", c)
+
+		_, err = NewWithConfig(nil, c)
+		assert.True(t, errors.Is(err, ErrInvalidConfig))
+	}
+
+	// 非法配置不会回退到默认值继续运行，New 不 panic，由 Serve 返回错误
+	pc1, pc2 := packet.Pipe()
+	defer pc1.Close()
+	defer pc2.Close()
+	var n *Node
+	assert.NotPanics(t, func() { n = New(pc1, WithListenBacklog(-1, 0)) })
+	assert.True(t, errors.Is(n.Serve(), ErrInvalidConfig))
+
+	sess := NewSession(nil, SessionConfig{Options: []Option{WithDialTimeout(-1)}})
+	_, err := sess.Listen(80)
+	assert.True(t, errors.Is(err, ErrInvalidConfig))
+	assert.True(t, errors.Is(sess.Serve(), ErrInvalidConfig))
+
+	n = New(nil, WithAckCoalescing(1, 0), WithReadTimeout(0))
+	assert.Equal(t, int32(1), n.GetConfig().AckThreshold)
+	assert.Equal(t, time.Duration(0), n.GetConfig().AckDelay)
+	assert.Equal(t, time.Duration(0), n.GetConfig().ReadTimeout)
+	n = New(nil)
+	assert.Equal(t, stream.DefaultAckDelay, n.GetConfig().AckDelay)
+}
+
+func TestNewWithOptions(t *testing.T) {
+	c1, c2 := packet.Pipe()
+	n1 := New(c1,
+		WithPortRange(5000, 5001),
+		WithDialTimeout(time.Second),
+		WithListenBacklog(2, 10*time.Millisecond),
+		WithWindowSize(64*1024),
+	)
+	n2 := New(c2)
+	n1.SetDomain("test1")
+	n1.SetIP(1)
+	n2.SetDomain("test2")
+	n2.SetIP(2)
+	go n1.Serve()
+	go n2.Serve()
+	defer n1.Close()
+	defer n2.Close()
+
+	assert.Equal(t, time.Second, n1.Dialer.timeout)
+	assert.Equal(t, int32(64*1024), n1.GetWindowSize())
+
+	l, err := n1.Listen(80)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, 2, cap(l.(*Listener).streams))
+
+	// 每个节点的配置相互独立
+	assert.Equal(t, DefaultConfig().ListenBacklog, n2.GetConfig().ListenBacklog)
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+	l2, err := n2.Listen(80)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer l2.Close()
+
+	// 源端口从配置的范围分配，用尽后拨号失败
+	s1, err := n1.Dial("test2:80")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer s1.Close()
+	s2, err := n1.Dial("test2:80")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer s2.Close()
+	for _, s := range []interface{ LocalAddr() net.Addr }{s1, s2} {
+		_, port, _ := net.SplitHostPort(s.LocalAddr().String())
+		assert.Contains(t, []string{"5000", "5001"}, port)
+	}
+	_, err = n1.Dial("test2:80")
+	assert.NotNil(t, err)
+}
+
+func TestWindowAutoTune(t *testing.T) {
+	c1, c2 := packet.Pipe()
+	n1 := New(c1)
+	n2 := New(c2, WithWindowAutoTune(64*1024, 8<<20))
+	n1.SetDomain("test1")
+	n1.SetIP(1)
+	n2.SetDomain("test2")
+	n2.SetIP(2)
+	go n1.Serve()
+	go n2.Serve()
+	defer n1.Close()
+	defer n2.Close()
+
+	assert.Equal(t, stream.DefaultWindowSize, n2.GetWindowSize())
+	assert.Equal(t, uint32(8<<20), n2.maxWindowSize())
+	assert.Equal(t, uint32(stream.DefaultWindowSize), n1.maxWindowSize())
+
+	// n2 作为接收端自动调整窗口，n1 的窗口固定
+	l, err := n2.Listen(80)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer l.Close()
+	accepted := make(chan *stream.Stream, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		io.Copy(io.Discard, c)
+		accepted <- c.(*stream.Stream)
+		c.Close()
+	}()
+
+	s, err := n1.DialDomain("test2", 80)
+	if !assert.Nil(t, err) {
+		return
+	}
+	_, err = s.Write(make([]byte, 8<<20))
+	assert.Nil(t, err)
+	s.CloseWrite()
+
+	select {
+	case c := <-accepted:
+		st := c.GetState()
+		assert.Greater(t, st.RTT, time.Duration(0), "receive window is tuned")
+		assert.GreaterOrEqual(t, st.Window, int32(64*1024))
+		assert.LessOrEqual(t, st.Window, int32(8<<20))
+	case <-time.After(5 * time.Second):
+		t.Fatal("transfer not finished")
+	}
+	st := s.GetState()
+	assert.Equal(t, stream.DefaultWindowSize, st.Window)
+	assert.Equal(t, time.Duration(0), st.RTT)
+}
@@ -1,14 +1,17 @@
 package node
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
 	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/net-agent/flex/v3/internal/idpool"
 	"github.com/net-agent/flex/v3/internal/pending"
+	"github.com/net-agent/flex/v3/internal/selector"
 	"github.com/net-agent/flex/v3/packet"
 	"github.com/net-agent/flex/v3/stream"
 )
@@ -20,68 +23,190 @@ var (
 	ErrWaitResponseTimeout   = errors.New("wait dial response timeout")
 	ErrWriteDialPbufFailed   = errors.New("write dial buffer failed")
 	ErrUnexpectedNilResponse = errors.New("unexpected nil response")
+	ErrDomainNotFound        = errors.New("domain not found")
 )
 
 type Dialer struct {
-	host    *Node
-	portm   *idpool.Pool
-	timeout time.Duration
-	pending pending.Requests[*stream.Stream]
+	host     *Node
+	portm    *idpool.Pool
+	timeout  time.Duration
+	pending  pending.Requests[*stream.Stream]
+	resolver atomic.Pointer[Resolver]
 }
 
 func (d *Dialer) init(host *Node, portm *idpool.Pool) {
 	d.host = host
 	d.portm = portm
-	d.SetDialTimeout(time.Second * 15)
+	d.SetDialTimeout(host.config.DialTimeout)
 }
 
 func (d *Dialer) SetDialTimeout(timeout time.Duration) { d.timeout = timeout }
 
+// SetResolver 设置 Dial 使用的解析器，r 为 nil 时由 switcher 在每次连接时解析域名
+func (d *Dialer) SetResolver(r *Resolver) { d.resolver.Store(r) }
+
 // Dial 通过address信息创建新的连接
 func (d *Dialer) Dial(addr string) (*stream.Stream, error) {
+	return d.dialAddr(context.Background(), addr)
+}
+
+// DialContext dials a flex address with net.Dialer semantics, so the node can
+// back http.Transport, gRPC dialers and database drivers. network must be
+// "tcp", "tcp4", "tcp6" or "flex"; a HostSuffix on the host is removed.
+// Cancelling ctx abandons the dial and releases its port; without a ctx
+// deadline the dial timeout still applies.
+func (d *Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	addr, err := flexAddr(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	s, err := d.dialAddr(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (d *Dialer) dialAddr(ctx context.Context, addr string) (*stream.Stream, error) {
 	isDomain, domain, ip, port, err := parseAddress(addr)
 	if err != nil {
 		return nil, err
 	}
 
 	if isDomain {
-		return d.DialDomain(domain, port)
+		if r := d.resolver.Load(); r != nil && !d.isLocalDomain(domain) {
+			return d.dialResolved(ctx, r, domain, port)
+		}
+		return d.DialDomainContext(ctx, domain, port)
 	}
 
-	return d.DialIP(ip, port)
+	return d.DialIPContext(ctx, ip, port)
+}
+
+func (d *Dialer) isLocalDomain(domain string) bool {
+	return domain == d.host.domain || domain == "local" || domain == "localhost"
+}
+
+// dialResolved 先通过解析器得到 IP 再连接。解析失败时（如经中继访问上游的域名）
+// 仍由 switcher 按域名转发；连接失败时丢弃缓存，下次重新解析。
+func (d *Dialer) dialResolved(ctx context.Context, r *Resolver, domain string, port uint16) (*stream.Stream, error) {
+	record, err := r.Resolve(domain)
+	if err != nil {
+		return d.DialDomainContext(ctx, domain, port)
+	}
+	s, err := d.DialIPContext(ctx, record.IP, port)
+	if err != nil {
+		if ctx.Err() == nil {
+			r.Invalidate(domain)
+		}
+		return nil, err
+	}
+	s.SetRemoteDomain(domain)
+	return s, nil
 }
 
 // DialDomain 通过domain信息进行dial
 func (d *Dialer) DialDomain(domain string, port uint16) (*stream.Stream, error) {
-	if domain == d.host.domain || domain == "local" || domain == "localhost" {
-		return d.DialIP(d.host.GetIP(), port)
+	return d.DialDomainContext(context.Background(), domain, port)
+}
+
+// DialDomainContext 与 DialDomain 相同，ctx 取消时放弃等待并回收端口
+func (d *Dialer) DialDomainContext(ctx context.Context, domain string, port uint16) (*stream.Stream, error) {
+	if d.isLocalDomain(domain) {
+		return d.DialIPContext(ctx, d.host.GetIP(), port)
+	}
+
+	md := MetadataFromContext(ctx)
+	if err := packet.ValidateMetadata(md); err != nil {
+		return nil, err
+	}
+
+	pbuf := packet.NewBuffer()
+	pbuf.SetCmd(packet.CmdOpenStream)
+	pbuf.SetSrc(d.host.GetIP(), 0)
+	pbuf.SetDist(packet.SwitcherIP, port)
+	req := packet.OpenStreamRequest{Domain: domain, WindowSize: uint32(d.host.GetWindowSize()), Metadata: md, MaxWindowSize: d.host.maxWindowSize()}
+	_ = pbuf.SetPayload(req.Encode())
+	return d.dialPbuf(ctx, pbuf)
+}
+
+// DialSelector 连接任意一个标签与 sel 匹配的节点（如 "role=db,region=eu"），
+// 由 switcher 选择目标节点。返回的 stream 以所选节点的域名作为 RemoteDomain，
+// 旧版本的 switcher 不返回域名，此时为对端 IP。
+func (d *Dialer) DialSelector(sel string, port uint16) (*stream.Stream, error) {
+	return d.DialSelectorContext(context.Background(), sel, port)
+}
+
+// DialSelectorContext 与 DialSelector 相同，ctx 取消时放弃等待并回收端口
+func (d *Dialer) DialSelectorContext(ctx context.Context, sel string, port uint16) (*stream.Stream, error) {
+	parsed, err := selector.Parse(sel)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Empty() {
+		return nil, selector.ErrInvalidSelector
+	}
+	md := MetadataFromContext(ctx)
+	if err := packet.ValidateMetadata(md); err != nil {
+		return nil, err
 	}
 
 	pbuf := packet.NewBuffer()
 	pbuf.SetCmd(packet.CmdOpenStream)
 	pbuf.SetSrc(d.host.GetIP(), 0)
 	pbuf.SetDist(packet.SwitcherIP, port)
-	req := packet.OpenStreamRequest{Domain: domain, WindowSize: uint32(d.host.GetWindowSize())}
+	req := packet.OpenStreamRequest{WindowSize: uint32(d.host.GetWindowSize()), Selector: parsed.String(), Metadata: md, MaxWindowSize: d.host.maxWindowSize()}
 	_ = pbuf.SetPayload(req.Encode())
-	return d.dialPbuf(pbuf)
+	return d.dialPbuf(ctx, pbuf)
 }
 
 // DialIP 通过IP信息进行dial
 func (d *Dialer) DialIP(ip, port uint16) (*stream.Stream, error) {
+	return d.DialIPContext(context.Background(), ip, port)
+}
+
+// DialIPContext 与 DialIP 相同，ctx 取消时放弃等待并回收端口
+func (d *Dialer) DialIPContext(ctx context.Context, ip, port uint16) (*stream.Stream, error) {
+	md := MetadataFromContext(ctx)
+	if err := packet.ValidateMetadata(md); err != nil {
+		return nil, err
+	}
+
 	pbuf := packet.NewBuffer()
 	pbuf.SetCmd(packet.CmdOpenStream)
 	pbuf.SetSrc(d.host.GetIP(), 0)
 	pbuf.SetDist(ip, port)
-	req := packet.OpenStreamRequest{WindowSize: uint32(d.host.GetWindowSize())}
+	req := packet.OpenStreamRequest{WindowSize: uint32(d.host.GetWindowSize()), Metadata: md, MaxWindowSize: d.host.maxWindowSize()}
 	_ = pbuf.SetPayload(req.Encode())
-	return d.dialPbuf(pbuf)
+	return d.dialPbuf(ctx, pbuf)
+}
+
+type metadataKey struct{}
+
+// WithMetadata 返回携带 md 的 ctx，用该 ctx 发起的 DialContext、DialDomainContext、
+// DialIPContext、DialSelectorContext 会把 md 附带在 open 请求中，对端通过 Stream.Metadata 读取。
+// md 编码后不能超过 packet.MaxMetadataSize。
+func WithMetadata(ctx context.Context, md map[string]string) context.Context {
+	return context.WithValue(ctx, metadataKey{}, md)
+}
+
+// MetadataFromContext 返回 WithMetadata 设置的元数据
+func MetadataFromContext(ctx context.Context) map[string]string {
+	md, _ := ctx.Value(metadataKey{}).(map[string]string)
+	return md
 }
 
 // DialPbuf dial的底层实现
 // 注意：pbuf里的srcPort还需要在writeBuffer前进行确认
-func (d *Dialer) dialPbuf(pbuf *packet.Buffer) (*stream.Stream, error) {
-	remoteDomain := string(pbuf.Payload)
-	if remoteDomain == "" {
+// ctx 没有 deadline 时使用 SetDialTimeout 设置的超时
+func (d *Dialer) dialPbuf(ctx context.Context, pbuf *packet.Buffer) (*stream.Stream, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	req := packet.DecodeOpenStreamRequest(pbuf.Payload)
+	remoteDomain := req.Domain
+	if remoteDomain == "" && req.Selector == "" {
 		distIP := pbuf.DistIP()
 		if distIP == d.host.ip {
 			remoteDomain = d.host.domain
@@ -118,6 +243,12 @@ func (d *Dialer) dialPbuf(pbuf *packet.Buffer) (*stream.Stream, error) {
 	}
 
 	// 第四步：等待ack返回（设置超时时间）
+	var timeout <-chan time.Time
+	if _, ok := ctx.Deadline(); !ok {
+		timer := time.NewTimer(d.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
 	select {
 	case res, ok := <-ch:
 		if !ok {
@@ -127,20 +258,83 @@ func (d *Dialer) dialPbuf(pbuf *packet.Buffer) (*stream.Stream, error) {
 			return nil, res.Err
 		}
 		s := res.Val
+		if remoteDomain == "" {
+			// 按选择器连接时，目标节点在 ack 到达后才确定，域名由 switcher 在 ack 中给出
+			remoteDomain = s.GetState().RemoteDomain
+		}
+		if remoteDomain == "" {
+			// 旧版本的 switcher 不返回域名
+			remoteDomain = fmt.Sprintf("%v", s.GetState().RemoteAddr.IP)
+		}
 		s.SetRemoteDomain(remoteDomain)
 		srcPort = 0 // 端口所有权转移给stream，阻止defer释放
 		return s, nil
-	case <-time.After(d.timeout):
+	case <-ctx.Done():
+		if d.abandon(srcPort, ch) {
+			srcPort = 0
+		}
+		return nil, ctx.Err()
+	case <-timeout:
+		if d.abandon(srcPort, ch) {
+			srcPort = 0
+		}
 		return nil, pending.ErrTimeout
 	}
 }
 
+// abandon 放弃等待中的 dial。ack 可能与取消同时到达，此时关闭已创建的 stream，
+// 端口随 stream 回收，返回 true
+func (d *Dialer) abandon(srcPort uint16, ch <-chan pending.Result[*stream.Stream]) bool {
+	d.pending.Remove(srcPort)
+	res, ok := <-ch
+	if !ok || res.Err != nil || res.Val == nil {
+		return false
+	}
+	go res.Val.Close()
+	return true
+}
+
+// OpenError 是对端拒绝 open 请求时 Dial 返回的错误，可用 errors.Is 与
+// ErrListenerNotFound、ErrListenerBacklogFull、ErrDomainNotFound、ErrAccessDenied 等比较
+type OpenError struct {
+	Code    packet.ErrorCode
+	Message string
+}
+
+func (e *OpenError) Error() string {
+	if e.Message == "" {
+		return e.Code.String()
+	}
+	return e.Message
+}
+
+func (e *OpenError) Unwrap() error { return codeErrors[e.Code] }
+
+var codeErrors = map[packet.ErrorCode]error{
+	packet.CodeListenerNotFound: ErrListenerNotFound,
+	packet.CodeListenerClosed:   ErrListenerClosed,
+	packet.CodeBacklogFull:      ErrListenerBacklogFull,
+	packet.CodeDomainNotFound:   ErrDomainNotFound,
+	packet.CodeAccessDenied:     ErrAccessDenied,
+	packet.CodeStreamExists:     ErrSidIsAttached,
+}
+
+// errorCode 返回 err 在 open 应答中对应的错误码
+func errorCode(err error) packet.ErrorCode {
+	for code, target := range codeErrors {
+		if errors.Is(err, target) {
+			return code
+		}
+	}
+	return packet.CodeInternal
+}
+
 func (d *Dialer) handleAckOpenStream(pbuf *packet.Buffer) {
 	evKey := pbuf.DistPort()
 	ack := packet.DecodeOpenStreamACK(pbuf.Payload)
 
 	if !ack.OK {
-		err := d.pending.Complete(evKey, nil, errors.New(ack.Error))
+		err := d.pending.Complete(evKey, nil, &OpenError{Code: ack.Code, Message: ack.Error})
 		if err != nil {
 			d.host.logger.Warn("dispatch ack-msg failed", "error", err)
 		}
@@ -155,9 +349,10 @@ func (d *Dialer) handleAckOpenStream(pbuf *packet.Buffer) {
 	s := stream.NewDialStream(
 		d.host,
 		d.host.domain, pbuf.DistIP(), pbuf.DistPort(),
-		"", pbuf.SrcIP(), pbuf.SrcPort(),
+		ack.Domain, pbuf.SrcIP(), pbuf.SrcPort(),
 		negotiatedWindowSize,
 	)
+	d.host.setupStream(s, ack.MaxWindowSize)
 
 	err := d.host.attachStream(s, pbuf.SID())
 	if err != nil {
@@ -173,9 +368,9 @@ func (d *Dialer) handleAckOpenStream(pbuf *packet.Buffer) {
 	if err != nil {
 		if errors.Is(err, pending.ErrNotFound) {
 			// Dial caller already timed out/cancelled. Reclaim the stream immediately
-			// to avoid orphaned entries staying in StreamHub.
-			_ = s.CloseRead()
-			_ = s.CloseWrite()
+			// to avoid orphaned entries staying in StreamHub, and reset the
+			// peer's side which has already been accepted.
+			_ = s.Reset(packet.CodeCanceled)
 			d.host.logger.Warn("late open-stream ack discarded", "sid", pbuf.SIDStr())
 			return
 		}
@@ -2,7 +2,9 @@ package node
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -14,6 +16,7 @@ import (
 	"github.com/net-agent/flex/v3/internal/idpool"
 	"github.com/net-agent/flex/v3/internal/pending"
 	"github.com/net-agent/flex/v3/packet"
+	"github.com/net-agent/flex/v3/stream"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -268,7 +271,7 @@ func TestDialBufErr_portmAndRepsonse(t *testing.T) {
 	// d1.responses.Store(uint16(1), nil)
 	d1.pending.Register(uint16(1))
 	pbuf := packet.NewBuffer()
-	_, err = d1.dialPbuf(pbuf)
+	_, err = d1.dialPbuf(context.Background(), pbuf)
 	assert.Equal(t, pending.ErrAlreadyRegistered, err)
 
 	// 测试用例：提前把free port申请完，触发无可用端口错误
@@ -278,7 +281,7 @@ func TestDialBufErr_portmAndRepsonse(t *testing.T) {
 			break
 		}
 	}
-	_, err = d1.dialPbuf(pbuf)
+	_, err = d1.dialPbuf(context.Background(), pbuf)
 	assert.NotNil(t, err)
 
 }
@@ -300,12 +303,128 @@ func TestDialBuffErr_timeoutAndWriteFailed(t *testing.T) {
 	pbuf := packet.NewBuffer()
 	pbuf.SetDistIP(0) // 默认0，会进入本地循环，触发timeout
 	d1.SetDialTimeout(time.Millisecond * 100)
-	_, err = d1.dialPbuf(pbuf)
+	_, err = d1.dialPbuf(context.Background(), pbuf)
 	assert.Equal(t, pending.ErrTimeout, err)
 
 	// 测试用例：关闭pipe，触发write错误
 	// portm(2)
 	pbuf.SetDistIP(1) // 会通过pipe发送，触发writetimeout
-	_, err = d1.dialPbuf(pbuf)
+	_, err = d1.dialPbuf(context.Background(), pbuf)
 	assert.Equal(t, ErrWriteDialPbufFailed, err)
 }
+
+func TestDialSelector_Invalid(t *testing.T) {
+	n := New(nil)
+	_, err := n.DialSelector("", 80)
+	assert.NotNil(t, err)
+	_, err = n.DialSelector("a b", 80)
+	assert.NotNil(t, err)
+}
+
+func TestDialContextCancel(t *testing.T) {
+	// 对端只读取不应答
+	c1, c2 := packet.Pipe()
+	go func() {
+		for {
+			if _, err := c2.ReadBuffer(); err != nil {
+				return
+			}
+		}
+	}()
+	portm, _ := idpool.New(1000, 0xFFFF)
+	n := NewWithOptions(c1, portm, DefaultHeartbeatInterval)
+	n.SetIP(1)
+	go n.Serve()
+	defer n.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		_, err := n.DialDomainContext(ctx, "test2", 80)
+		errs <- err
+	}()
+	assert.Eventually(t, func() bool { return portm.InUse() == 1 }, time.Second, time.Millisecond)
+	cancel()
+	select {
+	case err := <-errs:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(time.Second):
+		t.Fatal("dial not cancelled")
+	}
+
+	// 取消后 pending 与端口都已回收
+	assert.Equal(t, 0, portm.InUse())
+	assert.Equal(t, pending.ErrNotFound, n.Dialer.pending.Complete(1000, nil, nil))
+
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := n.DialIPContext(ctx, 2, 80)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, 0, portm.InUse())
+}
+
+func TestDialMetadata(t *testing.T) {
+	n1, n2 := Pipe("test1", "test2")
+	defer n1.Close()
+	defer n2.Close()
+
+	l, err := n2.Listen(80)
+	if !assert.Nil(t, err) {
+		return
+	}
+	var authorized map[string]string
+	l.(*Listener).SetAuthorizer(func(_ string, _ uint16, md map[string]string) error {
+		authorized = md
+		return nil
+	})
+
+	md := map[string]string{"token": "secret"}
+	assert.Equal(t, md, MetadataFromContext(WithMetadata(context.Background(), md)))
+	assert.Nil(t, MetadataFromContext(context.Background()))
+
+	go func() {
+		_, err := n1.DialContext(WithMetadata(context.Background(), md), "tcp", "test2:80")
+		assert.Nil(t, err)
+	}()
+	c, err := l.Accept()
+	if assert.Nil(t, err) {
+		assert.Equal(t, md, c.(*stream.Stream).Metadata())
+		assert.Equal(t, md, authorized)
+		c.Close()
+	}
+
+	// 不带元数据
+	go n1.DialIP(2, 80)
+	c, err = l.Accept()
+	if assert.Nil(t, err) {
+		assert.Nil(t, c.(*stream.Stream).Metadata())
+		c.Close()
+	}
+
+	big := map[string]string{"k": string(make([]byte, packet.MaxMetadataSize))}
+	_, err = n1.DialIPContext(WithMetadata(context.Background(), big), 2, 80)
+	assert.Equal(t, packet.ErrMetadataTooLarge, err)
+}
+
+func TestOpenErrorCodes(t *testing.T) {
+	n1, n2 := Pipe("test1", "test2")
+	defer n1.Close()
+	defer n2.Close()
+
+	_, err := n1.Dial("test2:81")
+	assert.ErrorIs(t, err, ErrListenerNotFound)
+	var oe *OpenError
+	if assert.ErrorAs(t, err, &oe) {
+		assert.Equal(t, packet.CodeListenerNotFound, oe.Code)
+		assert.Equal(t, ErrListenerNotFound.Error(), oe.Error())
+	}
+
+	// 旧版本对端的纯文本错误没有对应的哨兵错误
+	err = &OpenError{Code: packet.CodeUnknown, Message: "some failure"}
+	assert.Equal(t, "some failure", err.Error())
+	assert.False(t, errors.Is(err, ErrListenerNotFound))
+	assert.Equal(t, "access denied", (&OpenError{Code: packet.CodeAccessDenied}).Error())
+
+	assert.Equal(t, packet.CodeAccessDenied, errorCode(fmt.Errorf("%w: x", ErrAccessDenied)))
+	assert.Equal(t, packet.CodeInternal, errorCode(errors.New("x")))
+}
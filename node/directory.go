@@ -0,0 +1,68 @@
+package node
+
+import "github.com/net-agent/flex/v3/packet"
+
+// PresenceHandler 处理 switcher 推送的上下线事件。在节点的命令处理循环中调用，
+// 不能阻塞，也不能在其中调用 Request 等需要等待应答的方法。
+type PresenceHandler func(ev packet.PresenceEvent)
+
+// ListDirectory 返回 switcher 上 IP 大于 q.After 的在线节点，按 IP 排序。
+// switcher 按页应答，q.Limit 为每页的数量（0 表示不限，由应答大小决定），本方法会取完所有页
+func (node *Node) ListDirectory(q packet.DirectoryQuery) ([]packet.NodeRecord, error) {
+	var records []packet.NodeRecord
+	for first := true; ; first = false {
+		var page []packet.NodeRecord
+		err := node.Request(packet.TopicDirectoryList, q, &page, DefaultRequestTimeout)
+		if err != nil {
+			return nil, err
+		}
+		// 空页表示已取完。旧版本的 switcher 不分页，每次都返回完整列表
+		if len(page) == 0 || !first && page[0].IP <= q.After {
+			return records, nil
+		}
+		records = append(records, page...)
+		q.After = page[len(page)-1].IP
+	}
+}
+
+// AdvertisePorts 设置节点在目录中公布的端口，替换之前公布的端口
+func (node *Node) AdvertisePorts(ports ...uint16) error {
+	return node.Request(packet.TopicDirectoryAdvertise, packet.DirectoryAdvertisement{Ports: ports}, nil, DefaultRequestTimeout)
+}
+
+// SubscribePresence 订阅 domains 的上下线事件，domains 为空时订阅所有节点。
+// 返回订阅范围内当前在线的节点，之后的变化通过 fn 通知（可能与返回值重复）。
+// fn 为 nil 时沿用之前设置的处理函数。
+func (node *Node) SubscribePresence(fn PresenceHandler, domains ...string) ([]packet.PresenceEvent, error) {
+	if fn != nil {
+		node.HandleMessage(packet.TopicPresenceEvent, func(msg *packet.Message) {
+			var ev packet.PresenceEvent
+			if err := msg.Unmarshal(&ev); err != nil {
+				node.logger.Warn("decode presence event failed", "error", err)
+				return
+			}
+			fn(ev)
+		})
+	}
+
+	var online []packet.PresenceEvent
+	req := packet.PresenceSubscription{Domains: domains}
+	for first := true; ; first = false {
+		var page []packet.PresenceEvent
+		err := node.Request(packet.TopicPresenceSubscribe, req, &page, DefaultRequestTimeout)
+		if err != nil {
+			return nil, err
+		}
+		// 分页方式与 ListDirectory 相同，重复订阅相同的 domains 不影响已有的订阅
+		if len(page) == 0 || !first && page[0].IP <= req.After {
+			return online, nil
+		}
+		online = append(online, page...)
+		req.After = page[len(page)-1].IP
+	}
+}
+
+// UnsubscribePresence 取消 domains 的订阅，domains 为空时取消所有订阅
+func (node *Node) UnsubscribePresence(domains ...string) error {
+	return node.Request(packet.TopicPresenceUnsubscribe, packet.PresenceSubscription{Domains: domains}, nil, DefaultRequestTimeout)
+}
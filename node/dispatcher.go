@@ -17,16 +17,22 @@ type Dispatcher struct {
 	dataHandlers map[byte]func(*packet.Buffer)
 	logger       *slog.Logger
 	domain       string // for tracing
+	cmdSize      int
+	dataSize     int
 }
 
 func (d *Dispatcher) init(host *Node) {
 	d.logger = host.logger
 	d.domain = host.domain
+	d.cmdSize = host.config.CmdQueueSize
+	d.dataSize = host.config.DataQueueSize
 	d.cmdHandlers = map[byte]func(*packet.Buffer){
 		packet.AckPushStreamData: host.StreamHub.handleAckPushStreamData,
 		packet.CmdOpenStream:     host.ListenHub.handleCmdOpenStream,
 		packet.CmdPingDomain:     host.Pinger.handleCmdPingDomain,
 		packet.AckPingDomain:     host.Pinger.handleAckPingDomain,
+		packet.CmdPushMessage:    host.Messenger.handleCmdPushMessage,
+		packet.AckPushMessage:    host.Messenger.handleAckPushMessage,
 	}
 	d.dataHandlers = map[byte]func(*packet.Buffer){
 		packet.CmdPushStreamData: host.StreamHub.handleCmdPushStreamData,
@@ -57,8 +63,8 @@ func (d *Dispatcher) start() error {
 
 	// 创建不同的缓存队列，避免不同功能的数据包相互影响
 	// 根据是否需要有序分为：Cmd和Data两种类型
-	d.cmdChan = make(chan *packet.Buffer, 4096)  // 用于缓存OpenStream、PushDataAck的队列，这两个无需保证顺序
-	d.dataChan = make(chan *packet.Buffer, 1024) // 与数据传输有关的包，OpenStreamAck、PushData、Close、CloseAck，需要保证顺序
+	d.cmdChan = make(chan *packet.Buffer, d.cmdSize)   // 用于缓存OpenStream、PushDataAck的队列，这两个无需保证顺序
+	d.dataChan = make(chan *packet.Buffer, d.dataSize) // 与数据传输有关的包，OpenStreamAck、PushData、Close、CloseAck，需要保证顺序
 
 	return nil
 }
@@ -94,7 +100,9 @@ func (d *Dispatcher) dispatch(pbuf *packet.Buffer) error {
 	case packet.CmdOpenStream,
 		packet.AckPushStreamData,
 		packet.CmdPingDomain,
-		packet.AckPingDomain:
+		packet.AckPingDomain,
+		packet.CmdPushMessage,
+		packet.AckPushMessage:
 		d.cmdChan <- pbuf
 	default:
 		d.dataChan <- pbuf
@@ -0,0 +1,372 @@
+package node
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/net-agent/flex/v3/stream"
+)
+
+var (
+	ErrForwardIdleTimeout = errors.New("forward idle timeout")
+)
+
+// Join copies data between a and b in both directions until both directions
+// end, then closes both. EOF on one side half-closes the other side when it
+// supports CloseWrite, as streams and TCP connections do. It returns the bytes
+// copied from a to b and from b to a.
+func Join(a, b net.Conn) (aToB, bToA int64, err error) {
+	var c joinCounter
+	err = join(a, b, 0, &c)
+	return c.aToB.Load(), c.bToA.Load(), err
+}
+
+// joinCounter 记录转发字节数与最近一次活动时间，可在转发过程中读取
+type joinCounter struct {
+	aToB atomic.Int64
+	bToA atomic.Int64
+	last atomic.Int64 // unix nano
+}
+
+// join 双向转发；idle 大于 0 时，两个方向都没有数据超过 idle 后关闭连接
+func join(a, b net.Conn, idle time.Duration, c *joinCounter) error {
+	c.last.Store(time.Now().UnixNano())
+	errs := make(chan error, 2)
+	go func() { errs <- copyHalf(b, a, &c.aToB, &c.last) }()
+	go func() { errs <- copyHalf(a, b, &c.bToA, &c.last) }()
+
+	var idleErr atomic.Value
+	done := make(chan struct{})
+	if idle > 0 {
+		go func() {
+			tick := time.NewTicker(max(idle/4, time.Millisecond))
+			defer tick.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-tick.C:
+					if time.Since(time.Unix(0, c.last.Load())) >= idle {
+						idleErr.Store(ErrForwardIdleTimeout)
+						a.Close()
+						b.Close()
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	var err error
+	for i := 0; i < 2; i++ {
+		if e := <-errs; e != nil {
+			if err == nil {
+				err = e
+			}
+			// 一个方向出错后另一个方向不会再正常结束
+			a.Close()
+			b.Close()
+		}
+	}
+	close(done)
+	a.Close()
+	b.Close()
+
+	if e, ok := idleErr.Load().(error); ok {
+		return e
+	}
+	return err
+}
+
+// copyHalf 将 src 的数据写入 dst，src 读到 EOF 时关闭 dst 的写方向
+func copyHalf(dst, src net.Conn, n, last *atomic.Int64) error {
+	buf := make([]byte, 32*1024)
+	for {
+		nr, rerr := src.Read(buf)
+		if nr > 0 {
+			last.Store(time.Now().UnixNano())
+			nw, werr := dst.Write(buf[:nr])
+			n.Add(int64(nw))
+			if werr != nil {
+				return werr
+			}
+		}
+		if rerr == io.EOF {
+			closeWrite(dst)
+			return nil
+		}
+		if rerr != nil {
+			return rerr
+		}
+	}
+}
+
+// closeWrite 关闭 c 的写方向，不支持半关闭的连接整体关闭
+func closeWrite(c net.Conn) {
+	if cw, ok := c.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+		return
+	}
+	c.Close()
+}
+
+// ForwardConnInfo describes one connection (or UDP flow) handled by a forwarder.
+type ForwardConnInfo struct {
+	ID       int64         `json:"id"`
+	Source   string        `json:"source"` // 发起连接的一端
+	Target   string        `json:"target"`
+	Sent     int64         `json:"bytes_sent"` // source -> target
+	Received int64         `json:"bytes_received"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+	Err      string        `json:"error,omitempty"`
+}
+
+// ForwardStats summarizes a forwarder.
+type ForwardStats struct {
+	Addr        string `json:"addr"`
+	Target      string `json:"target"`
+	ActiveConns int64  `json:"active_conns"`
+	TotalConns  int64  `json:"total_conns"`
+	FailedConns int64  `json:"failed_conns"` // 无法连接 target 的次数
+	Sent        int64  `json:"bytes_sent"`
+	Received    int64  `json:"bytes_received"`
+	Dropped     int64  `json:"dropped,omitempty"` // 丢弃的数据报（仅 UDP）
+}
+
+type forwardConn struct {
+	id      int64
+	source  string
+	start   time.Time
+	counter joinCounter
+}
+
+// forwardTable 记录转发器的活动连接与累计统计
+type forwardTable struct {
+	target  string
+	onClose atomic.Pointer[func(ForwardConnInfo)]
+
+	mu     sync.Mutex
+	conns  map[int64]*forwardConn
+	nextID int64
+
+	total    atomic.Int64
+	failed   atomic.Int64
+	sent     atomic.Int64 // 已结束连接的累计字节数
+	received atomic.Int64
+}
+
+func (t *forwardTable) init(target string) {
+	t.target = target
+	t.conns = make(map[int64]*forwardConn)
+}
+
+// OnConnClose sets fn to be called with the final stats of every connection.
+func (t *forwardTable) OnConnClose(fn func(ForwardConnInfo)) {
+	t.onClose.Store(&fn)
+}
+
+// Conns returns the stats of the active connections ordered by ID.
+func (t *forwardTable) Conns() []ForwardConnInfo {
+	t.mu.Lock()
+	infos := make([]ForwardConnInfo, 0, len(t.conns))
+	for _, c := range t.conns {
+		infos = append(infos, t.connInfo(c))
+	}
+	t.mu.Unlock()
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return infos
+}
+
+func (t *forwardTable) stats(addr net.Addr) ForwardStats {
+	st := ForwardStats{
+		Addr:        addr.String(),
+		Target:      t.target,
+		TotalConns:  t.total.Load(),
+		FailedConns: t.failed.Load(),
+		Sent:        t.sent.Load(),
+		Received:    t.received.Load(),
+	}
+	t.mu.Lock()
+	st.ActiveConns = int64(len(t.conns))
+	for _, c := range t.conns {
+		st.Sent += c.counter.aToB.Load()
+		st.Received += c.counter.bToA.Load()
+	}
+	t.mu.Unlock()
+	return st
+}
+
+func (t *forwardTable) connInfo(c *forwardConn) ForwardConnInfo {
+	return ForwardConnInfo{
+		ID:       c.id,
+		Source:   c.source,
+		Target:   t.target,
+		Sent:     c.counter.aToB.Load(),
+		Received: c.counter.bToA.Load(),
+		Start:    c.start,
+		Duration: time.Since(c.start),
+	}
+}
+
+// add 登记一个已连接到 target 的连接
+func (t *forwardTable) add(source string) *forwardConn {
+	t.total.Add(1)
+	c := &forwardConn{source: source, start: time.Now()}
+	c.counter.last.Store(c.start.UnixNano())
+	t.mu.Lock()
+	t.nextID++
+	c.id = t.nextID
+	t.conns[c.id] = c
+	t.mu.Unlock()
+	return c
+}
+
+// remove 注销连接并报告最终统计
+func (t *forwardTable) remove(c *forwardConn, err error) {
+	t.mu.Lock()
+	if _, ok := t.conns[c.id]; !ok {
+		t.mu.Unlock()
+		return
+	}
+	delete(t.conns, c.id)
+	t.sent.Add(c.counter.aToB.Load())
+	t.received.Add(c.counter.bToA.Load())
+	t.mu.Unlock()
+
+	info := t.connInfo(c)
+	if err != nil {
+		info.Err = err.Error()
+	}
+	t.report(info)
+}
+
+// fail 记录一次无法连接 target 的请求
+func (t *forwardTable) fail(source string, err error) {
+	t.total.Add(1)
+	t.failed.Add(1)
+	t.report(ForwardConnInfo{Source: source, Target: t.target, Start: time.Now(), Err: err.Error()})
+}
+
+func (t *forwardTable) report(info ForwardConnInfo) {
+	if fn := t.onClose.Load(); fn != nil && *fn != nil {
+		(*fn)(info)
+	}
+}
+
+// Forwarder accepts connections on a listener and joins each one with a
+// connection to its target. It is created by ForwardLocal or ForwardRemote.
+type Forwarder struct {
+	forwardTable
+	listener net.Listener
+	dial     func() (net.Conn, error)
+	idle     atomic.Int64 // time.Duration
+}
+
+func newForwarder(l net.Listener, target string, dial func() (net.Conn, error)) *Forwarder {
+	f := &Forwarder{listener: l, dial: dial}
+	f.init(target)
+	go f.serve()
+	return f
+}
+
+// SetIdleTimeout closes connections with no data in either direction for d.
+// Zero (the default) disables the timeout.
+func (f *Forwarder) SetIdleTimeout(d time.Duration) {
+	f.idle.Store(int64(d))
+}
+
+// Addr returns the address the forwarder accepts connections on.
+func (f *Forwarder) Addr() net.Addr {
+	return f.listener.Addr()
+}
+
+// Close stops accepting connections. Active connections are left running.
+func (f *Forwarder) Close() error {
+	return f.listener.Close()
+}
+
+// Stats returns the totals of the forwarder, including active connections.
+func (f *Forwarder) Stats() ForwardStats {
+	return f.stats(f.listener.Addr())
+}
+
+func (f *Forwarder) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		go f.handle(conn)
+	}
+}
+
+func (f *Forwarder) handle(conn net.Conn) {
+	remote, err := f.dial()
+	if err != nil {
+		conn.Close()
+		f.fail(conn.RemoteAddr().String(), err)
+		return
+	}
+	c := f.add(conn.RemoteAddr().String())
+	f.remove(c, join(conn, remote, time.Duration(f.idle.Load()), &c.counter))
+}
+
+// forwardLocal 在本地 TCP 地址上监听，每个连接通过 dial 连接 flex 地址 target
+func forwardLocal(dial func(string) (*stream.Stream, error), localAddr, target string) (*Forwarder, error) {
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		return nil, err
+	}
+	l, err := net.Listen("tcp", localAddr)
+	if err != nil {
+		return nil, err
+	}
+	return newForwarder(l, target, func() (net.Conn, error) {
+		return dial(target)
+	}), nil
+}
+
+// forwardRemote 将 flex 端口上的连接转发到本地 TCP 地址
+func forwardRemote(l net.Listener, localAddr string) *Forwarder {
+	return newForwarder(l, localAddr, func() (net.Conn, error) {
+		return net.Dial("tcp", localAddr)
+	})
+}
+
+// ForwardLocal listens on the local TCP address localAddr and forwards every
+// connection to target ("domain:port") on the flex network.
+func (n *Node) ForwardLocal(localAddr, target string) (*Forwarder, error) {
+	return forwardLocal(n.Dial, localAddr, target)
+}
+
+// ForwardRemote listens on the flex port and forwards every stream to the
+// local TCP address localAddr.
+func (n *Node) ForwardRemote(port uint16, localAddr string) (*Forwarder, error) {
+	l, err := n.Listen(port)
+	if err != nil {
+		return nil, err
+	}
+	return forwardRemote(l, localAddr), nil
+}
+
+// ForwardLocal is Node.ForwardLocal over the session. Connections accepted
+// while the session is disconnected fail; the forwarder keeps running and
+// uses the new node after a reconnect.
+func (s *Session) ForwardLocal(localAddr, target string) (*Forwarder, error) {
+	return forwardLocal(s.Dial, localAddr, target)
+}
+
+// ForwardRemote is Node.ForwardRemote over the session. The flex port is
+// registered again after every reconnect.
+func (s *Session) ForwardRemote(port uint16, localAddr string) (*Forwarder, error) {
+	l, err := s.Listen(port)
+	if err != nil {
+		return nil, err
+	}
+	return forwardRemote(l, localAddr), nil
+}
@@ -0,0 +1,270 @@
+package node
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// startTCPEcho 启动一个按行回显的本地 TCP 服务
+func startTCPEcho(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.Nil(t, err) {
+		return ""
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// tcpPair 返回一对互联的 TCP 连接
+func tcpPair(t *testing.T) (net.Conn, net.Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	defer l.Close()
+	client, err := net.Dial("tcp", l.Addr().String())
+	assert.Nil(t, err)
+	server, err := l.Accept()
+	assert.Nil(t, err)
+	return client, server
+}
+
+func assertLineEcho(t *testing.T, c net.Conn, line string) {
+	_, err := io.WriteString(c, line+"\n")
+	assert.Nil(t, err)
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	resp, err := bufio.NewReader(c).ReadString('\n')
+	assert.Nil(t, err)
+	assert.Equal(t, line+"\n", resp)
+}
+
+func TestJoinHalfClose(t *testing.T) {
+	c1, s1 := tcpPair(t)
+	c2, s2 := tcpPair(t)
+
+	type result struct{ ab, ba int64 }
+	done := make(chan result, 1)
+	go func() {
+		ab, ba, err := Join(s1, c2)
+		assert.Nil(t, err)
+		done <- result{ab, ba}
+	}()
+
+	// 客户端发送后关闭写方向，仍能收到服务端的应答
+	io.WriteString(c1, "hello")
+	c1.(*net.TCPConn).CloseWrite()
+
+	req, err := io.ReadAll(s2)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(req))
+	io.WriteString(s2, "world!")
+	s2.Close()
+
+	resp, err := io.ReadAll(c1)
+	assert.Nil(t, err)
+	assert.Equal(t, "world!", string(resp))
+	c1.Close()
+
+	select {
+	case r := <-done:
+		assert.Equal(t, int64(5), r.ab)
+		assert.Equal(t, int64(6), r.ba)
+	case <-time.After(time.Second):
+		t.Error("join not finished")
+	}
+}
+
+func TestJoinTinyIdle(t *testing.T) {
+	c1, s1 := tcpPair(t)
+	c2, s2 := tcpPair(t)
+	defer c1.Close()
+	defer s2.Close()
+
+	// 小于 4ns 的 idle 不能让 ticker panic，空闲后关闭连接
+	var c joinCounter
+	err := join(s1, c2, time.Nanosecond, &c)
+	assert.ErrorIs(t, err, ErrForwardIdleTimeout)
+}
+
+func TestJoinStreamHalfClose(t *testing.T) {
+	n1, n2 := Pipe("test1", "test2")
+	defer n1.Close()
+	defer n2.Close()
+
+	l, err := n2.Listen(80)
+	assert.Nil(t, err)
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		// stream 读到 EOF 后仍能回写应答
+		req, _ := io.ReadAll(c)
+		io.WriteString(c, "re:"+string(req))
+		c.Close()
+	}()
+
+	c1, s1 := tcpPair(t)
+	st, err := n1.DialDomain("test2", 80)
+	assert.Nil(t, err)
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := Join(s1, st)
+		done <- err
+	}()
+
+	io.WriteString(c1, "hello")
+	c1.(*net.TCPConn).CloseWrite()
+	resp, err := io.ReadAll(c1)
+	assert.Nil(t, err)
+	assert.Equal(t, "re:hello", string(resp))
+	c1.Close()
+
+	select {
+	case err := <-done:
+		assert.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Error("join not finished")
+	}
+}
+
+func TestForward(t *testing.T) {
+	n1, n2 := Pipe("test1", "test2")
+	defer n1.Close()
+	defer n2.Close()
+	echo := startTCPEcho(t)
+
+	remote, err := n2.ForwardRemote(80, echo)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer remote.Close()
+	_, err = n2.ForwardRemote(80, echo)
+	assert.Equal(t, ErrListenPortIsUsed, err)
+
+	local, err := n1.ForwardLocal("127.0.0.1:0", "2:80")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer local.Close()
+	_, err = n1.ForwardLocal("127.0.0.1:0", "test2")
+	assert.NotNil(t, err)
+
+	closed := make(chan ForwardConnInfo, 4)
+	local.OnConnClose(func(info ForwardConnInfo) { closed <- info })
+
+	c, err := net.Dial("tcp", local.Addr().String())
+	if !assert.Nil(t, err) {
+		return
+	}
+	assertLineEcho(t, c, "hello")
+	if conns := local.Conns(); assert.Equal(t, 1, len(conns)) {
+		assert.Equal(t, "2:80", conns[0].Target)
+		assert.Equal(t, c.LocalAddr().String(), conns[0].Source)
+	}
+	c.Close()
+
+	select {
+	case info := <-closed:
+		assert.Equal(t, int64(6), info.Sent)
+		assert.Equal(t, int64(6), info.Received)
+	case <-time.After(time.Second):
+		t.Error("conn close not reported")
+	}
+	st := local.Stats()
+	assert.Equal(t, int64(0), st.ActiveConns)
+	assert.Equal(t, int64(1), st.TotalConns)
+	assert.Equal(t, int64(6), st.Sent)
+	assert.Equal(t, int64(6), st.Received)
+	assert.Eventually(t, func() bool {
+		return remote.Stats().TotalConns == 1 && remote.Stats().ActiveConns == 0
+	}, time.Second, 10*time.Millisecond)
+
+	// 空闲超时
+	local.SetIdleTimeout(100 * time.Millisecond)
+	c, err = net.Dial("tcp", local.Addr().String())
+	if !assert.Nil(t, err) {
+		return
+	}
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = c.Read(make([]byte, 1))
+	assert.NotNil(t, err)
+	c.Close()
+	select {
+	case info := <-closed:
+		assert.Equal(t, ErrForwardIdleTimeout.Error(), info.Err)
+	case <-time.After(time.Second):
+		t.Error("idle close not reported")
+	}
+
+	// 目标端口不可达
+	bad, err := n1.ForwardLocal("127.0.0.1:0", "2:81")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer bad.Close()
+	c, err = net.Dial("tcp", bad.Addr().String())
+	if assert.Nil(t, err) {
+		c.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = c.Read(make([]byte, 1))
+		assert.NotNil(t, err)
+		c.Close()
+	}
+	assert.Equal(t, int64(1), bad.Stats().FailedConns)
+}
+
+func TestSessionForwardRemote(t *testing.T) {
+	connector, getServers := newTestConnector()
+	s := NewSession(connector, testSessionConfig())
+	echo := startTCPEcho(t)
+
+	f, err := s.ForwardRemote(80, echo)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer f.Close()
+	go s.Serve()
+	defer s.Close()
+	assert.Nil(t, s.WaitReady(time.Second))
+
+	st, err := getServers()[0].DialIP(1, 80)
+	if assert.Nil(t, err) {
+		assertLineEcho(t, st, "first")
+		st.Close()
+	}
+
+	// 重连后端口重新注册
+	getServers()[0].Close()
+	time.Sleep(100 * time.Millisecond)
+	assert.Nil(t, s.WaitReady(5*time.Second))
+	servers := getServers()
+	if !assert.GreaterOrEqual(t, len(servers), 2) {
+		return
+	}
+	st, err = servers[1].DialIP(1, 80)
+	if assert.Nil(t, err) {
+		assertLineEcho(t, st, "second")
+		st.Close()
+	}
+	assert.Eventually(t, func() bool {
+		return f.Stats().TotalConns == 2
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,14 @@
+package node
+
+import "github.com/net-agent/flex/v3/packet"
+
+// JoinGroup 将节点加入 switcher 上的服务组。其他节点 Dial 组名时，
+// switcher 按服务组的策略从成员中选出一个节点接受连接。
+func (node *Node) JoinGroup(group string) error {
+	return node.Request(packet.TopicGroupJoin, packet.GroupRequest{Group: group}, nil, DefaultRequestTimeout)
+}
+
+// LeaveGroup 将节点移出服务组。节点断开时会自动移出所有服务组。
+func (node *Node) LeaveGroup(group string) error {
+	return node.Request(packet.TopicGroupLeave, packet.GroupRequest{Group: group}, nil, DefaultRequestTimeout)
+}
@@ -1,10 +1,12 @@
 package node
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/net-agent/flex/v3/internal/idpool"
@@ -18,10 +20,16 @@ var (
 	ErrListenerNotFound      = errors.New("listener not found")
 	ErrConvertListenerFailed = errors.New("convert listener failed")
 	ErrListenerBacklogFull   = errors.New("listener backlog full")
+	ErrAccessDenied          = errors.New("access denied")
 
-	listenerEnqueueTimeout = time.Second
+	listenerEnqueueTimeout = time.Second // Config.AcceptTimeout 的默认值
 )
 
+// AuthorizeFunc 在应答 OpenStream 之前检查拨号方，返回非 nil 时拒绝连接，
+// 拨号方收到的错误满足 errors.Is(err, ErrAccessDenied)。metadata 为拨号方通过 WithMetadata 附带的元数据，可能为 nil。
+// 该函数在分发协程中执行，不应阻塞。
+type AuthorizeFunc func(remoteDomain string, remoteIP uint16, metadata map[string]string) error
+
 type ListenHub struct {
 	host      *Node
 	portm     *idpool.Pool
@@ -33,15 +41,22 @@ func (hub *ListenHub) init(host *Node, portm *idpool.Pool) {
 	hub.portm = portm
 }
 
+// Listen 监听端口，port 为 AnyPort 时接收所有未被单独监听的端口上的连接
 func (hub *ListenHub) Listen(port uint16) (net.Listener, error) {
+	return hub.listen(port, nil)
+}
+
+// listen 在 listener 生效前设置鉴权函数，避免注册与 SetAuthorizer 之间有未经鉴权的连接
+func (hub *ListenHub) listen(port uint16, authorize AuthorizeFunc) (net.Listener, error) {
 	listener := &Listener{
 		hub:     hub,
 		port:    port,
-		streams: make(chan *stream.Stream, 32),
+		streams: make(chan *stream.Stream, hub.host.config.ListenBacklog),
 		done:    make(chan struct{}),
 		closed:  false,
 		str:     fmt.Sprintf("%v:%v", hub.host.GetIP(), port),
 	}
+	listener.SetAuthorizer(authorize)
 
 	_, loaded := hub.listeners.LoadOrStore(port, listener)
 	if loaded {
@@ -87,14 +102,14 @@ func (hub *ListenHub) getActiveListeners() []*Listener {
 // 处理对端发送过来的OpenStream请求
 func (hub *ListenHub) handleCmdOpenStream(pbuf *packet.Buffer) {
 	var negotiatedWindowSize int32
-	var ackMessage string
+	var ackErr error
 
 	defer func() {
 		var ack packet.OpenStreamACK
-		if ackMessage == "" {
-			ack = packet.OpenStreamACK{OK: true, WindowSize: uint32(negotiatedWindowSize)}
+		if ackErr == nil {
+			ack = packet.OpenStreamACK{OK: true, WindowSize: uint32(negotiatedWindowSize), MaxWindowSize: hub.host.maxWindowSize()}
 		} else {
-			ack = packet.OpenStreamACK{Error: ackMessage}
+			ack = packet.OpenStreamACK{Code: errorCode(ackErr), Error: ackErr.Error()}
 		}
 
 		_ = pbuf.SetPayload(ack.Encode())
@@ -111,10 +126,13 @@ func (hub *ListenHub) handleCmdOpenStream(pbuf *packet.Buffer) {
 		}
 	}()
 
-	// 找到该端口是否存在listener，如果不存在，则说明此端口并未开放
+	// 找到该端口是否存在listener，如果不存在，则交给AnyPort上的listener，都没有说明此端口并未开放
 	l, err := hub.getListenerByPort(pbuf.DistPort())
+	if errors.Is(err, ErrListenerNotFound) {
+		l, err = hub.getListenerByPort(AnyPort)
+	}
 	if err != nil {
-		ackMessage = err.Error()
+		ackErr = err
 		return
 	}
 
@@ -126,6 +144,15 @@ func (hub *ListenHub) handleCmdOpenStream(pbuf *packet.Buffer) {
 		remoteDomain = hub.host.domain
 	}
 
+	// 在创建 stream 之前鉴权，拒绝时不占用任何资源
+	if err := l.authorize(remoteDomain, pbuf.SrcIP(), req.Metadata); err != nil {
+		if !errors.Is(err, ErrAccessDenied) {
+			err = fmt.Errorf("%w: %v", ErrAccessDenied, err)
+		}
+		ackErr = err
+		return
+	}
+
 	// Negotiate Window Size
 	localWindowSize := hub.host.GetWindowSize()
 	negotiatedWindowSize = localWindowSize // Default to local
@@ -142,6 +169,8 @@ func (hub *ListenHub) handleCmdOpenStream(pbuf *packet.Buffer) {
 		remoteDomain, pbuf.SrcIP(), pbuf.SrcPort(),
 		negotiatedWindowSize,
 	)
+	s.SetMetadata(req.Metadata)
+	hub.host.setupStream(s, req.MaxWindowSize)
 	defer func() {
 		if s != nil {
 			s.Close()
@@ -152,21 +181,21 @@ func (hub *ListenHub) handleCmdOpenStream(pbuf *packet.Buffer) {
 	// 因此需要先完成stream和sid的绑定，然后再应答ack，避免因时序问题出现的数据包丢失
 	err = hub.host.attachStream(s, pbuf.SID())
 	if err != nil {
-		ackMessage = err.Error()
+		ackErr = err
 		return
 	}
 
 	// todo: maybe error with push channel
-	timer := time.NewTimer(listenerEnqueueTimeout)
+	timer := time.NewTimer(hub.host.config.AcceptTimeout)
 	defer timer.Stop()
 
 	select {
 	case l.streams <- s:
 		s = nil
 	case <-l.done:
-		ackMessage = ErrListenerClosed.Error()
+		ackErr = ErrListenerClosed
 	case <-timer.C:
-		ackMessage = ErrListenerBacklogFull.Error()
+		ackErr = ErrListenerBacklogFull
 	}
 }
 
@@ -180,9 +209,33 @@ type Listener struct {
 	closed bool
 	locker sync.RWMutex
 	str    string
+
+	authorizer atomic.Pointer[AuthorizeFunc]
+}
+
+// SetAuthorizer 设置新连接的鉴权函数，fn 为 nil 时接受所有连接
+func (l *Listener) SetAuthorizer(fn AuthorizeFunc) {
+	if fn == nil {
+		l.authorizer.Store(nil)
+		return
+	}
+	l.authorizer.Store(&fn)
+}
+
+func (l *Listener) authorize(remoteDomain string, remoteIP uint16, metadata map[string]string) error {
+	fn := l.authorizer.Load()
+	if fn == nil {
+		return nil
+	}
+	return (*fn)(remoteDomain, remoteIP, metadata)
 }
 
 func (l *Listener) Accept() (net.Conn, error) {
+	return l.AcceptContext(context.Background())
+}
+
+// AcceptContext 等待下一个连接，ctx 取消时返回 ctx.Err()
+func (l *Listener) AcceptContext(ctx context.Context) (net.Conn, error) {
 	select {
 	case s := <-l.streams:
 		if s == nil {
@@ -191,6 +244,8 @@ func (l *Listener) Accept() (net.Conn, error) {
 		return s, nil
 	case <-l.done:
 		return nil, ErrListenerClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
 }
 
@@ -1,6 +1,8 @@
 package node
 
 import (
+	"context"
+	"errors"
 	"sync"
 	"testing"
 	"time"
@@ -90,7 +92,7 @@ func TestHandleCmdOpenErr(t *testing.T) {
 	pbuf.SetSrc(1, 1000)
 	pbuf.SetPayload([]byte("test2"))
 
-	s, err := n1.dialPbuf(pbuf)
+	s, err := n1.dialPbuf(context.Background(), pbuf)
 	assert.Nil(t, err)
 	assert.NotNil(t, s)
 
@@ -125,3 +127,70 @@ func TestHandleCmdOpenStream_BacklogFullReturnsAckError(t *testing.T) {
 	assert.NotNil(t, err)
 	assert.Equal(t, ErrListenerBacklogFull.Error(), err.Error())
 }
+
+func TestAcceptContext(t *testing.T) {
+	n1, n2 := Pipe("test1", "test2")
+	defer n1.Close()
+	defer n2.Close()
+
+	l, err := n2.Listen(80)
+	if !assert.Nil(t, err) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = l.(*Listener).AcceptContext(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	go func() {
+		if c, err := n1.Dial("test2:80"); err == nil {
+			c.Close()
+		}
+	}()
+	c, err := l.(*Listener).AcceptContext(context.Background())
+	if assert.Nil(t, err) {
+		c.Close()
+	}
+}
+
+func TestListenerAuthorize(t *testing.T) {
+	n1, n2 := Pipe("test1", "test2")
+	defer n1.Close()
+	defer n2.Close()
+
+	l, err := n2.Listen(80)
+	if !assert.Nil(t, err) {
+		return
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+
+	var gotIP uint16
+	l.(*Listener).SetAuthorizer(func(_ string, ip uint16, _ map[string]string) error {
+		gotIP = ip
+		return errors.New("not allowed")
+	})
+	_, err = n1.Dial("test2:80")
+	assert.True(t, errors.Is(err, ErrAccessDenied))
+	assert.Equal(t, "access denied: not allowed", err.Error())
+	assert.Equal(t, uint16(1), gotIP)
+	// 拒绝的连接不占用 stream
+	assert.Equal(t, 0, len(n2.GetStreamStates()))
+
+	l.(*Listener).SetAuthorizer(func(string, uint16, map[string]string) error { return ErrAccessDenied })
+	_, err = n1.Dial("test2:80")
+	assert.ErrorIs(t, err, ErrAccessDenied)
+
+	l.(*Listener).SetAuthorizer(nil)
+	c, err := n1.Dial("test2:80")
+	if assert.Nil(t, err) {
+		c.Close()
+	}
+}
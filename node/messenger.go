@@ -0,0 +1,130 @@
+package node
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/net-agent/flex/v3/internal/idpool"
+	"github.com/net-agent/flex/v3/internal/pending"
+	"github.com/net-agent/flex/v3/packet"
+)
+
+var (
+	DefaultRequestTimeout = time.Second * 5
+)
+
+// MessageHandler 处理对端主动推送的消息
+type MessageHandler func(msg *packet.Message)
+
+// Messenger 提供与 switcher 之间的控制消息通道（CmdPushMessage/AckPushMessage）。
+// Request 发起请求并等待应答；HandleMessage 注册对端主动推送消息的处理函数。
+type Messenger struct {
+	host    *Node
+	portm   *idpool.Pool
+	pending pending.Requests[*packet.Message]
+
+	handlersMu sync.RWMutex
+	handlers   map[string]MessageHandler
+}
+
+func (m *Messenger) init(host *Node) {
+	m.host = host
+	m.portm, _ = idpool.New(1, 0xffff)
+	m.handlers = make(map[string]MessageHandler)
+}
+
+// Request 向 switcher 发送 topic 请求，req 与 resp 使用 JSON 编码，resp 为 nil 时忽略应答内容
+func (m *Messenger) Request(topic string, req, resp any, timeout time.Duration) error {
+	return m.request(packet.SwitcherIP, topic, req, resp, timeout)
+}
+
+// request 向 distIP 上的服务（SwitcherIP、DNSIP）发送请求
+func (m *Messenger) request(distIP uint16, topic string, req, resp any, timeout time.Duration) error {
+	msg, err := packet.NewMessage(topic, req)
+	if err != nil {
+		return err
+	}
+	payload, err := msg.Encode()
+	if err != nil {
+		return err
+	}
+
+	port, err := m.portm.Allocate()
+	if err != nil {
+		return err
+	}
+	defer m.portm.Release(port)
+
+	ch, err := m.pending.Register(port)
+	if err != nil {
+		return err
+	}
+	defer m.pending.Remove(port)
+
+	pbuf := packet.NewBufferWithCmd(packet.CmdPushMessage)
+	pbuf.SetSrc(m.host.GetIP(), port)
+	pbuf.SetDist(distIP, 0)
+	if err = pbuf.SetPayload(payload); err != nil {
+		return err
+	}
+	if err = m.host.WriteBuffer(pbuf); err != nil {
+		return err
+	}
+
+	select {
+	case res, ok := <-ch:
+		if !ok {
+			return pending.ErrTimeout
+		}
+		if res.Err != nil {
+			return res.Err
+		}
+		if res.Val.Error != "" {
+			return errors.New(res.Val.Error)
+		}
+		if resp == nil {
+			return nil
+		}
+		return res.Val.Unmarshal(resp)
+	case <-time.After(timeout):
+		return pending.ErrTimeout
+	}
+}
+
+// HandleMessage 注册 topic 推送消息的处理函数，fn 为 nil 时取消注册
+func (m *Messenger) HandleMessage(topic string, fn MessageHandler) {
+	m.handlersMu.Lock()
+	defer m.handlersMu.Unlock()
+	if fn == nil {
+		delete(m.handlers, topic)
+		return
+	}
+	m.handlers[topic] = fn
+}
+
+// handleCmdPushMessage 处理对端主动推送的消息
+func (m *Messenger) handleCmdPushMessage(pbuf *packet.Buffer) {
+	msg, err := packet.DecodeMessage(pbuf.Payload)
+	if err != nil {
+		m.host.logger.Warn("decode push message failed", "error", err)
+		return
+	}
+
+	m.handlersMu.RLock()
+	fn, ok := m.handlers[msg.Topic]
+	m.handlersMu.RUnlock()
+	if !ok {
+		m.host.logger.Warn("unhandled push message", "topic", msg.Topic)
+		return
+	}
+	fn(msg)
+}
+
+// handleAckPushMessage 处理请求的应答
+func (m *Messenger) handleAckPushMessage(pbuf *packet.Buffer) {
+	msg, err := packet.DecodeMessage(pbuf.Payload)
+	if err := m.pending.Complete(pbuf.DistPort(), msg, err); err != nil {
+		m.host.logger.Warn("dispatch message ack failed", "port", pbuf.DistPort(), "error", err)
+	}
+}
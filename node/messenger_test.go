@@ -0,0 +1,91 @@
+package node
+
+import (
+	"testing"
+	"time"
+
+	"github.com/net-agent/flex/v3/internal/pending"
+	"github.com/net-agent/flex/v3/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+type echoRequest struct {
+	Text string `json:"text"`
+}
+
+// replyMessages 让 n 扮演 switcher，对收到的请求调用 fn 并回复应答
+func replyMessages(n *Node, fn func(msg *packet.Message) *packet.Message) {
+	n.RegisterCmdHandler(packet.CmdPushMessage, func(pbuf *packet.Buffer) {
+		msg, _ := packet.DecodeMessage(pbuf.Payload)
+		payload, _ := fn(msg).Encode()
+		pbuf.SwapSrcDist()
+		pbuf.SetCmd(packet.AckPushMessage)
+		_ = pbuf.SetPayload(payload)
+		n.WriteBuffer(pbuf)
+	})
+}
+
+func TestMessengerRequest(t *testing.T) {
+	n1, n2 := Pipe("test1", "test2")
+	replyMessages(n2, func(msg *packet.Message) *packet.Message {
+		var req echoRequest
+		msg.Unmarshal(&req)
+		if req.Text == "" {
+			return &packet.Message{Topic: msg.Topic, Error: "empty text"}
+		}
+		resp, _ := packet.NewMessage(msg.Topic, req)
+		return resp
+	})
+
+	var resp echoRequest
+	err := n1.Request("echo", echoRequest{Text: "hello"}, &resp, time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", resp.Text)
+
+	err = n1.Request("echo", echoRequest{}, &resp, time.Second)
+	assert.EqualError(t, err, "empty text")
+
+	// resp 为 nil 时忽略应答内容
+	assert.Nil(t, n1.Request("echo", echoRequest{Text: "x"}, nil, time.Second))
+}
+
+func TestMessengerRequestTimeout(t *testing.T) {
+	n1, n2 := Pipe("test1", "test2")
+	n2.RegisterCmdHandler(packet.CmdPushMessage, func(*packet.Buffer) {})
+
+	err := n1.Request("echo", nil, nil, 50*time.Millisecond)
+	assert.Equal(t, pending.ErrTimeout, err)
+}
+
+func TestMessengerHandleMessage(t *testing.T) {
+	n1, n2 := Pipe("test1", "test2")
+
+	got := make(chan string, 1)
+	n2.HandleMessage("notify", func(msg *packet.Message) {
+		var req echoRequest
+		msg.Unmarshal(&req)
+		got <- req.Text
+	})
+
+	msg, _ := packet.NewMessage("notify", echoRequest{Text: "pushed"})
+	payload, _ := msg.Encode()
+	pbuf := packet.NewBufferWithCmd(packet.CmdPushMessage)
+	pbuf.SetSrc(packet.SwitcherIP, 0)
+	pbuf.SetDist(n2.GetIP(), 0)
+	_ = pbuf.SetPayload(payload)
+	assert.Nil(t, n1.Conn.WriteBuffer(pbuf))
+
+	select {
+	case text := <-got:
+		assert.Equal(t, "pushed", text)
+	case <-time.After(time.Second):
+		t.Fatal("push message not handled")
+	}
+
+	// 取消注册后不再处理
+	n2.HandleMessage("notify", nil)
+	n2.Messenger.handlersMu.RLock()
+	_, ok := n2.Messenger.handlers["notify"]
+	n2.Messenger.handlersMu.RUnlock()
+	assert.False(t, ok)
+}
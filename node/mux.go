@@ -0,0 +1,171 @@
+package node
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"runtime/debug"
+	"sync"
+)
+
+var (
+	ErrHandlerNotFound = errors.New("handler not found")
+	ErrNilHandler      = errors.New("nil handler")
+)
+
+// AnyPort 作为 Listen/Handle 的端口时，接收所有未被单独监听的端口上的连接
+const AnyPort uint16 = 0
+
+// Handler serves one connection accepted by a ServeMux. The connection is
+// closed when ServeConn returns.
+type Handler interface {
+	ServeConn(c net.Conn)
+}
+
+// HandlerFunc adapts a function to Handler.
+type HandlerFunc func(c net.Conn)
+
+func (f HandlerFunc) ServeConn(c net.Conn) { f(c) }
+
+// HandleOption changes how a ServeMux runs one handler.
+type HandleOption func(*muxEntry)
+
+// WithMaxConns limits the connections served by the handler at the same time.
+// Further connections wait in the listener backlog, and are rejected with
+// ErrListenerBacklogFull once it is full.
+func WithMaxConns(n int) HandleOption {
+	return func(e *muxEntry) {
+		if n > 0 {
+			e.sem = make(chan struct{}, n)
+		}
+	}
+}
+
+// WithAuthorizer checks callers before their connection is accepted, see
+// Listener.SetAuthorizer.
+func WithAuthorizer(fn AuthorizeFunc) HandleOption {
+	return func(e *muxEntry) { e.authorize = fn }
+}
+
+type portListener interface {
+	listen(port uint16, authorize AuthorizeFunc) (net.Listener, error)
+}
+
+// ServeMux runs an accept loop per registered port and serves every
+// connection on its own goroutine. Node and Session embed it, so handlers
+// are registered with node.Handle(port, h).
+type ServeMux struct {
+	host    portListener
+	logger  func() *slog.Logger
+	done    <-chan struct{} // host 关闭时关闭
+	mu      sync.Mutex
+	entries map[uint16]*muxEntry
+}
+
+type muxEntry struct {
+	port      uint16
+	handler   Handler
+	sem       chan struct{} // nil 表示不限制并发
+	authorize AuthorizeFunc
+	l         net.Listener
+	done      chan struct{} // Unhandle 时关闭
+}
+
+func (m *ServeMux) init(host portListener, logger func() *slog.Logger, done <-chan struct{}) {
+	m.host = host
+	m.logger = logger
+	m.done = done
+	m.entries = make(map[uint16]*muxEntry)
+}
+
+// Handle listens on port and serves its connections with h. Use AnyPort to
+// catch connections to ports without their own listener.
+func (m *ServeMux) Handle(port uint16, h Handler, opts ...HandleOption) error {
+	if h == nil {
+		return ErrNilHandler
+	}
+	e := &muxEntry{port: port, handler: h, done: make(chan struct{})}
+	for _, opt := range opts {
+		opt(e)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, found := m.entries[port]; found {
+		return ErrListenPortIsUsed
+	}
+	l, err := m.host.listen(port, e.authorize)
+	if err != nil {
+		return err
+	}
+	e.l = l
+	m.entries[port] = e
+	go m.serve(e)
+	return nil
+}
+
+// HandleFunc is Handle with a function.
+func (m *ServeMux) HandleFunc(port uint16, f func(c net.Conn), opts ...HandleOption) error {
+	if f == nil {
+		return ErrNilHandler
+	}
+	return m.Handle(port, HandlerFunc(f), opts...)
+}
+
+// Unhandle stops listening on port. Connections being served are not closed.
+func (m *ServeMux) Unhandle(port uint16) error {
+	m.mu.Lock()
+	e, found := m.entries[port]
+	delete(m.entries, port)
+	m.mu.Unlock()
+
+	if !found {
+		return ErrHandlerNotFound
+	}
+	close(e.done)
+	return e.l.Close()
+}
+
+func (m *ServeMux) serve(e *muxEntry) {
+	defer m.remove(e)
+	for {
+		if e.sem != nil {
+			// 并发已满时 accept 循环停在这里，需要响应 Unhandle 和 host 关闭
+			select {
+			case e.sem <- struct{}{}:
+			case <-e.done:
+				return
+			case <-m.done:
+				return
+			}
+		}
+		c, err := e.l.Accept()
+		if err != nil {
+			return
+		}
+		go m.serveConn(e, c)
+	}
+}
+
+// remove 在 accept 循环退出后（如 Node 关闭）注销该端口，已被替换的条目不受影响
+func (m *ServeMux) remove(e *muxEntry) {
+	m.mu.Lock()
+	if m.entries[e.port] == e {
+		delete(m.entries, e.port)
+	}
+	m.mu.Unlock()
+}
+
+func (m *ServeMux) serveConn(e *muxEntry, c net.Conn) {
+	defer func() {
+		if r := recover(); r != nil {
+			m.logger().Error("handler panic", "port", e.port, "remote", c.RemoteAddr().String(),
+				"panic", r, "stack", string(debug.Stack()))
+		}
+		c.Close()
+		if e.sem != nil {
+			<-e.sem
+		}
+	}()
+	e.handler.ServeConn(c)
+}
@@ -0,0 +1,189 @@
+package node
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// portEcho 回写 "<本地端口> <收到的一行>"
+func portEcho(c net.Conn) {
+	line, err := bufio.NewReader(c).ReadString('\n')
+	if err != nil {
+		return
+	}
+	_, port, _ := net.SplitHostPort(c.LocalAddr().String())
+	fmt.Fprintf(c, "%s %s", port, line)
+}
+
+func request(t *testing.T, dial func(string) (net.Conn, error), addr, msg string) string {
+	c, err := dial(addr)
+	if !assert.Nil(t, err, addr) {
+		return ""
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(time.Second))
+	fmt.Fprintf(c, "%s\n", msg)
+	line, _ := bufio.NewReader(c).ReadString('\n')
+	return line
+}
+
+func TestServeMux(t *testing.T) {
+	n1, n2 := Pipe("test1", "test2")
+	defer n1.Close()
+	defer n2.Close()
+	dial := func(addr string) (net.Conn, error) { return n1.Dial(addr) }
+
+	assert.Nil(t, n2.HandleFunc(80, portEcho))
+	assert.Equal(t, ErrListenPortIsUsed, n2.HandleFunc(80, portEcho))
+	assert.Equal(t, ErrNilHandler, n2.Handle(81, nil))
+	assert.Equal(t, "80 hello\n", request(t, dial, "test2:80", "hello"))
+
+	// 未注册的端口
+	_, err := n1.Dial("test2:81")
+	assert.NotNil(t, err)
+
+	// AnyPort 兜底，单独注册的端口优先
+	assert.Nil(t, n2.HandleFunc(AnyPort, func(c net.Conn) {
+		c.Write([]byte("any "))
+		portEcho(c)
+	}))
+	assert.Equal(t, "any 81 hi\n", request(t, dial, "test2:81", "hi"))
+	assert.Equal(t, "80 hello\n", request(t, dial, "test2:80", "hello"))
+
+	assert.Nil(t, n2.Unhandle(AnyPort))
+	assert.Equal(t, ErrHandlerNotFound, n2.Unhandle(AnyPort))
+	_, err = n1.Dial("test2:81")
+	assert.NotNil(t, err)
+
+	// handler panic 后连接被关闭，服务继续可用
+	assert.Nil(t, n2.HandleFunc(82, func(c net.Conn) { panic("boom") }))
+	c, err := n1.Dial("test2:82")
+	if assert.Nil(t, err) {
+		c.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = c.Read(make([]byte, 1))
+		assert.NotNil(t, err)
+		c.Close()
+	}
+	assert.Equal(t, "80 again\n", request(t, dial, "test2:80", "again"))
+}
+
+func TestServeMuxMaxConns(t *testing.T) {
+	n1, n2 := Pipe("test1", "test2")
+	defer n1.Close()
+	defer n2.Close()
+
+	var active, peak atomic.Int32
+	release := make(chan struct{})
+	err := n2.HandleFunc(80, func(c net.Conn) {
+		cur := active.Add(1)
+		defer active.Add(-1)
+		for {
+			old := peak.Load()
+			if cur <= old || peak.CompareAndSwap(old, cur) {
+				break
+			}
+		}
+		<-release
+	}, WithMaxConns(2))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	var conns []net.Conn
+	for i := 0; i < 4; i++ {
+		c, err := n1.Dial("test2:80")
+		if assert.Nil(t, err) {
+			conns = append(conns, c)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(2), active.Load())
+
+	close(release)
+	for _, c := range conns {
+		c.Close()
+	}
+	assert.Eventually(t, func() bool { return active.Load() == 0 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(2), peak.Load())
+}
+
+func TestServeMuxMaxConnsStop(t *testing.T) {
+	n1, n2 := Pipe("test1", "test2")
+	defer n1.Close()
+
+	release := make(chan struct{})
+	defer close(release)
+	handle := func(port uint16) *muxEntry {
+		assert.Nil(t, n2.HandleFunc(port, func(c net.Conn) { <-release }, WithMaxConns(1)))
+		c, err := n1.Dial(fmt.Sprintf("test2:%v", port))
+		if assert.Nil(t, err) {
+			defer c.Close()
+		}
+		n2.ServeMux.mu.Lock()
+		defer n2.ServeMux.mu.Unlock()
+		return n2.ServeMux.entries[port]
+	}
+
+	// 并发已满时 accept 循环等待空位，Unhandle 后不再占用空位
+	e := handle(80)
+	assert.Eventually(t, func() bool { return len(e.sem) == 1 }, time.Second, 10*time.Millisecond)
+	assert.Nil(t, n2.Unhandle(80))
+	release <- struct{}{}
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 0, len(e.sem))
+
+	// Node 关闭后 accept 循环退出并注销端口
+	handle(81)
+	n2.Close()
+	assert.Eventually(t, func() bool {
+		n2.ServeMux.mu.Lock()
+		defer n2.ServeMux.mu.Unlock()
+		return len(n2.ServeMux.entries) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestSessionServeMux(t *testing.T) {
+	connector, getServers := newTestConnector()
+	s := NewSession(connector, testSessionConfig())
+	assert.Nil(t, s.HandleFunc(80, portEcho))
+	assert.Nil(t, s.HandleFunc(AnyPort, portEcho))
+
+	go s.Serve()
+	defer s.Close()
+	assert.Nil(t, s.WaitReady(time.Second))
+
+	server1 := getServers()[0]
+	dial := func(addr string) (net.Conn, error) { return server1.Dial(addr) }
+	assert.Equal(t, "80 a\n", request(t, dial, "1:80", "a"))
+	assert.Equal(t, "90 b\n", request(t, dial, "1:90", "b"))
+
+	// 重连后 handler 继续生效
+	server1.Close()
+	time.Sleep(100 * time.Millisecond)
+	assert.Nil(t, s.WaitReady(5*time.Second))
+	servers := getServers()
+	if !assert.GreaterOrEqual(t, len(servers), 2) {
+		return
+	}
+	server2 := servers[1]
+	dial = func(addr string) (net.Conn, error) { return server2.Dial(addr) }
+	assert.Equal(t, "80 c\n", request(t, dial, "1:80", "c"))
+	assert.Equal(t, "91 d\n", request(t, dial, "1:91", "d"))
+}
+
+func TestServeMuxAuthorizer(t *testing.T) {
+	n1, n2 := Pipe("test1", "test2")
+	defer n1.Close()
+	defer n2.Close()
+
+	deny := func(string, uint16, map[string]string) error { return ErrAccessDenied }
+	assert.Nil(t, n2.HandleFunc(80, portEcho, WithAuthorizer(deny)))
+	_, err := n1.Dial("test2:80")
+	assert.ErrorIs(t, err, ErrAccessDenied)
+}
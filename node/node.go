@@ -9,6 +9,7 @@ import (
 
 	"github.com/net-agent/flex/v3/internal/idpool"
 	"github.com/net-agent/flex/v3/packet"
+	"github.com/net-agent/flex/v3/stream"
 )
 
 var (
@@ -25,7 +26,12 @@ var (
 type FlowConfig struct {
 	Bandwidth     int64 // bytes per second
 	RTT           time.Duration
-	MaxWindowSize int32
+	MaxWindowSize int32 // 固定窗口；AutoTune 时为窗口上限，默认 stream.DefaultMaxWindowSize
+
+	// AutoTune 按估计的 RTT 和交付速率自动调整接收窗口，需要对端也支持，
+	// 否则窗口固定为协商的大小
+	AutoTune      bool
+	MinWindowSize int32 // AutoTune 时的窗口下限，默认 stream.DefaultMinWindowSize
 }
 
 type Node struct {
@@ -37,7 +43,9 @@ type Node struct {
 	ListenHub // 提供Listen实现
 	Dialer    // 提供Dial、DialDomain、DialIP实现
 	Pinger    // 提供PingDomain实现
+	Messenger // 提供与switcher之间的控制消息
 	StreamHub // 处理Data、DataAck、Close、CloseAck
+	ServeMux  // 提供Handle、HandleFunc实现
 	logger    *slog.Logger
 
 	network string
@@ -50,7 +58,11 @@ type Node struct {
 	writtenDataSize int64
 	readDataSize    int64
 
+	config     Config
+	configErr  error // New 收到的选项非法，Serve 直接返回
 	flowConfig FlowConfig
+
+	relayHandler atomic.Pointer[func(*packet.Buffer)]
 }
 
 type NodeInfo struct {
@@ -67,33 +79,60 @@ type ListenerInfo struct {
 	Addr string `json:"addr"`
 }
 
-func New(conn packet.Conn) *Node {
-	portm, _ := idpool.New(1000, 0xFFFF)
-	return NewWithOptions(conn, portm, DefaultHeartbeatInterval)
+// New creates a node on conn with DefaultConfig changed by opts. If opts
+// produce an invalid configuration, Serve returns the error instead of
+// running with other settings; use NewWithConfig to check it up front.
+func New(conn packet.Conn, opts ...Option) *Node {
+	cfg := DefaultConfig()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	node, err := NewWithConfig(conn, cfg)
+	if err != nil {
+		node, _ = NewWithConfig(conn, DefaultConfig())
+		node.configErr = err
+	}
+	return node
 }
 
+// NewWithOptions creates a node with the port pool portm.
+//
+// Deprecated: use NewWithConfig or New with WithPortRange and WithHeartbeat.
 func NewWithOptions(conn packet.Conn, portm *idpool.Pool, heartbeatInterval time.Duration) *Node {
+	cfg := DefaultConfig()
+	cfg.HeartbeatInterval = heartbeatInterval
+	return newNode(conn, portm, cfg.withDefaults())
+}
+
+func newNode(conn packet.Conn, portm *idpool.Pool, cfg Config) *Node {
 	node := &Node{
-		Conn:   conn,
-		done:   make(chan struct{}),
-		logger: slog.Default(),
+		Conn:       conn,
+		done:       make(chan struct{}),
+		logger:     cfg.Logger,
+		config:     cfg,
+		flowConfig: cfg.Flow,
 	}
 
 	node.ListenHub.init(node, portm)
 	node.Dialer.init(node, portm)
 	node.Pinger.init(node)
+	node.Messenger.init(node)
 	node.StreamHub.init(node, portm)
-	node.Heartbeat.init(node, heartbeatInterval)
+	node.Heartbeat.init(node, cfg.HeartbeatInterval)
 	node.Dispatcher.init(node)
+	node.ServeMux.init(&node.ListenHub, func() *slog.Logger { return node.logger }, node.done)
 
 	node.Heartbeat.SetChecker(func() error {
-		_, err := node.PingDomain("", time.Second*2)
+		_, err := node.PingDomain("", cfg.HeartbeatTimeout)
 		return err
 	})
 
 	return node
 }
 
+// GetConfig 返回创建节点时使用的配置
+func (node *Node) GetConfig() Config { return node.config }
+
 func (node *Node) SetNetwork(n string)     { node.network = n }
 func (node *Node) GetNetwork() string      { return node.network }
 func (node *Node) SetDomain(domain string) { node.domain = domain }
@@ -105,6 +144,20 @@ func (node *Node) SetLogger(l *slog.Logger) {
 		node.logger = l
 	}
 }
+
+// SetRelayHandler 设置中继处理函数：收到的目标 IP 不属于本节点的数据包交给 fn 处理，
+// 用于在节点之上为下游节点提供转发（见 switcher.Relay）。fn 为 nil 时取消。
+func (node *Node) SetRelayHandler(fn func(*packet.Buffer)) {
+	if fn == nil {
+		node.relayHandler.Store(nil)
+		return
+	}
+	node.relayHandler.Store(&fn)
+}
+
+// Done 返回在节点关闭时被关闭的 channel
+func (node *Node) Done() <-chan struct{} { return node.done }
+
 func (node *Node) GetReadWriteSize() (read, written int64) {
 	return node.readDataSize, node.writtenDataSize
 }
@@ -137,6 +190,15 @@ func (node *Node) SetFlowConfig(cfg FlowConfig) {
 }
 
 func (node *Node) GetWindowSize() int32 {
+	if node.flowConfig.AutoTune {
+		// 自动调整时从 BDP 或默认窗口开始
+		size := stream.DefaultWindowSize
+		if node.flowConfig.Bandwidth > 0 && node.flowConfig.RTT > 0 {
+			size = int32(node.flowConfig.Bandwidth * int64(node.flowConfig.RTT) / int64(time.Second))
+		}
+		minSize, maxSize := node.windowBounds()
+		return min(max(size, minSize), maxSize)
+	}
 	if node.flowConfig.MaxWindowSize > 0 {
 		return node.flowConfig.MaxWindowSize
 	}
@@ -147,7 +209,49 @@ func (node *Node) GetWindowSize() int32 {
 	return 0 // uses default in stream package
 }
 
+// windowBounds 返回自动调整窗口的范围
+func (node *Node) windowBounds() (minSize, maxSize int32) {
+	minSize, maxSize = node.flowConfig.MinWindowSize, node.flowConfig.MaxWindowSize
+	if minSize <= 0 {
+		minSize = stream.DefaultMinWindowSize
+	}
+	if maxSize <= 0 {
+		maxSize = stream.DefaultMaxWindowSize
+	}
+	return min(minSize, maxSize), maxSize
+}
+
+// maxWindowSize 返回在 open 请求和应答中声明的最大接收窗口。总是声明一个非 0 值，
+// 让对端知道本端支持打开后调整窗口
+func (node *Node) maxWindowSize() uint32 {
+	if node.flowConfig.AutoTune {
+		_, maxSize := node.windowBounds()
+		return uint32(maxSize)
+	}
+	if size := node.GetWindowSize(); size > 0 {
+		return uint32(size)
+	}
+	return uint32(stream.DefaultWindowSize)
+}
+
+// setupStream 按节点配置设置新建的 stream，peerMaxWindow 是对端在 open 时
+// 声明的最大接收窗口
+func (node *Node) setupStream(s *stream.Stream, peerMaxWindow uint32) {
+	s.SetCloseAckTimeout(node.config.CloseAckTimeout)
+	s.SetAckPolicy(node.config.AckThreshold, node.config.AckDelay)
+	if peerMaxWindow == 0 {
+		return // 旧版本对端只接受固定窗口
+	}
+	s.SetPeerMaxWindow(int32(min(peerMaxWindow, 1<<31-1)))
+	if node.flowConfig.AutoTune {
+		s.SetWindowTuning(node.windowBounds())
+	}
+}
+
 func (node *Node) Serve() error {
+	if node.configErr != nil {
+		return node.configErr
+	}
 	err := node.Dispatcher.start()
 	if err != nil {
 		return err
@@ -202,7 +306,7 @@ func (node *Node) WriteBuffer(pbuf *packet.Buffer) error {
 
 func (node *Node) readLoop() error {
 	for {
-		node.SetReadTimeout(time.Minute * 15)
+		node.SetReadTimeout(node.config.ReadTimeout)
 		pbuf, err := node.ReadBuffer()
 		if err != nil {
 			return err
@@ -210,6 +314,13 @@ func (node *Node) readLoop() error {
 
 		atomic.AddInt64(&node.readDataSize, int64(pbuf.PayloadSize()))
 
+		if distIP := pbuf.DistIP(); distIP != node.ip && distIP != packet.LocalIP {
+			if fn := node.relayHandler.Load(); fn != nil {
+				(*fn)(pbuf)
+				continue
+			}
+		}
+
 		err = node.Dispatcher.dispatch(pbuf)
 		if err != nil {
 			return err
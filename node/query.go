@@ -0,0 +1,13 @@
+package node
+
+import "github.com/net-agent/flex/v3/packet"
+
+// QueryNodes 返回 switcher 上标签与 sel 匹配的节点，sel 为空时返回所有节点
+func (node *Node) QueryNodes(sel string) ([]packet.NodeRecord, error) {
+	var records []packet.NodeRecord
+	err := node.Request(packet.TopicNodeQuery, packet.NodeQuery{Selector: sel}, &records, DefaultRequestTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
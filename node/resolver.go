@@ -0,0 +1,92 @@
+package node
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/net-agent/flex/v3/packet"
+)
+
+type resolverEntry struct {
+	record  packet.DNSRecord
+	expires time.Time
+}
+
+// Resolver 通过 DNSIP 上的解析服务把域名解析为 IP，并按 TTL 缓存结果。
+// switcher 上域名对应的节点下线时会推送 dns.invalidate，缓存随之失效。
+// 通过 Dialer.SetResolver 启用后，Dial 使用解析结果走 DialIP。
+type Resolver struct {
+	host *Node
+
+	mu    sync.Mutex
+	cache map[string]resolverEntry
+}
+
+// NewResolver 创建 host 的解析器，并接管 host 上 dns.invalidate 消息的处理
+func NewResolver(host *Node) *Resolver {
+	r := &Resolver{
+		host:  host,
+		cache: make(map[string]resolverEntry),
+	}
+	host.HandleMessage(packet.TopicDNSInvalidate, r.handleInvalidate)
+	return r
+}
+
+func resolverKey(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// Resolve 返回 name 的解析结果，优先使用未过期的缓存
+func (r *Resolver) Resolve(name string) (packet.DNSRecord, error) {
+	key := resolverKey(name)
+	r.mu.Lock()
+	entry, ok := r.cache[key]
+	r.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.record, nil
+	}
+
+	var record packet.DNSRecord
+	err := r.host.request(packet.DNSIP, packet.TopicDNSResolve, packet.DNSQuery{Name: name}, &record, DefaultRequestTimeout)
+	if err != nil {
+		return packet.DNSRecord{}, err
+	}
+
+	r.mu.Lock()
+	if record.TTL > 0 {
+		r.cache[key] = resolverEntry{record: record, expires: time.Now().Add(time.Duration(record.TTL) * time.Second)}
+	} else {
+		delete(r.cache, key)
+	}
+	r.mu.Unlock()
+	return record, nil
+}
+
+// Invalidate 删除 name 的缓存
+func (r *Resolver) Invalidate(name string) {
+	r.mu.Lock()
+	delete(r.cache, resolverKey(name))
+	r.mu.Unlock()
+}
+
+// Flush 清空所有缓存
+func (r *Resolver) Flush() {
+	r.mu.Lock()
+	r.cache = make(map[string]resolverEntry)
+	r.mu.Unlock()
+}
+
+func (r *Resolver) handleInvalidate(msg *packet.Message) {
+	var inv packet.DNSInvalidation
+	if err := msg.Unmarshal(&inv); err != nil {
+		r.host.logger.Warn("decode dns invalidation failed", "error", err)
+		return
+	}
+	key := resolverKey(inv.Name)
+	r.mu.Lock()
+	if entry, ok := r.cache[key]; ok && entry.record.IP == inv.IP {
+		delete(r.cache, key)
+	}
+	r.mu.Unlock()
+}
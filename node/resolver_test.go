@@ -0,0 +1,81 @@
+package node
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/net-agent/flex/v3/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolverCache(t *testing.T) {
+	n1, n2 := Pipe("test1", "test2")
+	var queries int32
+	replyMessages(n2, func(msg *packet.Message) *packet.Message {
+		atomic.AddInt32(&queries, 1)
+		var q packet.DNSQuery
+		msg.Unmarshal(&q)
+		record := packet.DNSRecord{Name: q.Name, Domain: q.Name, IP: 7, TTL: 60}
+		switch q.Name {
+		case "grp":
+			record = packet.DNSRecord{Name: q.Name, Domain: "member", IP: 8, Alias: true}
+		case "none":
+			return &packet.Message{Topic: msg.Topic, Error: "domain not found"}
+		}
+		resp, _ := packet.NewMessage(msg.Topic, record)
+		return resp
+	})
+	invalidate := func(name string, ip uint16) {
+		msg, _ := packet.NewMessage(packet.TopicDNSInvalidate, packet.DNSInvalidation{Name: name, IP: ip})
+		payload, _ := msg.Encode()
+		pbuf := packet.NewBufferWithCmd(packet.CmdPushMessage)
+		pbuf.SetSrc(packet.DNSIP, 0)
+		pbuf.SetDist(n1.GetIP(), 0)
+		_ = pbuf.SetPayload(payload)
+		n2.WriteBuffer(pbuf)
+	}
+	r := NewResolver(n1)
+
+	record, err := r.Resolve("svc")
+	assert.Nil(t, err)
+	assert.Equal(t, uint16(7), record.IP)
+	_, err = r.Resolve(" SVC ")
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&queries))
+
+	// TTL 为 0 的应答不缓存
+	for i := 0; i < 2; i++ {
+		record, err = r.Resolve("grp")
+		assert.Nil(t, err)
+		assert.Equal(t, "member", record.Domain)
+	}
+	assert.Equal(t, int32(3), atomic.LoadInt32(&queries))
+
+	_, err = r.Resolve("none")
+	assert.NotNil(t, err)
+
+	// 推送与请求经同一队列按序处理，之后的请求返回时推送已生效；IP 不符的推送被忽略
+	invalidate("svc", 9)
+	r.Resolve("grp")
+	r.Resolve("svc")
+	assert.Equal(t, int32(5), atomic.LoadInt32(&queries))
+
+	invalidate("svc", 7)
+	r.Resolve("grp")
+	r.Resolve("svc")
+	assert.Equal(t, int32(7), atomic.LoadInt32(&queries))
+
+	r.Invalidate("svc")
+	r.Resolve("svc")
+	r.Flush()
+	r.Resolve("svc")
+	assert.Equal(t, int32(9), atomic.LoadInt32(&queries))
+
+	// 过期后重新解析
+	r.mu.Lock()
+	r.cache["svc"] = resolverEntry{record: record, expires: time.Now().Add(-time.Second)}
+	r.mu.Unlock()
+	r.Resolve("svc")
+	assert.Equal(t, int32(10), atomic.LoadInt32(&queries))
+}
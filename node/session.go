@@ -1,6 +1,7 @@
 package node
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log/slog"
@@ -26,6 +27,8 @@ type SessionConfig struct {
 	Domain   string
 	Password string
 	Mac      string
+	Labels   map[string]string // 节点标签，供 DialSelector/QueryNodes 按选择器匹配
+	Options  []Option          // 每次重连创建 Node 时使用的配置
 }
 
 // Session 是一个带有断线重连能力的 Node 代理。
@@ -34,11 +37,14 @@ type Session struct {
 	connector ConnectFunc
 	config    SessionConfig
 
+	ServeMux // 提供Handle、HandleFunc实现，注册的端口跨重连存活
+
 	enableFairConn atomic.Bool
 
 	mu        sync.RWMutex
 	node      *Node
 	listeners map[uint16]*SessionListener
+	groups    map[string]struct{}
 	ready     chan struct{} // closed when node is ready
 
 	trigger   chan struct{} // closed on first Listen/Dial to start connecting
@@ -54,12 +60,14 @@ func NewSession(connector ConnectFunc, cfg SessionConfig) *Session {
 		connector: connector,
 		config:    cfg,
 		listeners: make(map[uint16]*SessionListener),
+		groups:    make(map[string]struct{}),
 		ready:     make(chan struct{}),
 		trigger:   make(chan struct{}),
 		done:      make(chan struct{}),
 		logger:    slog.Default(),
 	}
 	s.enableFairConn.Store(true)
+	s.ServeMux.init(s, func() *slog.Logger { return s.logger }, s.done)
 	return s
 }
 
@@ -79,6 +87,14 @@ func (s *Session) SetLogger(l *slog.Logger) {
 // Listen 注册一个端口监听。该监听跨重连存活，Node 重建后自动重新注册。
 // 首次调用会触发 Serve 开始连接。
 func (s *Session) Listen(port uint16) (net.Listener, error) {
+	return s.listen(port, nil)
+}
+
+func (s *Session) listen(port uint16, authorize AuthorizeFunc) (net.Listener, error) {
+	cfg, err := s.nodeConfig()
+	if err != nil {
+		return nil, err
+	}
 	s.ensureServing()
 
 	s.mu.Lock()
@@ -91,14 +107,15 @@ func (s *Session) Listen(port uint16) (net.Listener, error) {
 	sl := &SessionListener{
 		port:    port,
 		session: s,
-		streams: make(chan *stream.Stream, 32),
+		streams: make(chan *stream.Stream, cfg.ListenBacklog),
 		done:    make(chan struct{}),
 	}
+	sl.SetAuthorizer(authorize)
 	s.listeners[port] = sl
 
 	// 如果 Node 已经在运行，立即注册并启动桥接
 	if s.node != nil {
-		nl, err := s.node.Listen(port)
+		nl, err := s.node.listen(port, sl.authorize)
 		if err != nil {
 			delete(s.listeners, port)
 			return nil, err
@@ -123,6 +140,43 @@ func (s *Session) Dial(addr string) (*stream.Stream, error) {
 	return n.Dial(addr)
 }
 
+// JoinGroup 将 Session 加入服务组。与 Listen 一样跨重连存活，Node 重建后自动重新加入。
+func (s *Session) JoinGroup(group string) error {
+	s.ensureServing()
+
+	s.mu.Lock()
+	s.groups[group] = struct{}{}
+	n := s.node
+	s.mu.Unlock()
+
+	if n == nil {
+		return nil
+	}
+	return n.JoinGroup(group)
+}
+
+// LeaveGroup 将 Session 移出服务组
+func (s *Session) LeaveGroup(group string) error {
+	s.mu.Lock()
+	delete(s.groups, group)
+	n := s.node
+	s.mu.Unlock()
+
+	if n == nil {
+		return nil
+	}
+	return n.LeaveGroup(group)
+}
+
+// rejoinGroups 在 Node 重建后重新加入服务组
+func (s *Session) rejoinGroups(node *Node, groups []string) {
+	for _, group := range groups {
+		if err := node.JoinGroup(group); err != nil {
+			s.logger.Warn("rejoin group failed", "group", group, "error", err)
+		}
+	}
+}
+
 // WaitReady 阻塞等待 Node 就绪（已连接）。可用于在 Dial 前等待重连完成。
 func (s *Session) WaitReady(timeout time.Duration) error {
 	s.mu.RLock()
@@ -156,7 +210,12 @@ func (s *Session) ensureServing() {
 
 // Serve 启动重连循环。阻塞直到 Close 被调用。
 // 实际连接在首次 Listen 或 Dial 调用时才开始（懒连接）。
+// SessionConfig.Options 产生非法配置时立即返回 ErrInvalidConfig。
 func (s *Session) Serve() error {
+	if _, err := s.nodeConfig(); err != nil {
+		return err
+	}
+
 	// 等待首次使用触发
 	select {
 	case <-s.trigger:
@@ -185,7 +244,7 @@ func (s *Session) Serve() error {
 			continue
 		}
 
-		ip, err := admit.Handshake(conn, s.config.Domain, s.config.Mac, s.config.Password)
+		ip, err := admit.HandshakeWithLabels(conn, s.config.Domain, s.config.Mac, s.config.Password, s.config.Labels)
 		if err != nil {
 			conn.Close()
 			s.logger.Warn("handshake failed", "error", err, "retry_in", backoff)
@@ -202,7 +261,7 @@ func (s *Session) Serve() error {
 			conn = sched.NewFairConn(conn)
 		}
 
-		node := New(conn)
+		node := New(conn, s.config.Options...)
 		node.SetIP(ip)
 		node.SetDomain(s.config.Domain)
 
@@ -211,16 +270,24 @@ func (s *Session) Serve() error {
 		s.mu.Lock()
 		s.node = node
 		for port, sl := range s.listeners {
-			nl, err := node.Listen(port)
+			nl, err := node.listen(port, sl.authorize)
 			if err != nil {
 				s.logger.Warn("register listener failed", "port", port, "error", err)
 				continue
 			}
 			go s.bridge(sl, nl)
 		}
+		groups := make([]string, 0, len(s.groups))
+		for group := range s.groups {
+			groups = append(groups, group)
+		}
 		close(s.ready) // 唤醒所有等待者
 		s.mu.Unlock()
 
+		if len(groups) > 0 {
+			go s.rejoinGroups(node, groups)
+		}
+
 		node.Serve() // 阻塞直到断线
 
 		s.mu.Lock()
@@ -284,9 +351,33 @@ type SessionListener struct {
 	streams chan *stream.Stream
 	done    chan struct{}
 	once    sync.Once
+
+	authorizer atomic.Pointer[AuthorizeFunc]
+}
+
+// SetAuthorizer 设置新连接的鉴权函数，跨重连生效，见 Listener.SetAuthorizer
+func (sl *SessionListener) SetAuthorizer(fn AuthorizeFunc) {
+	if fn == nil {
+		sl.authorizer.Store(nil)
+		return
+	}
+	sl.authorizer.Store(&fn)
+}
+
+func (sl *SessionListener) authorize(remoteDomain string, remoteIP uint16, metadata map[string]string) error {
+	fn := sl.authorizer.Load()
+	if fn == nil {
+		return nil
+	}
+	return (*fn)(remoteDomain, remoteIP, metadata)
 }
 
 func (sl *SessionListener) Accept() (net.Conn, error) {
+	return sl.AcceptContext(context.Background())
+}
+
+// AcceptContext 等待下一个连接，ctx 取消时返回 ctx.Err()
+func (sl *SessionListener) AcceptContext(ctx context.Context) (net.Conn, error) {
 	select {
 	case s, ok := <-sl.streams:
 		if !ok {
@@ -297,6 +388,8 @@ func (sl *SessionListener) Accept() (net.Conn, error) {
 		return nil, ErrListenerClosed
 	case <-sl.session.done:
 		return nil, ErrSessionClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
 }
 
@@ -311,3 +404,15 @@ func (sl *SessionListener) Close() error {
 func (sl *SessionListener) Addr() net.Addr  { return sl }
 func (sl *SessionListener) Network() string { return "flex" }
 func (sl *SessionListener) String() string  { return fmt.Sprintf("session:%d", sl.port) }
+
+// nodeConfig 返回 SessionConfig.Options 生效后的 Node 配置
+func (s *Session) nodeConfig() (Config, error) {
+	cfg := DefaultConfig()
+	for _, opt := range s.config.Options {
+		opt(&cfg)
+	}
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg.withDefaults(), nil
+}
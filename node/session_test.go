@@ -25,9 +25,11 @@
 package node
 
 import (
+	"context"
 	"errors"
 	"log/slog"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -605,3 +607,101 @@ func TestSessionRemoveListenerNilNode(t *testing.T) {
 	s.removeListener(80)
 	assert.Empty(t, s.listeners)
 }
+
+// --- Group ---
+
+func TestSessionJoinGroupBeforeServe(t *testing.T) {
+	s := NewSession(nil, SessionConfig{})
+
+	assert.Nil(t, s.JoinGroup("svc"))
+	assert.Contains(t, s.groups, "svc")
+
+	assert.Nil(t, s.LeaveGroup("svc"))
+	assert.NotContains(t, s.groups, "svc")
+}
+
+func TestSessionRejoinGroups(t *testing.T) {
+	n1, n2 := Pipe("test1", "test2")
+	s := NewSession(nil, SessionConfig{})
+
+	var mu sync.Mutex
+	var joined []string
+	replyMessages(n2, func(msg *packet.Message) *packet.Message {
+		var req packet.GroupRequest
+		msg.Unmarshal(&req)
+		mu.Lock()
+		joined = append(joined, msg.Topic+":"+req.Group)
+		mu.Unlock()
+		return &packet.Message{Topic: msg.Topic}
+	})
+
+	s.rejoinGroups(n1, []string{"a", "b"})
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"group.join:a", "group.join:b"}, joined)
+}
+
+func TestSessionListenerAcceptContext(t *testing.T) {
+	s := NewSession(nil, SessionConfig{})
+	sl, err := s.Listen(80)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer sl.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	_, err = sl.(*SessionListener).AcceptContext(ctx)
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestSessionListenerAuthorize(t *testing.T) {
+	connector, getServers := newTestConnector()
+	s := NewSession(connector, testSessionConfig())
+	l, err := s.Listen(80)
+	if !assert.Nil(t, err) {
+		return
+	}
+	sl := l.(*SessionListener)
+	var allow atomic.Bool
+	sl.SetAuthorizer(func(string, uint16, map[string]string) error {
+		if !allow.Load() {
+			return ErrAccessDenied
+		}
+		return nil
+	})
+
+	go s.Serve()
+	defer s.Close()
+	assert.Nil(t, s.WaitReady(time.Second))
+
+	server := getServers()[0]
+	_, err = server.DialIP(1, 80)
+	assert.ErrorIs(t, err, ErrAccessDenied)
+
+	allow.Store(true)
+	go func() {
+		if c, err := sl.Accept(); err == nil {
+			c.Close()
+		}
+	}()
+	st, err := server.DialIP(1, 80)
+	if assert.Nil(t, err) {
+		st.Close()
+	}
+
+	// 重连后鉴权继续生效
+	server.Close()
+	time.Sleep(100 * time.Millisecond)
+	assert.Nil(t, s.WaitReady(5*time.Second))
+	servers := getServers()
+	if !assert.GreaterOrEqual(t, len(servers), 2) {
+		return
+	}
+	allow.Store(false)
+	_, err = servers[1].DialIP(1, 80)
+	assert.ErrorIs(t, err, ErrAccessDenied)
+}
@@ -98,8 +98,8 @@ func (hub *StreamHub) scheduleStreamCleanup(sid uint64, s *stream.Stream) {
 func (hub *StreamHub) closeAllStreams() {
 	hub.streams.Range(func(key, value interface{}) bool {
 		if s, ok := value.(*stream.Stream); ok {
-			s.CloseRead()
-			s.CloseWrite()
+			// 连接已不可用，不再通知对端
+			s.CloseLocal()
 		}
 		hub.streams.Delete(key)
 		return true
@@ -147,7 +147,8 @@ func (hub *StreamHub) handleCmdPushStreamData(pbuf *packet.Buffer) {
 		return
 	}
 
-	c.HandleCmdPushStreamData(pbuf)
+	// 每个 stream 独立投递，读取缓慢的 stream 不会阻塞分发协程
+	c.Deliver(pbuf)
 }
 
 // 处理数据包已送达的消息
@@ -174,7 +175,8 @@ func (hub *StreamHub) handleCmdCloseStream(pbuf *packet.Buffer) {
 		}
 		return
 	}
-	c.HandleCmdCloseStream(pbuf)
+	// 与数据包走同一个投递队列，保证在已收到的数据之后处理
+	c.Deliver(pbuf)
 }
 
 func (hub *StreamHub) handleAckCloseStream(pbuf *packet.Buffer) {
@@ -182,7 +184,7 @@ func (hub *StreamHub) handleAckCloseStream(pbuf *packet.Buffer) {
 	if err != nil {
 		return
 	}
-	c.HandleAckCloseStream(pbuf)
+	c.Deliver(pbuf)
 }
 
 func (hub *StreamHub) recordClosedState(state *stream.State) {
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"io"
 	"log"
+	"net"
 	"sync"
 	"testing"
 	"time"
@@ -173,10 +174,7 @@ func TestAttachStream_OnDetachReleasesPortAndRecordsState(t *testing.T) {
 	err = hub.attachStream(s, sid)
 	assert.Nil(t, err)
 
-	err = s.CloseRead()
-	assert.Nil(t, err)
-	err = s.CloseWrite()
-	assert.Nil(t, err)
+	s.CloseLocal()
 
 	waitForCondition(t, 5*time.Second, func() bool {
 		_, err = hub.getStream(sid)
@@ -221,10 +219,63 @@ func TestAttachStream_OnDetachDoesNotDeleteReusedSID(t *testing.T) {
 	s2.SetBoundPort(port2)
 	assert.Nil(t, hub.attachStream(s2, sid))
 
-	assert.Nil(t, s1.CloseRead())
-	assert.Nil(t, s1.CloseWrite())
+	s1.CloseLocal()
 
 	got, err := hub.getStream(sid)
 	assert.Nil(t, err)
 	assert.Equal(t, s2, got, "old stream detach callback must not remove the new stream under same SID")
 }
+
+func TestSlowReaderDoesNotBlockOtherStreams(t *testing.T) {
+	n1, n2 := Pipe("test1", "test2")
+	defer n1.Close()
+	defer n2.Close()
+
+	l, err := n2.Listen(80)
+	assert.Nil(t, err)
+	defer l.Close()
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- c
+		}
+	}()
+
+	// slow 的对端不读取，小包写满接收队列
+	slow, err := n1.DialDomain("test2", 80)
+	assert.Nil(t, err)
+	slowPeer := <-accepted
+	defer slowPeer.Close()
+	for i := 0; i < 2000; i++ {
+		_, err := slow.Write([]byte("x"))
+		assert.Nil(t, err)
+	}
+
+	fast, err := n1.DialDomain("test2", 80)
+	assert.Nil(t, err)
+	fastPeer := <-accepted
+	payload := bytes.Repeat([]byte("fast"), 64*1024)
+	go func() {
+		fast.Write(payload)
+		fast.CloseWrite()
+	}()
+
+	start := time.Now()
+	got, err := io.ReadAll(fastPeer)
+	assert.Nil(t, err)
+	assert.Equal(t, payload, got)
+	assert.Less(t, time.Since(start), time.Second, "slow reader must not stall other streams")
+
+	st := slowPeer.(*stream.Stream).GetState()
+	assert.Greater(t, st.DeliverBacklog, int32(0))
+
+	// 读取后积压的包继续投递，等待时间被记录
+	_, err = io.ReadFull(slowPeer, make([]byte, 2000))
+	assert.Nil(t, err)
+	st = slowPeer.(*stream.Stream).GetState()
+	assert.Greater(t, st.DeliverBlocked, time.Duration(0))
+}
@@ -0,0 +1,89 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUnsupportedNetwork = errors.New("unsupported network")
+)
+
+// HostSuffix 是 URL 中 flex 域名的可选后缀，"node-a.flex:8080" 与 "node-a:8080" 等价
+const HostSuffix = ".flex"
+
+var (
+	DefaultTransportIdleTimeout = 90 * time.Second
+	DefaultTransportIdlePerHost = 8
+)
+
+// ContextDialer dials flex addresses in the net.Dialer.DialContext style.
+// *Node and *Session implement it.
+type ContextDialer interface {
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// flexAddr 校验网络类型并去掉主机名的 HostSuffix
+func flexAddr(network, addr string) (string, error) {
+	switch network {
+	case "", "tcp", "tcp4", "tcp6", "flex":
+	default:
+		return "", ErrUnsupportedNetwork
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if h, ok := strings.CutSuffix(strings.ToLower(host), HostSuffix); ok && h != "" {
+		host = h
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// DialContext is Node.DialContext over the session.
+func (s *Session) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	s.ensureServing()
+
+	s.mu.RLock()
+	n := s.node
+	s.mu.RUnlock()
+	if n == nil {
+		return nil, ErrSessionDisconnected
+	}
+	return n.DialContext(ctx, network, addr)
+}
+
+// Transport is an http.RoundTripper that sends requests over flex streams.
+// URL hosts are flex addresses, e.g. "http://node-a.flex:8080/" or
+// "http://node-a:8080/". Streams are pooled per host with keep-alive and
+// closed after DefaultTransportIdleTimeout without use.
+type Transport struct {
+	base *http.Transport
+}
+
+// NewTransport creates a Transport dialing through d.
+func NewTransport(d ContextDialer) *Transport {
+	return &Transport{base: &http.Transport{
+		DialContext:         d.DialContext,
+		MaxIdleConnsPerHost: DefaultTransportIdlePerHost,
+		IdleConnTimeout:     DefaultTransportIdleTimeout,
+	}}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.base.RoundTrip(req)
+}
+
+// CloseIdleConnections closes the pooled streams that are not in use.
+func (t *Transport) CloseIdleConnections() {
+	t.base.CloseIdleConnections()
+}
+
+// NewHTTPClient returns an http.Client using a new Transport over d.
+func NewHTTPClient(d ContextDialer) *http.Client {
+	return &http.Client{Transport: NewTransport(d)}
+}
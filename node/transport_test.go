@@ -0,0 +1,133 @@
+package node
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/net-agent/flex/v3/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlexAddr(t *testing.T) {
+	cases := []struct {
+		network, addr, want string
+		ok                  bool
+	}{
+		{"tcp", "node-a.flex:8080", "node-a:8080", true},
+		{"tcp", "NODE-A.FLEX:80", "node-a:80", true},
+		{"tcp4", "node-a:80", "node-a:80", true},
+		{"flex", "2:80", "2:80", true},
+		{"tcp", ".flex:80", ".flex:80", true},
+		{"udp", "node-a:80", "", false},
+		{"tcp", "node-a", "", false},
+	}
+	for _, c := range cases {
+		got, err := flexAddr(c.network, c.addr)
+		if c.ok {
+			assert.Nil(t, err, c.addr)
+			assert.Equal(t, c.want, got)
+		} else {
+			assert.NotNil(t, err, c.addr)
+		}
+	}
+}
+
+func TestDialContext(t *testing.T) {
+	n1, n2 := Pipe("test1", "test2")
+	defer n1.Close()
+	defer n2.Close()
+
+	l, err := n2.Listen(80)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+
+	c, err := n1.DialContext(context.Background(), "tcp", "test2.flex:80")
+	if assert.Nil(t, err) {
+		c.Close()
+	}
+	_, err = n1.DialContext(context.Background(), "udp", "test2:80")
+	assert.Equal(t, ErrUnsupportedNetwork, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = n1.DialContext(ctx, "tcp", "test2:80")
+	assert.Equal(t, context.Canceled, err)
+
+	// 对端不应答时按 ctx 超时返回
+	c1, c2 := packet.Pipe()
+	go func() {
+		for {
+			if _, err := c2.ReadBuffer(); err != nil {
+				return
+			}
+		}
+	}()
+	n := New(c1)
+	n.SetIP(1)
+	go n.Serve()
+	defer n.Close()
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = n.DialContext(ctx, "tcp", "test2:80")
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestTransport(t *testing.T) {
+	n1, n2 := Pipe("test1", "test2")
+	defer n1.Close()
+	defer n2.Close()
+
+	l, err := n2.Listen(8080)
+	if !assert.Nil(t, err) {
+		return
+	}
+	var conns atomic.Int32
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "hello "+r.Host)
+		}),
+		ConnState: func(_ net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				conns.Add(1)
+			}
+		},
+	}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	tr := NewTransport(n1)
+	defer tr.CloseIdleConnections()
+	client := &http.Client{Transport: tr}
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get("http://test2.flex:8080/")
+		if !assert.Nil(t, err) {
+			return
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, "hello test2.flex:8080", string(body))
+	}
+	// 请求之间复用同一个 stream
+	assert.Equal(t, int32(1), conns.Load())
+
+	_, err = client.Get("http://test2:8081/")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,17 @@
+package node
+
+import "github.com/net-agent/flex/v3/packet"
+
+// OpenTunnel 请求 switcher 在公网 TCP 端口 port 上监听，并将连接转发到本节点的 targetPort。
+// port 为 0 时由 switcher 在允许的范围内随机尝试有限个端口，都被占用时返回错误。返回 switcher 实际监听的地址与端口。
+// 隧道在节点断开时自动关闭。
+func (node *Node) OpenTunnel(port, targetPort uint16) (packet.TunnelResponse, error) {
+	var resp packet.TunnelResponse
+	err := node.Request(packet.TopicTunnelOpen, packet.TunnelRequest{Port: port, TargetPort: targetPort}, &resp, DefaultRequestTimeout)
+	return resp, err
+}
+
+// CloseTunnel 关闭本节点通过 OpenTunnel 申请的隧道
+func (node *Node) CloseTunnel(port uint16) error {
+	return node.Request(packet.TopicTunnelClose, packet.TunnelRequest{Port: port}, nil, DefaultRequestTimeout)
+}
@@ -0,0 +1,415 @@
+package node
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/net-agent/flex/v3/stream"
+)
+
+var (
+	ErrDatagramTooLarge = errors.New("datagram too large")
+)
+
+const (
+	// MaxDatagramSize 是 stream 上单个数据报的长度上限（2 字节长度前缀）
+	MaxDatagramSize       = 0xffff
+	DefaultUDPIdleTimeout = time.Minute
+	udpFlowQueueSize      = 64
+	udpIdleCheckMinPeriod = 10 * time.Millisecond
+	udpIdleCheckMaxPeriod = time.Second
+)
+
+// writeDatagram 以 2 字节长度前缀在 stream 上发送一个数据报
+func writeDatagram(w io.Writer, p []byte) error {
+	if len(p) > MaxDatagramSize {
+		return ErrDatagramTooLarge
+	}
+	buf := make([]byte, 2+len(p))
+	binary.BigEndian.PutUint16(buf, uint16(len(p)))
+	copy(buf[2:], p)
+	_, err := w.Write(buf)
+	return err
+}
+
+// readDatagram 读取一个数据报到 buf；超过 buf 长度的数据报被丢弃并返回 ErrDatagramTooLarge
+func readDatagram(r io.Reader, buf []byte) (int, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, err
+	}
+	n := int(binary.BigEndian.Uint16(hdr[:]))
+	if n > len(buf) {
+		// 跳过数据以保持帧同步
+		if _, err := io.CopyN(io.Discard, r, int64(n)); err != nil {
+			return 0, err
+		}
+		return 0, ErrDatagramTooLarge
+	}
+	_, err := io.ReadFull(r, buf[:n])
+	return n, err
+}
+
+// udpFlow 是一个 UDP 客户端地址对应的 stream
+type udpFlow struct {
+	*forwardConn
+	queue chan []byte // 本地端：等待写入 stream 的数据报
+	once  sync.Once
+	close func()
+}
+
+func (fl *udpFlow) shutdown() {
+	fl.once.Do(fl.close)
+}
+
+// UDPForwarder carries UDP datagrams over flex streams, one stream per UDP
+// client address (a flow). Datagrams are length-prefixed on the stream. Flows
+// with no traffic for the idle timeout are closed. It is created by
+// ForwardUDP or ForwardRemoteUDP.
+type UDPForwarder struct {
+	forwardTable
+	addr    net.Addr
+	closer  io.Closer
+	idle    atomic.Int64 // time.Duration
+	maxSize atomic.Int64
+	dropped atomic.Int64
+
+	flowsMu sync.Mutex
+	flows   map[int64]*udpFlow
+
+	done chan struct{}
+	once sync.Once
+}
+
+func newUDPForwarder(addr net.Addr, closer io.Closer, target string) *UDPForwarder {
+	f := &UDPForwarder{
+		addr:   addr,
+		closer: closer,
+		flows:  make(map[int64]*udpFlow),
+		done:   make(chan struct{}),
+	}
+	f.init(target)
+	f.idle.Store(int64(DefaultUDPIdleTimeout))
+	f.maxSize.Store(MaxDatagramSize)
+	go f.expireFlows()
+	return f
+}
+
+// SetIdleTimeout sets how long a flow may stay without traffic before it is
+// closed. The default is DefaultUDPIdleTimeout.
+func (f *UDPForwarder) SetIdleTimeout(d time.Duration) {
+	f.idle.Store(int64(d))
+}
+
+// SetMaxDatagramSize sets the largest datagram forwarded in either direction;
+// larger datagrams are dropped. It is capped at MaxDatagramSize.
+func (f *UDPForwarder) SetMaxDatagramSize(size int) {
+	if size <= 0 || size > MaxDatagramSize {
+		size = MaxDatagramSize
+	}
+	f.maxSize.Store(int64(size))
+}
+
+// Addr returns the local UDP address or the flex listen address.
+func (f *UDPForwarder) Addr() net.Addr {
+	return f.addr
+}
+
+// Close stops the forwarder and closes all flows.
+func (f *UDPForwarder) Close() error {
+	var err error
+	f.once.Do(func() {
+		close(f.done)
+		err = f.closer.Close()
+		for _, fl := range f.activeFlows() {
+			fl.shutdown()
+		}
+	})
+	return err
+}
+
+// Stats returns the totals of the forwarder; ActiveConns counts flows.
+func (f *UDPForwarder) Stats() ForwardStats {
+	st := f.stats(f.addr)
+	st.Dropped = f.dropped.Load()
+	return st
+}
+
+func (f *UDPForwarder) maxDatagram() int {
+	return int(f.maxSize.Load())
+}
+
+func (f *UDPForwarder) addFlow(source string, close func()) *udpFlow {
+	fl := &udpFlow{forwardConn: f.add(source), close: close}
+	f.flowsMu.Lock()
+	f.flows[fl.id] = fl
+	f.flowsMu.Unlock()
+	return fl
+}
+
+func (f *UDPForwarder) removeFlow(fl *udpFlow, err error) {
+	fl.shutdown()
+	f.flowsMu.Lock()
+	delete(f.flows, fl.id)
+	f.flowsMu.Unlock()
+	f.remove(fl.forwardConn, err)
+}
+
+func (f *UDPForwarder) activeFlows() []*udpFlow {
+	f.flowsMu.Lock()
+	defer f.flowsMu.Unlock()
+	flows := make([]*udpFlow, 0, len(f.flows))
+	for _, fl := range f.flows {
+		flows = append(flows, fl)
+	}
+	return flows
+}
+
+// expireFlows 关闭超过空闲时间的 flow
+func (f *UDPForwarder) expireFlows() {
+	for {
+		period := min(max(time.Duration(f.idle.Load())/4, udpIdleCheckMinPeriod), udpIdleCheckMaxPeriod)
+		select {
+		case <-f.done:
+			return
+		case <-time.After(period):
+		}
+		idle := time.Duration(f.idle.Load())
+		if idle <= 0 {
+			continue
+		}
+		for _, fl := range f.activeFlows() {
+			if time.Since(time.Unix(0, fl.counter.last.Load())) >= idle {
+				fl.shutdown()
+			}
+		}
+	}
+}
+
+// forwardUDP 在本地 UDP 地址上接收数据报，每个客户端地址通过 dial 建立一个到 target 的 stream
+func forwardUDP(dial func(string) (*stream.Stream, error), localAddr, target string) (*UDPForwarder, error) {
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		return nil, err
+	}
+	pc, err := net.ListenPacket("udp", localAddr)
+	if err != nil {
+		return nil, err
+	}
+	f := newUDPForwarder(pc.LocalAddr(), pc, target)
+	go f.serveLocal(pc, func() (net.Conn, error) { return dial(target) })
+	return f, nil
+}
+
+func (f *UDPForwarder) serveLocal(pc net.PacketConn, dial func() (net.Conn, error)) {
+	flows := make(map[string]*udpFlow) // client addr -> flow
+	var mu sync.Mutex
+	buf := make([]byte, MaxDatagramSize)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if n > f.maxDatagram() {
+			f.dropped.Add(1)
+			continue
+		}
+
+		key := addr.String()
+		mu.Lock()
+		fl := flows[key]
+		if fl == nil {
+			fl = f.startLocalFlow(pc, addr, dial, func(fl *udpFlow) {
+				mu.Lock()
+				if flows[key] == fl {
+					delete(flows, key)
+				}
+				mu.Unlock()
+			})
+			flows[key] = fl
+		}
+		mu.Unlock()
+
+		fl.counter.last.Store(time.Now().UnixNano())
+		select {
+		case fl.queue <- append([]byte(nil), buf[:n]...):
+		default:
+			f.dropped.Add(1) // stream 来不及发送，按 UDP 语义丢弃
+		}
+	}
+}
+
+// startLocalFlow 为客户端地址 addr 建立 flow：后台连接 target，然后双向转发数据报
+func (f *UDPForwarder) startLocalFlow(pc net.PacketConn, addr net.Addr, dial func() (net.Conn, error), onDone func(*udpFlow)) *udpFlow {
+	closed := make(chan struct{})
+	fl := f.addFlow(addr.String(), func() { close(closed) })
+	fl.queue = make(chan []byte, udpFlowQueueSize)
+
+	go func() {
+		defer onDone(fl)
+		conn, err := dial()
+		if err != nil {
+			f.failed.Add(1)
+			f.removeFlow(fl, err)
+			return
+		}
+		go func() {
+			<-closed
+			conn.Close()
+		}()
+
+		errs := make(chan error, 1)
+		go func() {
+			r := bufio.NewReader(conn)
+			buf := make([]byte, MaxDatagramSize)
+			for {
+				n, err := readDatagram(r, buf[:f.maxDatagram()])
+				if err == ErrDatagramTooLarge {
+					f.dropped.Add(1)
+					continue
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+				fl.counter.last.Store(time.Now().UnixNano())
+				if _, err := pc.WriteTo(buf[:n], addr); err == nil {
+					fl.counter.bToA.Add(int64(n))
+				}
+			}
+		}()
+
+		var err2 error
+		for err2 == nil {
+			select {
+			case p := <-fl.queue:
+				if err2 = writeDatagram(conn, p); err2 == nil {
+					fl.counter.aToB.Add(int64(len(p)))
+				}
+			case <-closed:
+				err2 = net.ErrClosed
+			case err2 = <-errs:
+			}
+		}
+		f.removeFlow(fl, flowError(err2))
+	}()
+	return fl
+}
+
+// forwardRemoteUDP 将 flex 端口上的每个 stream 作为一个 flow 转发到 UDP 地址 udpAddr
+func forwardRemoteUDP(l net.Listener, udpAddr string) *UDPForwarder {
+	f := newUDPForwarder(l.Addr(), l, udpAddr)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go f.serveRemoteFlow(conn, udpAddr)
+		}
+	}()
+	return f
+}
+
+func (f *UDPForwarder) serveRemoteFlow(conn net.Conn, udpAddr string) {
+	uc, err := net.Dial("udp", udpAddr)
+	if err != nil {
+		conn.Close()
+		f.fail(conn.RemoteAddr().String(), err)
+		return
+	}
+	fl := f.addFlow(conn.RemoteAddr().String(), func() {
+		conn.Close()
+		uc.Close()
+	})
+
+	errs := make(chan error, 2)
+	go func() {
+		buf := make([]byte, MaxDatagramSize)
+		for {
+			n, err := uc.Read(buf)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if n > f.maxDatagram() {
+				f.dropped.Add(1)
+				continue
+			}
+			fl.counter.last.Store(time.Now().UnixNano())
+			if err := writeDatagram(conn, buf[:n]); err != nil {
+				errs <- err
+				return
+			}
+			fl.counter.bToA.Add(int64(n))
+		}
+	}()
+	go func() {
+		r := bufio.NewReader(conn)
+		buf := make([]byte, MaxDatagramSize)
+		for {
+			n, err := readDatagram(r, buf[:f.maxDatagram()])
+			if err == ErrDatagramTooLarge {
+				f.dropped.Add(1)
+				continue
+			}
+			if err != nil {
+				errs <- err
+				return
+			}
+			fl.counter.last.Store(time.Now().UnixNano())
+			if _, err := uc.Write(buf[:n]); err == nil {
+				fl.counter.aToB.Add(int64(n))
+			}
+		}
+	}()
+
+	err = <-errs
+	f.removeFlow(fl, flowError(err))
+}
+
+// flowError 过滤 flow 正常结束（对端关闭、空闲超时）时产生的错误
+func flowError(err error) error {
+	if err == io.EOF || errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+// ForwardUDP receives datagrams on the local UDP address localAddr and
+// forwards them to the flex address target ("domain:port"), which is
+// usually served by ForwardRemoteUDP. Every UDP client address gets its own
+// stream and receives the replies of its flow.
+func (n *Node) ForwardUDP(localAddr, target string) (*UDPForwarder, error) {
+	return forwardUDP(n.Dial, localAddr, target)
+}
+
+// ForwardRemoteUDP listens on the flex port and forwards the datagrams of
+// every stream to the UDP address udpAddr, relaying the replies back.
+func (n *Node) ForwardRemoteUDP(port uint16, udpAddr string) (*UDPForwarder, error) {
+	l, err := n.Listen(port)
+	if err != nil {
+		return nil, err
+	}
+	return forwardRemoteUDP(l, udpAddr), nil
+}
+
+// ForwardUDP is Node.ForwardUDP over the session.
+func (s *Session) ForwardUDP(localAddr, target string) (*UDPForwarder, error) {
+	return forwardUDP(s.Dial, localAddr, target)
+}
+
+// ForwardRemoteUDP is Node.ForwardRemoteUDP over the session. The flex port
+// is registered again after every reconnect.
+func (s *Session) ForwardRemoteUDP(port uint16, udpAddr string) (*UDPForwarder, error) {
+	l, err := s.Listen(port)
+	if err != nil {
+		return nil, err
+	}
+	return forwardRemoteUDP(l, udpAddr), nil
+}
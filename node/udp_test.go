@@ -0,0 +1,127 @@
+package node
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// startUDPEcho 启动一个本地 UDP 回显服务
+func startUDPEcho(t *testing.T) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if !assert.Nil(t, err) {
+		return ""
+	}
+	t.Cleanup(func() { pc.Close() })
+	go func() {
+		buf := make([]byte, MaxDatagramSize)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			pc.WriteTo(buf[:n], addr)
+		}
+	}()
+	return pc.LocalAddr().String()
+}
+
+func assertUDPEcho(t *testing.T, c net.Conn, msg string) {
+	_, err := c.Write([]byte(msg))
+	assert.Nil(t, err)
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 1024)
+	n, err := c.Read(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, msg, string(buf[:n]))
+}
+
+func TestDatagramFraming(t *testing.T) {
+	var b bytes.Buffer
+	assert.Nil(t, writeDatagram(&b, []byte("hello")))
+	assert.Nil(t, writeDatagram(&b, nil))
+	assert.Nil(t, writeDatagram(&b, []byte("too large")))
+	assert.Nil(t, writeDatagram(&b, []byte("next")))
+	assert.Equal(t, ErrDatagramTooLarge, writeDatagram(&b, make([]byte, MaxDatagramSize+1)))
+
+	buf := make([]byte, 5)
+	n, err := readDatagram(&b, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(buf[:n]))
+	n, err = readDatagram(&b, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+	_, err = readDatagram(&b, buf)
+	assert.Equal(t, ErrDatagramTooLarge, err)
+	n, err = readDatagram(&b, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "next", string(buf[:n]))
+}
+
+func TestForwardUDP(t *testing.T) {
+	n1, n2 := Pipe("test1", "test2")
+	defer n1.Close()
+	defer n2.Close()
+	echo := startUDPEcho(t)
+
+	remote, err := n2.ForwardRemoteUDP(53, echo)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer remote.Close()
+	local, err := n1.ForwardUDP("127.0.0.1:0", "2:53")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer local.Close()
+	_, err = n1.ForwardUDP("127.0.0.1:0", "test2")
+	assert.NotNil(t, err)
+
+	// 每个客户端地址对应一个 flow
+	c1, err := net.Dial("udp", local.Addr().String())
+	assert.Nil(t, err)
+	defer c1.Close()
+	c2, err := net.Dial("udp", local.Addr().String())
+	assert.Nil(t, err)
+	defer c2.Close()
+
+	assertUDPEcho(t, c1, "one")
+	assertUDPEcho(t, c2, "two")
+	assertUDPEcho(t, c1, "three")
+	st := local.Stats()
+	assert.Equal(t, int64(2), st.ActiveConns)
+	assert.Equal(t, int64(11), st.Sent)
+	assert.Equal(t, int64(11), st.Received)
+	assert.Equal(t, int64(2), remote.Stats().ActiveConns)
+
+	// 超过上限的数据报被丢弃
+	local.SetMaxDatagramSize(8)
+	c1.Write([]byte("0123456789"))
+	assert.Eventually(t, func() bool {
+		return local.Stats().Dropped == 1
+	}, time.Second, 10*time.Millisecond)
+	assertUDPEcho(t, c1, "short")
+
+	// 空闲的 flow 被关闭，两端同时释放
+	closed := make(chan ForwardConnInfo, 4)
+	local.OnConnClose(func(info ForwardConnInfo) { closed <- info })
+	local.SetIdleTimeout(100 * time.Millisecond)
+	assert.Eventually(t, func() bool {
+		return local.Stats().ActiveConns == 0 && remote.Stats().ActiveConns == 0
+	}, 2*time.Second, 10*time.Millisecond)
+	for i := 0; i < 2; i++ {
+		select {
+		case info := <-closed:
+			assert.Equal(t, "", info.Err)
+		case <-time.After(time.Second):
+			t.Error("flow close not reported")
+		}
+	}
+
+	// 过期后同一地址重新建立 flow
+	assertUDPEcho(t, c1, "again")
+	assert.Equal(t, int64(3), local.Stats().TotalConns)
+}
@@ -1,6 +1,7 @@
 package packet
 
 import (
+	"io"
 	"net"
 	"sync"
 	"testing"
@@ -134,7 +135,16 @@ func BenchmarkGetPutBuffer(b *testing.B) {
 	}
 }
 
-// BenchmarkReadWriteBufferPool measures read/write with pool (reader uses GetBuffer internally).
+// BenchmarkGetPutPayload measures size-classed payload pool.
+func BenchmarkGetPutPayload(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		PutPayload(GetPayload(16384))
+	}
+}
+
+// BenchmarkReadWriteBufferPool measures read/write with pool (reader uses GetBuffer
+// and GetPayload internally, the consumer releases both).
 func BenchmarkReadWriteBufferPool(b *testing.B) {
 	payloads := []struct {
 		name string
@@ -183,6 +193,7 @@ func BenchmarkReadWriteBufferPool(b *testing.B) {
 				if err != nil {
 					b.Fatal(err)
 				}
+				buf.ReleasePayload()
 				PutBuffer(buf)
 			}
 			wg.Wait()
@@ -194,3 +205,54 @@ func BenchmarkReadWriteBufferPool(b *testing.B) {
 type devNull struct{}
 
 func (devNull) Write(p []byte) (int, error) { return len(p), nil }
+
+// BenchmarkWriteCoalescing compares writing small packets with and without
+// write coalescing over a TCP loopback connection.
+func BenchmarkWriteCoalescing(b *testing.B) {
+	writers := []struct {
+		name string
+		new  func(net.Conn) Writer
+	}{
+		{"Plain", NewConnWriter},
+		{"Coalesce", func(c net.Conn) Writer { return NewBufferedWriter(c, DefaultCoalesceConfig) }},
+	}
+
+	for _, wr := range writers {
+		b.Run(wr.name, func(b *testing.B) {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer l.Close()
+			go func() {
+				c, err := l.Accept()
+				if err != nil {
+					return
+				}
+				io.Copy(io.Discard, c)
+				c.Close()
+			}()
+			c, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer c.Close()
+
+			w := wr.new(c)
+			buf := NewBufferWithCmd(CmdPushStreamData)
+			buf.SetPayload(make([]byte, 64))
+
+			b.ReportAllocs()
+			b.SetBytes(int64(HeaderSz + 64))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := w.WriteBuffer(buf); err != nil {
+					b.Fatal(err)
+				}
+			}
+			if f, ok := w.(Flusher); ok {
+				f.Flush()
+			}
+		})
+	}
+}
@@ -59,6 +59,7 @@ type Header [HeaderSz]byte
 type Buffer struct {
 	Head    Header
 	Payload []byte
+	pooled  bool // Payload 来自 payload 池，见 GetPayload
 }
 
 var bufferPool = sync.Pool{
@@ -70,10 +71,12 @@ func GetBuffer() *Buffer {
 	return bufferPool.Get().(*Buffer)
 }
 
-// PutBuffer returns a Buffer to the pool after resetting it.
+// PutBuffer returns a Buffer to the pool after resetting it. A pooled payload
+// is not released; see ReleasePayload.
 func PutBuffer(buf *Buffer) {
 	buf.Head = Header{}
 	buf.Payload = nil
+	buf.pooled = false
 	bufferPool.Put(buf)
 }
 
@@ -233,6 +236,7 @@ func (buf *Buffer) SetPayload(payload []byte) error {
 	}
 	binary.BigEndian.PutUint16(buf.Head[9:11], uint16(len(payload)))
 	buf.Payload = payload
+	buf.pooled = false
 	return nil
 }
 
@@ -249,6 +253,7 @@ func (buf *Buffer) PayloadSize() uint16 {
 func (buf *Buffer) SetDataACKSize(size uint16) {
 	binary.BigEndian.PutUint16(buf.Head[9:11], size)
 	buf.Payload = nil
+	buf.pooled = false
 }
 
 // DataACKSize 获取 AckPushStreamData 包中已确认的数据大小。
@@ -0,0 +1,214 @@
+package packet
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+var ErrFlushFailed = errors.New("flush buffered packets failed")
+
+// Flusher is implemented by writers that may hold frames in memory.
+type Flusher interface {
+	Flush() error
+}
+
+// CoalesceConfig 控制写合并：多个帧先复制到缓冲区，再由一次系统调用写出。
+//
+// 缓冲区写满时立即写出；否则在写入停止 IdleDelay 后写出，且缓冲区中最早的帧
+// 最多等待 MaxDelay。延迟越大，合并的帧越多、系统调用越少，单个帧的延迟也越高。
+// IdleDelay 为 0 时每次 WriteBuffer / WriteBufferBatch 结束即写出，只合并同一次
+// 调用中的 header、payload 和批量的帧。
+//
+// 定时器触发的写出没有调用方接收错误，失败时关闭连接，读端随之返回错误。
+type CoalesceConfig struct {
+	BufferSize   int           // 缓冲区大小，为 0 时使用 DefaultCoalesceBufferSize
+	IdleDelay    time.Duration // 写入停止后等待的时间
+	MaxDelay     time.Duration // 帧在缓冲区中最长的等待时间，小于 IdleDelay 时按 IdleDelay 处理
+	WriteTimeout time.Duration // 每次写出的超时，为 0 时使用 DefaultWriteTimeout，SetWriteTimeout 可以覆盖
+}
+
+var (
+	DefaultCoalesceBufferSize = 32 * 1024
+
+	// DefaultCoalesceConfig 适合大量小包的场景，对交互延迟的影响在 1ms 以内
+	DefaultCoalesceConfig = CoalesceConfig{
+		BufferSize: DefaultCoalesceBufferSize,
+		IdleDelay:  100 * time.Microsecond,
+		MaxDelay:   time.Millisecond,
+	}
+)
+
+// bufferedWriter 实现写合并，见 CoalesceConfig。写出失败或者 Close 之后所有写入
+// 都返回同一个错误
+type bufferedWriter struct {
+	conn net.Conn
+	cfg  CoalesceConfig
+
+	mu      sync.Mutex
+	buf     []byte
+	first   time.Time // 缓冲区中最早的帧写入的时间
+	timer   *time.Timer
+	timeout time.Duration // SetWriteTimeout 设置的超时，为 0 时使用 cfg.WriteTimeout
+	err     error
+}
+
+// NewBufferedWriter 创建带写合并的 Writer，同时实现 BatchWriter、Flusher 和
+// io.Closer（写出缓冲区中的帧，不关闭 conn）
+func NewBufferedWriter(conn net.Conn, cfg CoalesceConfig) Writer {
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = DefaultCoalesceBufferSize
+	}
+	if cfg.WriteTimeout <= 0 {
+		cfg.WriteTimeout = DefaultWriteTimeout
+	}
+	cfg.IdleDelay = max(cfg.IdleDelay, 0)
+	cfg.MaxDelay = max(cfg.MaxDelay, cfg.IdleDelay)
+	return &bufferedWriter{
+		conn: conn,
+		cfg:  cfg,
+		buf:  make([]byte, 0, cfg.BufferSize),
+	}
+}
+
+// SetWriteTimeout 设置之后每次写出的超时，为 0 时恢复为 CoalesceConfig.WriteTimeout
+func (w *bufferedWriter) SetWriteTimeout(timeout time.Duration) {
+	w.mu.Lock()
+	w.timeout = timeout
+	w.mu.Unlock()
+}
+
+func (w *bufferedWriter) WriteBuffer(buf *Buffer) error {
+	if buf == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	w.appendLocked(buf)
+	return w.scheduleLocked()
+}
+
+func (w *bufferedWriter) WriteBufferBatch(bufs []*Buffer) error {
+	if len(bufs) == 0 {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, buf := range bufs {
+		if w.err != nil {
+			return w.err
+		}
+		w.appendLocked(buf)
+	}
+	return w.scheduleLocked()
+}
+
+// Flush 立即写出缓冲区中的帧
+func (w *bufferedWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.flushLocked()
+}
+
+// Close 写出缓冲区中的帧，之后的写入返回 net.ErrClosed。不关闭 conn
+func (w *bufferedWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	err := w.flushLocked()
+	if w.err == nil {
+		w.err = net.ErrClosed
+	}
+	return err
+}
+
+// appendLocked 把帧复制到缓冲区，放不下时先写出。大于缓冲区的帧与缓冲区一起
+// 直接写出，不经过复制
+func (w *bufferedWriter) appendLocked(buf *Buffer) {
+	size := HeaderSz + len(buf.Payload)
+	if size > w.cfg.BufferSize {
+		vecs := net.Buffers{w.buf, buf.Head[:], buf.Payload}
+		w.setDeadlineLocked()
+		_, err := vecs.WriteTo(w.conn)
+		w.buf = w.buf[:0]
+		w.setErrLocked(err)
+		return
+	}
+	if len(w.buf)+size > w.cfg.BufferSize {
+		if w.flushLocked() != nil {
+			return
+		}
+	}
+	if len(w.buf) == 0 {
+		w.first = time.Now()
+	}
+	w.buf = append(w.buf, buf.Head[:]...)
+	w.buf = append(w.buf, buf.Payload...)
+}
+
+// scheduleLocked 在缓冲区写满或不需要等待时立即写出，否则推迟定时器
+func (w *bufferedWriter) scheduleLocked() error {
+	if w.err != nil {
+		return w.err
+	}
+	if len(w.buf) == 0 {
+		return nil
+	}
+	if w.cfg.IdleDelay == 0 || len(w.buf) >= w.cfg.BufferSize {
+		return w.flushLocked()
+	}
+	d := min(w.cfg.IdleDelay, time.Until(w.first.Add(w.cfg.MaxDelay)))
+	if w.timer == nil {
+		w.timer = time.AfterFunc(d, w.flushTimer)
+	} else {
+		w.timer.Reset(d)
+	}
+	return nil
+}
+
+// flushTimer 由定时器调用。写出失败时没有调用方接收错误，关闭连接让读端感知
+func (w *bufferedWriter) flushTimer() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err == nil && w.flushLocked() != nil {
+		w.conn.Close()
+	}
+}
+
+func (w *bufferedWriter) flushLocked() error {
+	if w.err != nil {
+		return w.err
+	}
+	if len(w.buf) == 0 {
+		return nil
+	}
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.setDeadlineLocked()
+	_, err := w.conn.Write(w.buf)
+	w.buf = w.buf[:0]
+	w.setErrLocked(err)
+	return w.err
+}
+
+// setDeadlineLocked 为接下来的一次写出设置超时，对端不读取时写出不会一直阻塞
+func (w *bufferedWriter) setDeadlineLocked() {
+	timeout := w.timeout
+	if timeout <= 0 {
+		timeout = w.cfg.WriteTimeout
+	}
+	w.conn.SetWriteDeadline(time.Now().Add(timeout))
+}
+
+func (w *bufferedWriter) setErrLocked(err error) {
+	if err != nil && w.err == nil {
+		w.err = errors.Join(ErrFlushFailed, err)
+	}
+}
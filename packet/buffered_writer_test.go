@@ -0,0 +1,211 @@
+package packet
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeCountingConn 记录 Write 的调用次数
+type writeCountingConn struct {
+	net.Conn
+	writes atomic.Int32
+}
+
+func (c *writeCountingConn) Write(b []byte) (int, error) {
+	c.writes.Add(1)
+	return c.Conn.Write(b)
+}
+
+// newCoalescePipe 返回带写合并的 Writer，对端收到的包依次放入 chan
+func newCoalescePipe(t *testing.T, cfg CoalesceConfig) (*writeCountingConn, Writer, <-chan *Buffer) {
+	c1, c2 := net.Pipe()
+	t.Cleanup(func() { c1.Close(); c2.Close() })
+	conn := &writeCountingConn{Conn: c1}
+	ch := make(chan *Buffer, 64)
+	go func() {
+		r := NewConnReader(c2)
+		for {
+			buf, err := r.ReadBuffer()
+			if err != nil {
+				close(ch)
+				return
+			}
+			ch <- buf
+		}
+	}()
+	return conn, NewBufferedWriter(conn, cfg), ch
+}
+
+func dataBuffer(port uint16, payload string) *Buffer {
+	buf := NewBufferWithCmd(CmdPushStreamData)
+	buf.SetSrcPort(port)
+	buf.SetPayload([]byte(payload))
+	return buf
+}
+
+func TestBufferedWriter_Flush(t *testing.T) {
+	conn, w, ch := newCoalescePipe(t, CoalesceConfig{IdleDelay: time.Hour})
+
+	for i := range 10 {
+		require.NoError(t, w.WriteBuffer(dataBuffer(uint16(i), "data")))
+	}
+	assert.Equal(t, int32(0), conn.writes.Load(), "frames stay buffered until flush")
+
+	require.NoError(t, w.(Flusher).Flush())
+	for i := range 10 {
+		buf := <-ch
+		assert.Equal(t, uint16(i), buf.SrcPort())
+		assert.Equal(t, []byte("data"), buf.Payload)
+	}
+	assert.Equal(t, int32(1), conn.writes.Load())
+}
+
+func TestBufferedWriter_IdleFlush(t *testing.T) {
+	conn, w, ch := newCoalescePipe(t, CoalesceConfig{IdleDelay: 5 * time.Millisecond, MaxDelay: time.Hour})
+
+	require.NoError(t, w.WriteBuffer(dataBuffer(1, "a")))
+	require.NoError(t, w.(BatchWriter).WriteBufferBatch([]*Buffer{dataBuffer(2, "b"), dataBuffer(3, "c")}))
+
+	for i := range 3 {
+		select {
+		case buf := <-ch:
+			assert.Equal(t, uint16(i+1), buf.SrcPort())
+		case <-time.After(time.Second):
+			t.Fatal("idle timer did not flush")
+		}
+	}
+	assert.Equal(t, int32(1), conn.writes.Load())
+}
+
+func TestBufferedWriter_MaxDelay(t *testing.T) {
+	_, w, ch := newCoalescePipe(t, CoalesceConfig{IdleDelay: 50 * time.Millisecond, MaxDelay: 100 * time.Millisecond})
+
+	// 持续写入时空闲定时器一直被推迟，由 MaxDelay 限制第一个帧的延迟
+	start := time.Now()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; time.Since(start) < time.Second; i++ {
+			w.WriteBuffer(dataBuffer(uint16(i), "x"))
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+
+	<-ch
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+	go func() {
+		for range ch {
+		}
+	}()
+	<-done
+}
+
+func TestBufferedWriter_Immediate(t *testing.T) {
+	conn, w, ch := newCoalescePipe(t, CoalesceConfig{})
+
+	// IdleDelay 为 0 时每次写入都立即写出，header 和 payload 只需一次 Write
+	for i := range 3 {
+		require.NoError(t, w.WriteBuffer(dataBuffer(uint16(i), "data")))
+		assert.Equal(t, uint16(i), (<-ch).SrcPort())
+	}
+	assert.Equal(t, int32(3), conn.writes.Load())
+}
+
+func TestBufferedWriter_LargeFrame(t *testing.T) {
+	_, w, ch := newCoalescePipe(t, CoalesceConfig{BufferSize: 1024, IdleDelay: time.Hour})
+
+	large := make([]byte, 4096)
+	for i := range large {
+		large[i] = byte(i)
+	}
+	big := NewBufferWithCmd(CmdPushStreamData)
+	big.SetPayload(large)
+
+	// 缓冲区中已有的帧和大帧按顺序一起写出
+	require.NoError(t, w.WriteBuffer(dataBuffer(1, "small")))
+	require.NoError(t, w.WriteBuffer(big))
+
+	assert.Equal(t, []byte("small"), (<-ch).Payload)
+	assert.Equal(t, large, (<-ch).Payload)
+}
+
+func TestBufferedWriter_Close(t *testing.T) {
+	_, w, ch := newCoalescePipe(t, CoalesceConfig{IdleDelay: time.Hour})
+
+	require.NoError(t, w.WriteBuffer(dataBuffer(1, "last")))
+	require.NoError(t, w.(io.Closer).Close())
+	assert.Equal(t, []byte("last"), (<-ch).Payload, "close flushes buffered frames")
+	assert.ErrorIs(t, w.WriteBuffer(dataBuffer(2, "data")), net.ErrClosed)
+}
+
+func TestBufferedConn_Close(t *testing.T) {
+	c1, c2 := net.Pipe()
+	pc := NewBufferedConn(c1, CoalesceConfig{IdleDelay: time.Hour})
+	require.NoError(t, pc.WriteBuffer(dataBuffer(1, "bye")))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r := NewConnReader(c2)
+		buf, err := r.ReadBuffer()
+		if assert.NoError(t, err) {
+			assert.Equal(t, []byte("bye"), buf.Payload)
+		}
+		_, err = r.ReadBuffer()
+		assert.Error(t, err, "conn is closed after the flush")
+	}()
+	assert.NoError(t, pc.Close())
+	<-done
+}
+
+// closeRecordingConn 记录 Close 是否被调用
+type closeRecordingConn struct {
+	net.Conn
+	closed atomic.Bool
+}
+
+func (c *closeRecordingConn) Close() error {
+	c.closed.Store(true)
+	return c.Conn.Close()
+}
+
+func TestBufferedWriter_TimerError(t *testing.T) {
+	c1, c2 := net.Pipe()
+	c2.Close()
+	conn := &closeRecordingConn{Conn: c1}
+	w := NewBufferedWriter(conn, CoalesceConfig{IdleDelay: time.Millisecond})
+
+	// 定时写出失败时没有调用方接收错误，关闭连接
+	require.NoError(t, w.WriteBuffer(dataBuffer(1, "data")))
+	assert.Eventually(t, conn.closed.Load, time.Second, time.Millisecond)
+	assert.ErrorIs(t, w.WriteBuffer(dataBuffer(2, "data")), ErrFlushFailed)
+}
+
+func TestBufferedWriter_WriteTimeout(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	w := NewBufferedWriter(c1, CoalesceConfig{WriteTimeout: 20 * time.Millisecond})
+
+	// 对端不读取时写出在超时后失败，不会一直阻塞
+	start := time.Now()
+	err := w.WriteBuffer(dataBuffer(1, "data"))
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestBufferedWriter_Error(t *testing.T) {
+	c1, c2 := net.Pipe()
+	c2.Close()
+	w := NewBufferedWriter(c1, CoalesceConfig{IdleDelay: time.Hour})
+
+	require.NoError(t, w.WriteBuffer(dataBuffer(1, "data")))
+	assert.ErrorIs(t, w.(Flusher).Flush(), ErrFlushFailed)
+	assert.ErrorIs(t, w.WriteBuffer(dataBuffer(2, "data")), ErrFlushFailed, "error is sticky")
+}
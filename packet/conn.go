@@ -28,6 +28,25 @@ func NewWithConn(conn net.Conn) Conn {
 	}
 }
 
+// NewBufferedConn 与 NewWithConn 相同，但写入经过写合并，见 CoalesceConfig。
+// Close 会先写出缓冲区中的帧
+func NewBufferedConn(conn net.Conn, cfg CoalesceConfig) Conn {
+	return &connImpl{
+		raw:    conn,
+		Closer: conn,
+		Reader: NewConnReader(conn),
+		Writer: NewBufferedWriter(conn, cfg),
+	}
+}
+
+// Close 关闭连接。开启写合并时先写出缓冲区中的帧，写出受写超时限制
+func (impl *connImpl) Close() error {
+	if c, ok := impl.Writer.(io.Closer); ok {
+		c.Close()
+	}
+	return impl.Closer.Close()
+}
+
 func (impl *connImpl) GetRawConn() net.Conn {
 	return impl.raw
 }
@@ -43,3 +62,11 @@ func (impl *connImpl) WriteBufferBatch(bufs []*Buffer) error {
 	}
 	return nil
 }
+
+// Flush 写出写合并缓冲区中的帧，没有开启写合并时直接返回
+func (impl *connImpl) Flush() error {
+	if f, ok := impl.Writer.(Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package packet
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// ErrorCode 是 open 失败应答和 CloseStream 中携带的错误码
+type ErrorCode uint16
+
+const (
+	CodeNone             ErrorCode = iota // 成功，或正常关闭
+	CodeUnknown                           // 旧版本对端发送的纯文本错误
+	CodeListenerNotFound                  // 目标端口没有 listener
+	CodeListenerClosed                    // listener 在排队期间关闭
+	CodeBacklogFull                       // listener 的 backlog 已满
+	CodeDomainNotFound                    // switcher 无法解析目标域名或选择器
+	CodeAccessDenied                      // 被目标的鉴权函数拒绝
+	CodeStreamExists                      // stream 标识冲突
+	CodeCanceled                          // stream 被应用主动终止
+	CodeFlowControl                       // 接收队列溢出，窗口无法继续同步
+	CodeInternal                          // 其他本地错误
+)
+
+// CodeApplication 及以上的错误码由应用自行定义，例如用于 Stream.Reset
+const CodeApplication ErrorCode = 0x1000
+
+var codeNames = map[ErrorCode]string{
+	CodeNone:             "none",
+	CodeUnknown:          "unknown",
+	CodeListenerNotFound: "listener not found",
+	CodeListenerClosed:   "listener closed",
+	CodeBacklogFull:      "listener backlog full",
+	CodeDomainNotFound:   "domain not found",
+	CodeAccessDenied:     "access denied",
+	CodeStreamExists:     "stream exists",
+	CodeCanceled:         "canceled",
+	CodeFlowControl:      "flow control error",
+	CodeInternal:         "internal error",
+}
+
+func (c ErrorCode) String() string {
+	if name, ok := codeNames[c]; ok {
+		return name
+	}
+	if c >= CodeApplication {
+		return fmt.Sprintf("application error %d", c-CodeApplication)
+	}
+	return fmt.Sprintf("error code %d", uint16(c))
+}
+
+// EncodeCloseCode returns the CmdCloseStream payload for code. A graceful
+// close (CodeNone) has an empty payload, as in older versions.
+func EncodeCloseCode(code ErrorCode) []byte {
+	if code == CodeNone {
+		return nil
+	}
+	return binary.BigEndian.AppendUint16(nil, uint16(code))
+}
+
+// DecodeCloseCode parses a CmdCloseStream payload. Empty payloads are
+// graceful closes.
+func DecodeCloseCode(payload []byte) ErrorCode {
+	if len(payload) < 2 {
+		return CodeNone
+	}
+	return ErrorCode(binary.BigEndian.Uint16(payload))
+}
+
+// closeWriteFlag 是只关闭写方向的 CmdCloseStream 的 payload。旧版本的对端
+// 不解析 payload，会按完整关闭处理
+const closeWriteFlag byte = 0x01
+
+// EncodeCloseWrite returns the CmdCloseStream payload that shuts down only the
+// sender's write direction.
+func EncodeCloseWrite() []byte { return []byte{closeWriteFlag} }
+
+// IsCloseWrite reports whether a CmdCloseStream payload shuts down only the
+// sender's write direction. The AckCloseStream confirming it carries the same
+// payload.
+func IsCloseWrite(payload []byte) bool {
+	return len(payload) == 1 && payload[0] == closeWriteFlag
+}
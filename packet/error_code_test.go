@@ -0,0 +1,40 @@
+package packet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorCodeString(t *testing.T) {
+	assert.Equal(t, "access denied", CodeAccessDenied.String())
+	assert.Equal(t, "application error 3", (CodeApplication + 3).String())
+	assert.Equal(t, "error code 100", ErrorCode(100).String())
+}
+
+func TestCloseCode(t *testing.T) {
+	assert.Nil(t, EncodeCloseCode(CodeNone))
+	assert.Equal(t, CodeNone, DecodeCloseCode(nil))
+	assert.Equal(t, CodeNone, DecodeCloseCode([]byte{1}))
+	assert.Equal(t, CodeFlowControl, DecodeCloseCode(EncodeCloseCode(CodeFlowControl)))
+	assert.Equal(t, CodeApplication+1, DecodeCloseCode(EncodeCloseCode(CodeApplication+1)))
+}
+
+func TestOpenStreamACK_Code(t *testing.T) {
+	ack := OpenStreamACK{Code: CodeBacklogFull, Error: "listener backlog full"}
+	assert.Equal(t, ack, DecodeOpenStreamACK(ack.Encode()))
+
+	// 旧版本的纯文本应答
+	legacy := OpenStreamACK{Error: "listener not found"}
+	decoded := DecodeOpenStreamACK(legacy.Encode())
+	assert.False(t, decoded.OK)
+	assert.Equal(t, CodeUnknown, decoded.Code)
+	assert.Equal(t, "listener not found", decoded.Error)
+}
+
+func TestCloseWrite(t *testing.T) {
+	assert.True(t, IsCloseWrite(EncodeCloseWrite()))
+	assert.False(t, IsCloseWrite(nil))
+	assert.False(t, IsCloseWrite(EncodeCloseCode(CodeCanceled)))
+	assert.Equal(t, CodeNone, DecodeCloseCode(EncodeCloseWrite()))
+}
@@ -0,0 +1,178 @@
+package packet
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+var ErrMessageOversize = errors.New("message exceeds MaxPayloadSize")
+
+// Topics of messages exchanged between nodes and the switcher.
+const (
+	TopicGroupJoin  = "group.join"  // GroupRequest: join a service group
+	TopicGroupLeave = "group.leave" // GroupRequest: leave a service group
+	TopicNodeQuery  = "node.query"  // NodeQuery: list nodes matching a label selector
+
+	TopicDirectoryList       = "directory.list"       // DirectoryQuery: list online nodes
+	TopicDirectoryAdvertise  = "directory.advertise"  // DirectoryAdvertisement: set the ports listed for the caller
+	TopicPresenceSubscribe   = "presence.subscribe"   // PresenceSubscription: receive presence.event for domains
+	TopicPresenceUnsubscribe = "presence.unsubscribe" // PresenceSubscription: stop receiving presence.event
+	TopicPresenceEvent       = "presence.event"       // PresenceEvent: pushed by the switcher
+
+	TopicDNSResolve    = "dns.resolve"    // DNSQuery, addressed to DNSIP: resolve a name to an IP
+	TopicDNSInvalidate = "dns.invalidate" // DNSInvalidation: pushed when a resolved name changes address
+
+	TopicTunnelOpen  = "tunnel.open"  // TunnelRequest: expose a port of the caller on a public TCP port
+	TopicTunnelClose = "tunnel.close" // TunnelRequest: close a tunnel opened by the caller
+)
+
+// GroupRequest is the body of group.join/group.leave messages.
+type GroupRequest struct {
+	Group string `json:"group"`
+}
+
+// NodeQuery is the body of node.query messages. An empty Selector matches
+// every node. The reply body is a list of NodeRecord.
+type NodeQuery struct {
+	Selector string `json:"selector"`
+}
+
+// NodeRecord describes a node returned by node.query.
+type NodeRecord struct {
+	Domain string            `json:"domain"`
+	IP     uint16            `json:"ip"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Ports  []uint16          `json:"ports,omitempty"`
+}
+
+// DirectoryQuery is the body of directory.list messages. The reply body is a
+// list of NodeRecord sorted by IP; Labels and Ports are only filled in when
+// requested.
+//
+// The reply is one page: the records with an IP greater than After, at most
+// Limit of them (0 means no limit), cut short so that the reply fits in a
+// packet. The next page starts after the IP of the last record; an empty page
+// ends the listing.
+type DirectoryQuery struct {
+	Selector string `json:"selector,omitempty"`
+	Labels   bool   `json:"labels,omitempty"`
+	Ports    bool   `json:"ports,omitempty"`
+	After    uint16 `json:"after,omitempty"`
+	Limit    int    `json:"limit,omitempty"`
+}
+
+// DirectoryAdvertisement is the body of directory.advertise messages. It
+// replaces the ports previously advertised by the caller.
+type DirectoryAdvertisement struct {
+	Ports []uint16 `json:"ports"`
+}
+
+// PresenceSubscription is the body of presence.subscribe/presence.unsubscribe
+// messages. An empty Domains subscribes to every node, or unsubscribes from
+// everything. The reply to presence.subscribe is a list of PresenceEvent for
+// the subscribed nodes that are currently online, sorted by IP and paged with
+// After and Limit like DirectoryQuery. Subscribing again with the same
+// Domains fetches the next page.
+type PresenceSubscription struct {
+	Domains []string `json:"domains,omitempty"`
+	After   uint16   `json:"after,omitempty"`
+	Limit   int      `json:"limit,omitempty"`
+}
+
+// PresenceEvent reports a node going online or offline.
+type PresenceEvent struct {
+	Domain string            `json:"domain"`
+	IP     uint16            `json:"ip"`
+	Online bool              `json:"online"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// DNSQuery is the body of dns.resolve messages. The reply body is a DNSRecord.
+type DNSQuery struct {
+	Name string `json:"name"`
+}
+
+// DNSRecord is the answer to a dns.resolve query. Domain is the node serving
+// Name; Alias is set when Name is another name for it, such as a service
+// group. TTL is in seconds; 0 means the answer must not be cached.
+type DNSRecord struct {
+	Name   string `json:"name"`
+	Domain string `json:"domain"`
+	IP     uint16 `json:"ip"`
+	TTL    uint32 `json:"ttl"`
+	Alias  bool   `json:"alias,omitempty"`
+}
+
+// DNSInvalidation is pushed to nodes that resolved Name when the node
+// serving it at IP goes away.
+type DNSInvalidation struct {
+	Name string `json:"name"`
+	IP   uint16 `json:"ip"`
+}
+
+// TunnelRequest is the body of tunnel.open/tunnel.close messages. Port is the
+// public TCP port on the switcher (0 picks a free allowed port); TargetPort is
+// the port of the caller that accepted connections are forwarded to. The
+// reply to tunnel.open is a TunnelResponse.
+type TunnelRequest struct {
+	Port       uint16 `json:"port"`
+	TargetPort uint16 `json:"target_port,omitempty"`
+}
+
+// TunnelResponse is the reply to tunnel.open.
+type TunnelResponse struct {
+	Addr string `json:"addr"`
+	Port uint16 `json:"port"`
+}
+
+// Message is the payload of CmdPushMessage/AckPushMessage packets.
+// It carries small control requests and events addressed to the switcher
+// (or pushed by it), using Topic to select the handler.
+type Message struct {
+	Topic string          `json:"topic"`
+	Body  json.RawMessage `json:"body,omitempty"`
+	Error string          `json:"error,omitempty"`
+}
+
+// NewMessage builds a Message whose Body is the JSON encoding of body.
+// A nil body leaves Body empty.
+func NewMessage(topic string, body any) (*Message, error) {
+	msg := &Message{Topic: topic}
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		msg.Body = data
+	}
+	return msg, nil
+}
+
+// Encode serializes the message as JSON. The result must fit in a single packet.
+func (m *Message) Encode() ([]byte, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxPayloadSize {
+		return nil, ErrMessageOversize
+	}
+	return data, nil
+}
+
+// Unmarshal decodes Body into v.
+func (m *Message) Unmarshal(v any) error {
+	if len(m.Body) == 0 {
+		return nil
+	}
+	return json.Unmarshal(m.Body, v)
+}
+
+// DecodeMessage parses a CmdPushMessage/AckPushMessage payload.
+func DecodeMessage(payload []byte) (*Message, error) {
+	msg := &Message{}
+	if err := json.Unmarshal(payload, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
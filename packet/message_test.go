@@ -0,0 +1,51 @@
+package packet
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessage_Encode_Decode(t *testing.T) {
+	type body struct {
+		Domain string `json:"domain"`
+		IP     uint16 `json:"ip"`
+	}
+
+	msg, err := NewMessage("peer.add", []body{{"a", 1}, {"b", 2}})
+	assert.Nil(t, err)
+
+	payload, err := msg.Encode()
+	assert.Nil(t, err)
+
+	decoded, err := DecodeMessage(payload)
+	assert.Nil(t, err)
+	assert.Equal(t, "peer.add", decoded.Topic)
+	assert.Equal(t, "", decoded.Error)
+
+	var got []body
+	assert.Nil(t, decoded.Unmarshal(&got))
+	assert.Equal(t, []body{{"a", 1}, {"b", 2}}, got)
+}
+
+func TestMessage_EmptyBody(t *testing.T) {
+	msg, err := NewMessage("ping", nil)
+	assert.Nil(t, err)
+	assert.Nil(t, msg.Body)
+
+	var v struct{ X int }
+	assert.Nil(t, msg.Unmarshal(&v), "empty body should decode to zero value")
+}
+
+func TestMessage_Oversize(t *testing.T) {
+	msg, err := NewMessage("big", strings.Repeat("x", MaxPayloadSize))
+	assert.Nil(t, err)
+	_, err = msg.Encode()
+	assert.Equal(t, ErrMessageOversize, err)
+}
+
+func TestDecodeMessage_Invalid(t *testing.T) {
+	_, err := DecodeMessage([]byte("not json"))
+	assert.NotNil(t, err)
+}
@@ -1,52 +1,195 @@
 package packet
 
-import "encoding/binary"
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+// open stream 请求的扩展字段类型，旧版本解码时会忽略 windowSize 之后的字节
+const (
+	openExtSelector  byte = 1
+	openExtMetadata  byte = 2 // 每个键值对一个扩展字段：[keyLen(1B)][key][value]
+	openExtMaxWindow byte = 3 // [maxWindowSize(4B)]
+)
+
+// MaxMetadataSize 限制 open 请求中元数据编码后的总长度，为域名等字段留出
+// MaxPayloadSize 内的余量（switcher 转发时会改写域名）
+const MaxMetadataSize = 4096
+
+var (
+	ErrMetadataTooLarge = errors.New("metadata exceeds MaxMetadataSize")
+	ErrInvalidMetadata  = errors.New("invalid metadata key")
+)
 
 // OpenStreamRequest is the payload of a CmdOpenStream packet.
 type OpenStreamRequest struct {
 	Domain     string
 	WindowSize uint32
+	Selector   string            // 非空时由 switcher 按标签选择器挑选目标节点
+	Metadata   map[string]string // 拨号方附带的键值对，由 switcher 原样转发
+
+	// MaxWindowSize 是拨号方作为接收端最多授予对端的窗口。非 0 表示拨号方支持
+	// 打开后调整窗口，旧版本的请求为 0
+	MaxWindowSize uint32
+}
+
+// MetadataSize returns the encoded size of md inside an OpenStreamRequest.
+func MetadataSize(md map[string]string) int {
+	size := 0
+	for k, v := range md {
+		size += 3 + 1 + len(k) + len(v)
+	}
+	return size
+}
+
+// ValidateMetadata checks that md can be carried by an OpenStreamRequest:
+// keys are 1 to 255 bytes and the encoded size is at most MaxMetadataSize.
+func ValidateMetadata(md map[string]string) error {
+	for k := range md {
+		if len(k) == 0 || len(k) > 0xff {
+			return ErrInvalidMetadata
+		}
+	}
+	if MetadataSize(md) > MaxMetadataSize {
+		return ErrMetadataTooLarge
+	}
+	return nil
 }
 
-// Encode serializes the request as [domain][0x00][windowSize(4B)].
+// Encode serializes the request as [domain][0x00][windowSize(4B)][extensions...].
+// Each extension is encoded as [type(1B)][len(2B)][value]. Metadata that
+// fails ValidateMetadata is dropped.
 func (r *OpenStreamRequest) Encode() []byte {
-	buf := make([]byte, len(r.Domain)+5)
+	size := len(r.Domain) + 5
+	if r.Selector != "" {
+		size += 3 + len(r.Selector)
+	}
+	md := r.Metadata
+	if ValidateMetadata(md) != nil {
+		md = nil
+	}
+	size += MetadataSize(md)
+	if r.MaxWindowSize > 0 {
+		size += 3 + 4
+	}
+
+	buf := make([]byte, len(r.Domain)+5, size)
 	copy(buf, r.Domain)
 	buf[len(r.Domain)] = 0
 	binary.BigEndian.PutUint32(buf[len(r.Domain)+1:], r.WindowSize)
+	if r.Selector != "" {
+		buf = appendExt(buf, openExtSelector, r.Selector)
+	}
+	if r.MaxWindowSize > 0 {
+		buf = append(buf, openExtMaxWindow, 0, 4)
+		buf = binary.BigEndian.AppendUint32(buf, r.MaxWindowSize)
+	}
+
+	// 按 key 排序，保证编码结果稳定
+	keys := make([]string, 0, len(md))
+	for k := range md {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		buf = append(buf, openExtMetadata)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(1+len(k)+len(md[k])))
+		buf = append(buf, byte(len(k)))
+		buf = append(buf, k...)
+		buf = append(buf, md[k]...)
+	}
 	return buf
 }
 
+func appendExt(buf []byte, typ byte, value string) []byte {
+	buf = append(buf, typ)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(value)))
+	return append(buf, value...)
+}
+
 // DecodeOpenStreamRequest parses a CmdOpenStream payload.
 func DecodeOpenStreamRequest(payload []byte) OpenStreamRequest {
 	for i, b := range payload {
 		if b == 0 {
-			var ws uint32
+			req := OpenStreamRequest{Domain: string(payload[:i])}
 			if len(payload) >= i+5 {
-				ws = binary.BigEndian.Uint32(payload[i+1 : i+5])
+				req.WindowSize = binary.BigEndian.Uint32(payload[i+1 : i+5])
+				req.decodeExtensions(payload[i+5:])
 			}
-			return OpenStreamRequest{Domain: string(payload[:i]), WindowSize: ws}
+			return req
 		}
 	}
 	return OpenStreamRequest{Domain: string(payload)}
 }
 
+// decodeExtensions 解析扩展字段，未知类型直接跳过，截断的字段被忽略
+func (r *OpenStreamRequest) decodeExtensions(ext []byte) {
+	for len(ext) >= 3 {
+		typ := ext[0]
+		n := int(binary.BigEndian.Uint16(ext[1:3]))
+		if len(ext) < 3+n {
+			return
+		}
+		value := ext[3 : 3+n]
+		ext = ext[3+n:]
+		switch typ {
+		case openExtSelector:
+			r.Selector = string(value)
+		case openExtMetadata:
+			if len(value) == 0 || len(value) < 1+int(value[0]) || value[0] == 0 {
+				continue
+			}
+			if r.Metadata == nil {
+				r.Metadata = make(map[string]string)
+			}
+			k := 1 + int(value[0])
+			r.Metadata[string(value[1:k])] = string(value[k:])
+		case openExtMaxWindow:
+			if len(value) == 4 {
+				r.MaxWindowSize = binary.BigEndian.Uint32(value)
+			}
+		}
+	}
+}
+
 // OpenStreamACK is the payload of an AckOpenStream packet.
 type OpenStreamACK struct {
 	OK         bool
 	WindowSize uint32
+	Code       ErrorCode // 失败原因，旧版本对端的应答为 CodeUnknown
 	Error      string
+
+	// MaxWindowSize 是应答方作为接收端最多授予对端的窗口，旧版本的应答为 0
+	MaxWindowSize uint32
+
+	// Domain 是应答方的域名，由 switcher 在转发成功应答时填写，旧版本的应答为空
+	Domain string
 }
 
-// Encode serializes the ACK. Success: [0x00][windowSize(4B)]. Failure: [error string].
+// ackCodeMarker 开头的失败应答带有错误码，旧版本的应答是以可打印字符开头的纯文本
+const ackCodeMarker byte = 0x01
+
+// Encode serializes the ACK. Success: [0x00][windowSize(4B)][maxWindowSize(4B)][domain],
+// where maxWindowSize is omitted when zero and there is no domain.
+// Failure: [0x01][code(2B)][error string], or [error string] without a code.
 func (a *OpenStreamACK) Encode() []byte {
 	if !a.OK {
-		return []byte(a.Error)
+		if a.Code == CodeNone || a.Code == CodeUnknown {
+			return []byte(a.Error)
+		}
+		buf := make([]byte, 3, 3+len(a.Error))
+		buf[0] = ackCodeMarker
+		binary.BigEndian.PutUint16(buf[1:3], uint16(a.Code))
+		return append(buf, a.Error...)
 	}
-	buf := make([]byte, 5)
+	buf := make([]byte, 5, 9+len(a.Domain))
 	buf[0] = 0
 	binary.BigEndian.PutUint32(buf[1:], a.WindowSize)
-	return buf
+	if a.MaxWindowSize > 0 || a.Domain != "" {
+		buf = binary.BigEndian.AppendUint32(buf, a.MaxWindowSize)
+	}
+	return append(buf, a.Domain...)
 }
 
 // DecodeOpenStreamACK parses an AckOpenStream payload.
@@ -55,11 +198,19 @@ func DecodeOpenStreamACK(payload []byte) OpenStreamACK {
 		return OpenStreamACK{OK: true}
 	}
 	if payload[0] == 0 {
-		var ws uint32
+		ack := OpenStreamACK{OK: true}
 		if len(payload) >= 5 {
-			ws = binary.BigEndian.Uint32(payload[1:5])
+			ack.WindowSize = binary.BigEndian.Uint32(payload[1:5])
 		}
-		return OpenStreamACK{OK: true, WindowSize: ws}
+		if len(payload) >= 9 {
+			ack.MaxWindowSize = binary.BigEndian.Uint32(payload[5:9])
+			ack.Domain = string(payload[9:])
+		}
+		return ack
+	}
+	if payload[0] == ackCodeMarker && len(payload) >= 3 {
+		code := ErrorCode(binary.BigEndian.Uint16(payload[1:3]))
+		return OpenStreamACK{Code: code, Error: string(payload[3:])}
 	}
-	return OpenStreamACK{Error: string(payload)}
+	return OpenStreamACK{Code: CodeUnknown, Error: string(payload)}
 }
@@ -107,3 +107,72 @@ func TestOpenStreamRequest_DomainWithNullByte(t *testing.T) {
 	decoded := DecodeOpenStreamRequest(encoded)
 	assert.Equal(t, "ab", decoded.Domain, "should truncate at first null byte")
 }
+
+func TestOpenStreamRequest_Selector(t *testing.T) {
+	req := OpenStreamRequest{WindowSize: 4096, Selector: "role=db,region!=eu"}
+	decoded := DecodeOpenStreamRequest(req.Encode())
+	assert.Equal(t, req, decoded)
+
+	// 不带扩展字段时编码与旧格式一致
+	plain := OpenStreamRequest{Domain: "a", WindowSize: 1}
+	assert.Equal(t, 6, len(plain.Encode()))
+}
+
+func TestDecodeOpenStreamRequest_Extensions(t *testing.T) {
+	// 未知扩展被跳过，截断的扩展被忽略
+	payload := []byte("d\x00\x00\x00\x00\x10")
+	payload = append(payload, 0x7f, 0x00, 0x02, 'x', 'y')
+	payload = append(payload, openExtSelector, 0x00, 0x01, 'k')
+	payload = append(payload, openExtSelector, 0x00, 0x09, 'z')
+	decoded := DecodeOpenStreamRequest(payload)
+	assert.Equal(t, "d", decoded.Domain)
+	assert.Equal(t, uint32(16), decoded.WindowSize)
+	assert.Equal(t, "k", decoded.Selector)
+}
+
+func TestOpenStreamRequest_Metadata(t *testing.T) {
+	req := OpenStreamRequest{Domain: "d", WindowSize: 1024, Selector: "role=db",
+		Metadata: map[string]string{"trace-id": "abc", "empty": ""}}
+	encoded := req.Encode()
+	assert.Equal(t, encoded, req.Encode(), "encoding is stable")
+	decoded := DecodeOpenStreamRequest(encoded)
+	assert.Equal(t, req, decoded)
+
+	// 未知扩展字段被跳过
+	withUnknown := append(append([]byte{}, encoded...), 0x7f, 0, 1, 'x')
+	assert.Equal(t, req, DecodeOpenStreamRequest(withUnknown))
+
+	// 不合法的元数据不被编码
+	bad := OpenStreamRequest{Domain: "d", Metadata: map[string]string{"": "v"}}
+	assert.Nil(t, DecodeOpenStreamRequest(bad.Encode()).Metadata)
+	assert.Equal(t, ErrInvalidMetadata, ValidateMetadata(bad.Metadata))
+
+	big := map[string]string{"k": string(make([]byte, MaxMetadataSize))}
+	assert.Equal(t, ErrMetadataTooLarge, ValidateMetadata(big))
+	assert.Nil(t, ValidateMetadata(nil))
+}
+
+func TestOpenStream_MaxWindowSize(t *testing.T) {
+	req := OpenStreamRequest{Domain: "d", WindowSize: 1024, MaxWindowSize: 1 << 24}
+	assert.Equal(t, req, DecodeOpenStreamRequest(req.Encode()))
+
+	ack := OpenStreamACK{OK: true, WindowSize: 1024, MaxWindowSize: 1 << 24}
+	assert.Equal(t, ack, DecodeOpenStreamACK(ack.Encode()))
+
+	// 旧版本只解析前 5 字节，新增字段为 0 时编码不变
+	legacy := OpenStreamACK{OK: true, WindowSize: 1024}
+	assert.Len(t, legacy.Encode(), 5)
+	assert.Equal(t, uint32(1024), DecodeOpenStreamACK(ack.Encode()[:5]).WindowSize)
+}
+
+func TestOpenStreamACK_Domain(t *testing.T) {
+	ack := OpenStreamACK{OK: true, WindowSize: 1024, Domain: "db-1"}
+	decoded := DecodeOpenStreamACK(ack.Encode())
+	assert.Equal(t, ack, decoded)
+
+	ack.MaxWindowSize = 1 << 24
+	assert.Equal(t, ack, DecodeOpenStreamACK(ack.Encode()))
+
+	// 旧版本只解析到 maxWindowSize，忽略之后的域名
+	assert.Equal(t, uint32(1<<24), DecodeOpenStreamACK(ack.Encode()[:9]).MaxWindowSize)
+}
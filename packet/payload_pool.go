@@ -0,0 +1,71 @@
+package packet
+
+import (
+	"math/bits"
+	"sync"
+	"unsafe"
+)
+
+// payload 池按 2 的幂分级，从 512B 到 64KB（容纳 MaxPayloadSize）。
+//
+// 所有权约定：
+//   - ReadBuffer 返回的 Buffer.Payload 归调用方所有。PayloadPooled 为 true 时
+//     payload 来自本池，调用方不再引用它之后可以调用 ReleasePayload 或
+//     PutPayload 归还；不归还也是安全的，由 GC 回收
+//   - 归还之后不能再访问 payload 以及由它切出的任何切片
+//   - 只能归还 GetPayload 返回的切片，应用传入 SetPayload 的切片不会被归还
+const (
+	minPayloadShift = 9
+	maxPayloadShift = 16
+)
+
+// 池中保存底层数组的首地址，Put 时不需要为切片头额外分配
+var payloadPools [maxPayloadShift - minPayloadShift + 1]sync.Pool
+
+// payloadClass 返回容纳 size 字节的最小分级
+func payloadClass(size int) int {
+	if size <= 1<<minPayloadShift {
+		return 0
+	}
+	return bits.Len(uint(size-1)) - minPayloadShift
+}
+
+// GetPayload 从池中取出长度为 size 的切片，内容未初始化。size 为 0 时返回 nil
+func GetPayload(size int) []byte {
+	if size <= 0 {
+		return nil
+	}
+	if size > MaxPayloadSize {
+		return make([]byte, size)
+	}
+	c := payloadClass(size)
+	capacity := 1 << (c + minPayloadShift)
+	if p, ok := payloadPools[c].Get().(*byte); ok {
+		return unsafe.Slice(p, capacity)[:size]
+	}
+	return make([]byte, size, capacity)
+}
+
+// PutPayload 把 GetPayload 返回的切片归还到池中，容量不属于任何分级的切片会被忽略
+func PutPayload(p []byte) {
+	c := cap(p)
+	if c < 1<<minPayloadShift || c > 1<<maxPayloadShift || c&(c-1) != 0 {
+		return
+	}
+	payloadPools[bits.Len(uint(c))-1-minPayloadShift].Put(unsafe.SliceData(p[:c]))
+}
+
+// PayloadPooled 判断 Payload 是否由 ReadBuffer 从 payload 池中取得
+func (buf *Buffer) PayloadPooled() bool {
+	return buf.pooled
+}
+
+// ReleasePayload 在 Payload 来自 payload 池时归还并清空 Payload，其他情况不做处理
+func (buf *Buffer) ReleasePayload() {
+	if !buf.pooled {
+		return
+	}
+	PutPayload(buf.Payload)
+	buf.Payload = nil
+	buf.pooled = false
+}
@@ -0,0 +1,67 @@
+package packet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetPayload(t *testing.T) {
+	tests := []struct {
+		size, cap int
+	}{
+		{1, 512},
+		{512, 512},
+		{513, 1024},
+		{4096, 4096},
+		{4097, 8192},
+		{MaxPayloadSize, 65536},
+	}
+	for _, tt := range tests {
+		p := GetPayload(tt.size)
+		assert.Equal(t, tt.size, len(p))
+		assert.Equal(t, tt.cap, cap(p), "size %d", tt.size)
+		PutPayload(p)
+	}
+
+	assert.Nil(t, GetPayload(0))
+	assert.Equal(t, MaxPayloadSize+1, len(GetPayload(MaxPayloadSize+1)))
+}
+
+func TestPutPayload_Foreign(t *testing.T) {
+	// 容量不属于任何分级的切片被忽略
+	PutPayload(nil)
+	PutPayload(make([]byte, 100))
+	PutPayload(make([]byte, 600))
+	PutPayload(make([]byte, 1<<17))
+
+	p := GetPayload(600)
+	assert.Equal(t, 600, len(p))
+	assert.Equal(t, 1024, cap(p))
+}
+
+func TestReleasePayload(t *testing.T) {
+	c1, c2 := Pipe()
+	go func() {
+		buf := NewBufferWithCmd(CmdPushStreamData)
+		buf.SetPayload([]byte("hello"))
+		c1.WriteBuffer(buf)
+	}()
+
+	recv, err := c2.ReadBuffer()
+	assert.Nil(t, err)
+	assert.True(t, recv.PayloadPooled())
+	assert.Equal(t, []byte("hello"), recv.Payload)
+
+	recv.ReleasePayload()
+	assert.False(t, recv.PayloadPooled())
+	assert.Nil(t, recv.Payload)
+
+	// 应用传入的 payload 不属于池，ReleasePayload 不做处理
+	payload := []byte("world")
+	buf := NewBuffer()
+	buf.SetPayload(payload)
+	assert.False(t, buf.PayloadPooled())
+	buf.ReleasePayload()
+	assert.Equal(t, payload, buf.Payload)
+}
@@ -1,6 +1,7 @@
 package packet
 
 import (
+	"bufio"
 	"errors"
 	"io"
 	"net"
@@ -18,13 +19,19 @@ type Reader interface {
 	SetReadTimeout(time.Duration) error
 }
 
+// ReadBufferSize 是 connReader 的读缓冲大小，多个小包可以由一次系统调用读入
+var ReadBufferSize = 32 * 1024
+
 // Reader implements with net.Conn
 type connReader struct {
 	conn net.Conn
+	br   *bufio.Reader
 }
 
+// NewConnReader 创建带缓冲的 Reader。缓冲中可能预读了后续的数据，
+// 创建之后不能再直接读取 conn
 func NewConnReader(conn net.Conn) Reader {
-	return &connReader{conn}
+	return &connReader{conn: conn, br: bufio.NewReaderSize(conn, ReadBufferSize)}
 }
 
 func (reader *connReader) SetReadTimeout(timeout time.Duration) error {
@@ -34,10 +41,11 @@ func (reader *connReader) SetReadTimeout(timeout time.Duration) error {
 	return reader.conn.SetReadDeadline(time.Now().Add(timeout))
 }
 
+// ReadBuffer 读取一个数据包，payload 来自 payload 池（PayloadPooled 为 true）
 func (reader *connReader) ReadBuffer() (retBuf *Buffer, retErr error) {
 	pb := GetBuffer()
 
-	_, err := io.ReadFull(reader.conn, pb.Head[:])
+	_, err := io.ReadFull(reader.br, pb.Head[:])
 	if err != nil {
 		PutBuffer(pb)
 		return nil, ErrReadHeaderFailed
@@ -45,9 +53,11 @@ func (reader *connReader) ReadBuffer() (retBuf *Buffer, retErr error) {
 
 	sz := pb.PayloadSize()
 	if sz > 0 {
-		pb.Payload = make([]byte, sz)
-		_, err := io.ReadFull(reader.conn, pb.Payload)
+		pb.Payload = GetPayload(int(sz))
+		pb.pooled = true
+		_, err := io.ReadFull(reader.br, pb.Payload)
 		if err != nil {
+			pb.ReleasePayload()
 			PutBuffer(pb)
 			return nil, ErrReadPayloadFailed
 		}
@@ -151,3 +151,42 @@ func TestReader_MultipleSequentialReads(t *testing.T) {
 		assert.Equal(t, []byte("data"), recv.Payload, "packet %d", i)
 	}
 }
+
+// countingConn 记录 Read 的调用次数
+type countingConn struct {
+	net.Conn
+	reads int
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	c.reads++
+	return c.Conn.Read(b)
+}
+
+func TestReader_BufferedReads(t *testing.T) {
+	c1, c2 := net.Pipe()
+	conn := &countingConn{Conn: c1}
+	r := NewConnReader(conn)
+	const n = 10
+
+	// 多个小包在一次写入中到达，读取时不需要每个包两次系统调用
+	go func() {
+		var data []byte
+		for i := range n {
+			buf := NewBufferWithCmd(CmdPushStreamData)
+			buf.SetSrcPort(uint16(i))
+			buf.SetPayload([]byte("data"))
+			data = append(data, buf.Head[:]...)
+			data = append(data, buf.Payload...)
+		}
+		c2.Write(data)
+	}()
+
+	for i := range n {
+		recv, err := r.ReadBuffer()
+		require.NoError(t, err)
+		assert.Equal(t, uint16(i), recv.SrcPort())
+		assert.Equal(t, []byte("data"), recv.Payload)
+	}
+	assert.Less(t, conn.reads, n)
+}
@@ -0,0 +1,301 @@
+// Package socks5 implements a SOCKS5 proxy server (RFC 1928) whose CONNECT
+// requests are dialed through a flex Node or Session.
+package socks5
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/net-agent/flex/v3/node"
+	"github.com/net-agent/flex/v3/stream"
+)
+
+var (
+	ErrUnsupportedVersion = errors.New("unsupported socks version")
+	ErrNoAcceptableMethod = errors.New("no acceptable auth method")
+	ErrAuthFailed         = errors.New("socks auth failed")
+	ErrUnsupportedCommand = errors.New("unsupported socks command")
+	ErrUnsupportedAddr    = errors.New("unsupported address type")
+)
+
+// DefaultHandshakeTimeout 限制从建立连接到发出 CONNECT 请求的时间
+var DefaultHandshakeTimeout = 10 * time.Second
+
+const (
+	version5 = 0x05
+
+	methodNoAuth       = 0x00
+	methodUserPass     = 0x02
+	methodNoAcceptable = 0xff
+
+	userPassVersion = 0x01
+
+	cmdConnect = 0x01
+
+	atypIPv4   = 0x01
+	atypDomain = 0x03
+	atypIPv6   = 0x04
+
+	repSucceeded        = 0x00
+	repNotAllowed       = 0x02
+	repHostUnreachable  = 0x04
+	repConnRefused      = 0x05
+	repCmdNotSupported  = 0x07
+	repAddrNotSupported = 0x08
+)
+
+// Dialer opens flex streams; *node.Node and *node.Session implement it.
+type Dialer interface {
+	Dial(addr string) (*stream.Stream, error)
+}
+
+// Server is a SOCKS5 server that only supports CONNECT. The target host is
+// looked up in the host table and otherwise used as the flex domain as is,
+// so "CONNECT node-a:8080" dials "node-a:8080".
+type Server struct {
+	dialer Dialer
+	logger *slog.Logger
+
+	mu       sync.RWMutex
+	username string
+	password string
+	hosts    map[string]string // real hostname -> flex domain
+}
+
+func NewServer(d Dialer, logger *slog.Logger) *Server {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Server{
+		dialer: d,
+		logger: logger.With("module", "socks5"),
+		hosts:  make(map[string]string),
+	}
+}
+
+// SetAuth requires clients to authenticate with username and password.
+// An empty username disables authentication.
+func (s *Server) SetAuth(username, password string) {
+	s.mu.Lock()
+	s.username, s.password = username, password
+	s.mu.Unlock()
+}
+
+// MapHost makes requests for host go to the flex domain instead.
+func (s *Server) MapHost(host, domain string) {
+	s.mu.Lock()
+	s.hosts[strings.ToLower(host)] = domain
+	s.mu.Unlock()
+}
+
+// UnmapHost removes the mapping of host.
+func (s *Server) UnmapHost(host string) {
+	s.mu.Lock()
+	delete(s.hosts, strings.ToLower(host))
+	s.mu.Unlock()
+}
+
+func (s *Server) credentials() (string, string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.username, s.password
+}
+
+// target 返回 host 对应的 flex 地址
+func (s *Server) target(host string, port uint16) string {
+	s.mu.RLock()
+	if domain, ok := s.hosts[strings.ToLower(host)]; ok {
+		host = domain
+	}
+	s.mu.RUnlock()
+	return net.JoinHostPort(host, strconv.Itoa(int(port)))
+}
+
+// Serve accepts connections on l until it is closed.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn handles a single client connection and closes it when done.
+func (s *Server) ServeConn(conn net.Conn) error {
+	defer conn.Close()
+	client := conn.RemoteAddr().String()
+
+	conn.SetDeadline(time.Now().Add(DefaultHandshakeTimeout))
+	user, err := s.negotiate(conn)
+	if err != nil {
+		s.logger.Warn("socks handshake failed", "client", client, "error", err)
+		return err
+	}
+	host, port, err := readRequest(conn)
+	if err != nil {
+		s.logger.Warn("socks request failed", "client", client, "user", user, "error", err)
+		return err
+	}
+
+	target := s.target(host, port)
+	st, err := s.dialer.Dial(target)
+	if err != nil {
+		writeReply(conn, dialReply(err))
+		s.logger.Warn("socks dial failed", "client", client, "user", user, "host", host, "target", target, "error", err)
+		return err
+	}
+	if err := writeReply(conn, repSucceeded); err != nil {
+		st.Close()
+		return err
+	}
+	conn.SetDeadline(time.Time{})
+
+	start := time.Now()
+	s.logger.Info("socks connect", "client", client, "user", user, "host", host, "target", target)
+	sent, received, err := node.Join(conn, st)
+	s.logger.Info("socks connection closed", "client", client, "user", user, "target", target,
+		"bytes_sent", sent, "bytes_received", received, "duration", time.Since(start).Round(time.Millisecond), "error", err)
+	return err
+}
+
+// negotiate 选择认证方式并完成认证，返回用户名
+func (s *Server) negotiate(conn net.Conn) (string, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+		return "", err
+	}
+	if hdr[0] != version5 {
+		return "", ErrUnsupportedVersion
+	}
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+
+	username, password := s.credentials()
+	want := byte(methodNoAuth)
+	if username != "" {
+		want = methodUserPass
+	}
+	if !slices.Contains(methods, want) {
+		conn.Write([]byte{version5, methodNoAcceptable})
+		return "", ErrNoAcceptableMethod
+	}
+	if _, err := conn.Write([]byte{version5, want}); err != nil {
+		return "", err
+	}
+	if want == methodNoAuth {
+		return "", nil
+	}
+
+	// RFC 1929
+	user, pass, err := readUserPass(conn)
+	if err != nil {
+		return "", err
+	}
+	if user != username || pass != password {
+		conn.Write([]byte{userPassVersion, 0x01})
+		return user, ErrAuthFailed
+	}
+	_, err = conn.Write([]byte{userPassVersion, 0x00})
+	return user, err
+}
+
+func readUserPass(r io.Reader) (string, string, error) {
+	var ver [1]byte
+	if _, err := io.ReadFull(r, ver[:]); err != nil {
+		return "", "", err
+	}
+	if ver[0] != userPassVersion {
+		return "", "", ErrUnsupportedVersion
+	}
+	user, err := readString(r)
+	if err != nil {
+		return "", "", err
+	}
+	pass, err := readString(r)
+	return user, pass, err
+}
+
+// readString 读取 1 字节长度前缀的字符串
+func readString(r io.Reader) (string, error) {
+	var n [1]byte
+	if _, err := io.ReadFull(r, n[:]); err != nil {
+		return "", err
+	}
+	buf := make([]byte, n[0])
+	_, err := io.ReadFull(r, buf)
+	return string(buf), err
+}
+
+// readRequest 读取 CONNECT 请求，不支持的请求会先应答错误
+func readRequest(conn net.Conn) (string, uint16, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+		return "", 0, err
+	}
+	if hdr[0] != version5 {
+		return "", 0, ErrUnsupportedVersion
+	}
+
+	var host string
+	switch hdr[3] {
+	case atypIPv4, atypIPv6:
+		size := net.IPv4len
+		if hdr[3] == atypIPv6 {
+			size = net.IPv6len
+		}
+		ip := make(net.IP, size)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", 0, err
+		}
+		host = ip.String()
+	case atypDomain:
+		var err error
+		if host, err = readString(conn); err != nil {
+			return "", 0, err
+		}
+	default:
+		writeReply(conn, repAddrNotSupported)
+		return "", 0, ErrUnsupportedAddr
+	}
+
+	var port [2]byte
+	if _, err := io.ReadFull(conn, port[:]); err != nil {
+		return "", 0, err
+	}
+	if hdr[1] != cmdConnect {
+		writeReply(conn, repCmdNotSupported)
+		return "", 0, ErrUnsupportedCommand
+	}
+	return host, binary.BigEndian.Uint16(port[:]), nil
+}
+
+// writeReply 应答请求结果，绑定地址固定为 0.0.0.0:0
+func writeReply(w io.Writer, rep byte) error {
+	_, err := w.Write([]byte{version5, rep, 0x00, atypIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// dialReply 将 Dial 的错误映射为 socks5 应答码
+func dialReply(err error) byte {
+	switch {
+	case errors.Is(err, node.ErrAccessDenied):
+		return repNotAllowed
+	case errors.Is(err, node.ErrListenerNotFound),
+		errors.Is(err, node.ErrListenerClosed),
+		errors.Is(err, node.ErrListenerBacklogFull):
+		return repConnRefused
+	}
+	return repHostUnreachable
+}
@@ -0,0 +1,160 @@
+package socks5
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/net-agent/flex/v3/node"
+	"github.com/stretchr/testify/assert"
+)
+
+// connect 以 socks5 客户端的身份发起 CONNECT，返回应答码
+func connect(t *testing.T, addr, user, pass, host string, port uint16) (net.Conn, byte) {
+	c, err := net.Dial("tcp", addr)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	c.SetDeadline(time.Now().Add(time.Second))
+
+	method := byte(methodNoAuth)
+	if user != "" {
+		method = methodUserPass
+	}
+	c.Write([]byte{version5, 1, method})
+	resp := make([]byte, 2)
+	if _, err := io.ReadFull(c, resp); err != nil || resp[1] != method {
+		return c, methodNoAcceptable
+	}
+	if user != "" {
+		c.Write(append(append([]byte{userPassVersion, byte(len(user))}, user...), append([]byte{byte(len(pass))}, pass...)...))
+		if _, err := io.ReadFull(c, resp); err != nil || resp[1] != 0 {
+			return c, methodNoAcceptable
+		}
+	}
+
+	req := append([]byte{version5, cmdConnect, 0, atypDomain, byte(len(host))}, host...)
+	req = binary.BigEndian.AppendUint16(req, port)
+	c.Write(req)
+	reply := make([]byte, 10)
+	if _, err := io.ReadFull(c, reply); err != nil {
+		return c, 0xff
+	}
+	c.SetDeadline(time.Time{})
+	return c, reply[1]
+}
+
+func initServer(t *testing.T) (*Server, string) {
+	n1, n2 := node.Pipe("test1", "test2")
+	t.Cleanup(func() {
+		n1.Close()
+		n2.Close()
+	})
+	l, err := n2.Listen(80)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	denied, err := n2.Listen(82)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	denied.(*node.Listener).SetAuthorizer(func(string, uint16, map[string]string) error { return node.ErrAccessDenied })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+
+	s := NewServer(n1, nil)
+	sl, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() { sl.Close() })
+	go s.Serve(sl)
+	return s, sl.Addr().String()
+}
+
+func assertEcho(t *testing.T, c net.Conn) {
+	c.Write([]byte("hello"))
+	buf := make([]byte, 5)
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	_, err := io.ReadFull(c, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(buf))
+}
+
+func TestConnect(t *testing.T) {
+	s, addr := initServer(t)
+
+	c, rep := connect(t, addr, "", "", "test2", 80)
+	assert.Equal(t, byte(repSucceeded), rep)
+	assertEcho(t, c)
+	c.Close()
+
+	c, rep = connect(t, addr, "", "", "test2", 81)
+	assert.Equal(t, byte(repConnRefused), rep)
+	c.Close()
+
+	c, rep = connect(t, addr, "", "", "test2", 82)
+	assert.Equal(t, byte(repNotAllowed), rep)
+	c.Close()
+
+	// 真实主机名映射到 flex 域名
+	s.MapHost("Intranet.Example.com", "test2")
+	c, rep = connect(t, addr, "", "", "intranet.example.com", 80)
+	assert.Equal(t, byte(repSucceeded), rep)
+	assertEcho(t, c)
+	c.Close()
+	assert.Equal(t, "test2:80", s.target("intranet.example.com", 80))
+	s.UnmapHost("intranet.example.com")
+	assert.Equal(t, "intranet.example.com:80", s.target("intranet.example.com", 80))
+}
+
+func TestAuth(t *testing.T) {
+	s, addr := initServer(t)
+	s.SetAuth("user", "pswd")
+
+	c, rep := connect(t, addr, "", "", "test2", 80)
+	assert.Equal(t, byte(methodNoAcceptable), rep)
+	c.Close()
+
+	c, rep = connect(t, addr, "user", "wrong", "test2", 80)
+	assert.Equal(t, byte(methodNoAcceptable), rep)
+	c.Close()
+
+	c, rep = connect(t, addr, "user", "pswd", "test2", 80)
+	assert.Equal(t, byte(repSucceeded), rep)
+	assertEcho(t, c)
+	c.Close()
+}
+
+func TestUnsupportedRequest(t *testing.T) {
+	_, addr := initServer(t)
+	c, err := net.Dial("tcp", addr)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(time.Second))
+
+	c.Write([]byte{version5, 1, methodNoAuth})
+	resp := make([]byte, 2)
+	io.ReadFull(c, resp)
+
+	// BIND
+	c.Write([]byte{version5, 0x02, 0, atypIPv4, 127, 0, 0, 1, 0, 80})
+	reply := make([]byte, 10)
+	_, err = io.ReadFull(c, reply)
+	assert.Nil(t, err)
+	assert.Equal(t, byte(repCmdNotSupported), reply[1])
+}
@@ -0,0 +1,146 @@
+package stream
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/net-agent/flex/v3/packet"
+)
+
+var (
+	// 数据确认的合并参数：累计读取 DefaultAckThreshold 字节（不超过窗口的 1/4）
+	// 或者距第一次未确认的读取经过 DefaultAckDelay 后发送一个 ACK
+	DefaultAckThreshold int32         = 64 * KB
+	DefaultAckDelay     time.Duration = 2 * time.Millisecond
+)
+
+// ackBatcher 合并 Read 消费的字节。同一时间最多有一个 flush 在发送 ACK，
+// 因此 ACK 按授信的顺序发出，Read 也不会因为发送 ACK 而阻塞。
+type ackBatcher struct {
+	mu        sync.Mutex
+	pending   int64 // 已读取、还没有确认的字节
+	threshold int64
+	delay     time.Duration
+	timer     *time.Timer
+	flushing  bool
+	stopped   bool
+}
+
+// SetAckPolicy 设置数据确认的合并参数，需在使用 stream 前调用。threshold 为 1 或
+// delay 为 0 时每次 Read 都立即确认
+func (s *Stream) SetAckPolicy(threshold int32, delay time.Duration) {
+	if threshold > 0 {
+		s.ack.threshold = int64(threshold)
+	}
+	if delay >= 0 {
+		s.ack.delay = delay
+	}
+}
+
+// consumeAck 记录 Read 消费的 n 字节，满足阈值时发送 ACK，否则等待定时器
+func (s *Stream) consumeAck(n int) {
+	atomic.AddInt32(&s.state.ReadCount, 1)
+
+	a := &s.ack
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.stopped {
+		return
+	}
+	a.pending += int64(n)
+	if a.flushing {
+		return // 正在发送的 flush 结束前会处理剩余的字节
+	}
+	if a.pending >= s.ackThreshold() || a.delay <= 0 {
+		a.flushing = true
+		if a.timer != nil {
+			a.timer.Stop()
+			a.timer = nil
+		}
+		go s.flushAck()
+		return
+	}
+	if a.timer == nil {
+		a.timer = time.AfterFunc(a.delay, s.flushAckTimer)
+	}
+}
+
+// ackThreshold 返回实际使用的阈值，不超过窗口的 1/4，避免发送端等待确认
+func (s *Stream) ackThreshold() int64 {
+	window := int64(s.state.Window)
+	if s.tuner != nil {
+		window, _ = s.tuner.stats()
+	}
+	return max(min(s.ack.threshold, window/4), 1)
+}
+
+func (s *Stream) flushAckTimer() {
+	a := &s.ack
+	a.mu.Lock()
+	a.timer = nil
+	if a.flushing || a.stopped || a.pending == 0 {
+		a.mu.Unlock()
+		return
+	}
+	a.flushing = true
+	a.mu.Unlock()
+	s.flushAck()
+}
+
+// flushAck 发送累计的确认，直到剩余字节不足阈值
+func (s *Stream) flushAck() {
+	a := &s.ack
+	for {
+		a.mu.Lock()
+		n := a.pending
+		a.pending = 0
+		a.mu.Unlock()
+
+		credit := n
+		if s.tuner != nil {
+			credit = s.tuner.onRead(int(n), time.Now())
+		}
+		s.sendDataAck(credit)
+
+		a.mu.Lock()
+		if !a.stopped && a.pending >= s.ackThreshold() {
+			a.mu.Unlock()
+			continue
+		}
+		a.flushing = false
+		if !a.stopped && a.pending > 0 && a.timer == nil {
+			a.timer = time.AfterFunc(a.delay, s.flushAckTimer)
+		}
+		a.mu.Unlock()
+		return
+	}
+}
+
+// stopAck 在读方向关闭后停止发送确认
+func (s *Stream) stopAck() {
+	a := &s.ack
+	a.mu.Lock()
+	a.stopped = true
+	a.pending = 0
+	if a.timer != nil {
+		a.timer.Stop()
+		a.timer = nil
+	}
+	a.mu.Unlock()
+}
+
+// sendDataAck 向对端授予 credit 字节的发送额度，超过单个 ACK 上限时分多次发送
+func (s *Stream) sendDataAck(credit int64) {
+	for credit > 0 {
+		n := min(credit, packet.MaxPayloadSize)
+		err := s.sender.SendDataAck(uint16(n))
+		if err != nil {
+			s.logger.Warn("SendDataAck failed", "error", err.Error())
+			return
+		}
+		atomic.AddInt32(&s.state.AckCount, 1)
+		atomic.AddInt64(&s.state.SentAckTotal, n)
+		credit -= n
+	}
+}
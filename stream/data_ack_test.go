@@ -0,0 +1,110 @@
+package stream
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/net-agent/flex/v3/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+// ackWriter records the credit of each AckPushStreamData.
+type ackWriter struct {
+	mu   sync.Mutex
+	acks []uint16
+}
+
+func (w *ackWriter) WriteBuffer(buf *packet.Buffer) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if buf.Cmd() == packet.AckPushStreamData {
+		w.acks = append(w.acks, buf.DataACKSize())
+	}
+	return nil
+}
+
+func (w *ackWriter) SetWriteTimeout(dur time.Duration) {}
+
+func (w *ackWriter) total() (count, sum int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, n := range w.acks {
+		sum += int(n)
+	}
+	return len(w.acks), sum
+}
+
+// readN 让 s 读取 count 次，每次 size 字节
+func readN(t *testing.T, s *Stream, count, size int) {
+	buf := make([]byte, size)
+	for i := 0; i < count; i++ {
+		s.readBuf = make([]byte, size)
+		n, err := s.Read(buf)
+		assert.Nil(t, err)
+		assert.Equal(t, size, n)
+	}
+}
+
+func TestAckCoalescing(t *testing.T) {
+	w := &ackWriter{}
+	s := New(w, 0)
+	s.SetAckPolicy(64*KB, testShortTimeout)
+
+	// 不足阈值的读取在定时器到期后合并为一个 ACK
+	readN(t, s, 100, 10)
+	count, _ := w.total()
+	assert.Equal(t, 0, count)
+	assert.Eventually(t, func() bool {
+		count, sum := w.total()
+		return count == 1 && sum == 1000
+	}, testLongTimeout, time.Millisecond)
+
+	st := s.GetState()
+	assert.Equal(t, int32(100), st.ReadCount)
+	assert.Equal(t, int32(1), st.AckCount)
+	assert.Equal(t, int64(1000), st.SentAckTotal)
+}
+
+func TestAckThreshold(t *testing.T) {
+	w := &ackWriter{}
+	s := New(w, 0)
+	s.SetAckPolicy(4*KB, time.Hour)
+
+	// 达到阈值立即确认，不等待定时器
+	readN(t, s, 8, KB)
+	assert.Eventually(t, func() bool {
+		_, sum := w.total()
+		return sum == 8*KB
+	}, testLongTimeout, time.Millisecond)
+	count, _ := w.total()
+	assert.LessOrEqual(t, count, 2)
+
+	// 阈值不超过窗口的 1/4
+	s = New(w, 8*KB)
+	assert.Equal(t, int64(2*KB), s.ackThreshold())
+}
+
+func TestAckImmediate(t *testing.T) {
+	w := &ackWriter{}
+	s := New(w, 0)
+	s.SetAckPolicy(64*KB, 0)
+
+	readN(t, s, 3, 10)
+	assert.Eventually(t, func() bool {
+		_, sum := w.total()
+		return sum == 30
+	}, testLongTimeout, time.Millisecond)
+}
+
+func TestAckStopOnCloseRead(t *testing.T) {
+	w := &ackWriter{}
+	s := New(w, 0)
+	s.SetAckPolicy(64*KB, testShortTimeout)
+
+	readN(t, s, 1, 10)
+	s.CloseRead()
+	time.Sleep(2 * testShortTimeout)
+	count, _ := w.total()
+	assert.Equal(t, 0, count, "no ack after the read side is closed")
+}
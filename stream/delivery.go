@@ -0,0 +1,93 @@
+package stream
+
+import (
+	"sync/atomic"
+
+	"github.com/net-agent/flex/v3/packet"
+)
+
+// Deliver 按到达顺序把对端发来的数据包和关闭包交给 stream 处理，不会阻塞调用方。
+//
+// 每个 stream 有独立的投递队列，只在队列非空时占用一个 goroutine。应用读取缓慢时
+// 只有这个 stream 的投递会等待，不影响同一连接上的其他 stream。队列中的数据受流控
+// 窗口限制，遵守窗口的对端不会让队列超过 inboxLimit；超过时对端已不可信，
+// 丢弃数据并以 CodeFlowControl 终止 stream。
+//
+// 对端的 Reset 会丢弃未读数据，收到时立即停止等待应用读取，不排在数据之后。
+func (s *Stream) Deliver(pbuf *packet.Buffer) {
+	if pbuf.Cmd() == packet.CmdCloseStream && packet.DecodeCloseCode(pbuf.Payload) != packet.CodeNone {
+		s.stopRecv()
+	}
+	s.inboxMu.Lock()
+	if pbuf.Cmd() == packet.CmdPushStreamData {
+		size := int64(pbuf.PayloadSize())
+		if s.inboxOverflow || s.inboxBytes+size > s.inboxLimit() {
+			overflow, queued := !s.inboxOverflow, s.inboxBytes
+			s.inboxOverflow = true
+			s.inboxMu.Unlock()
+			pbuf.ReleasePayload()
+			if overflow {
+				s.logger.Error("peer exceeds the receive window, resetting stream", "inbox_bytes", queued)
+				go s.Reset(packet.CodeFlowControl)
+			}
+			return
+		}
+		s.inboxBytes += size
+	}
+	atomic.AddInt32(&s.state.DeliverBacklog, 1)
+	s.inbox = append(s.inbox, pbuf)
+	if s.delivering {
+		s.inboxMu.Unlock()
+		return
+	}
+	s.delivering = true
+	s.inboxMu.Unlock()
+
+	go s.deliverLoop()
+}
+
+func (s *Stream) deliverLoop() {
+	for {
+		s.inboxMu.Lock()
+		if len(s.inbox) == 0 {
+			s.inbox = nil
+			s.delivering = false
+			s.inboxMu.Unlock()
+			return
+		}
+		pbuf := s.inbox[0]
+		s.inbox[0] = nil
+		s.inbox = s.inbox[1:]
+		if pbuf.Cmd() == packet.CmdPushStreamData {
+			s.inboxBytes -= int64(pbuf.PayloadSize())
+		}
+		s.inboxMu.Unlock()
+
+		s.handle(pbuf)
+		atomic.AddInt32(&s.state.DeliverBacklog, -1)
+	}
+}
+
+// inboxLimit 返回 inbox 中数据的上限：本端可能授予对端的最大窗口，再加一个包
+func (s *Stream) inboxLimit() int64 {
+	window := int64(s.state.Window)
+	if s.tuner != nil {
+		window = max(window, s.tuner.max)
+	}
+	return window + packet.MaxPayloadSize
+}
+
+func (s *Stream) handle(pbuf *packet.Buffer) {
+	switch pbuf.Cmd() {
+	case packet.CmdPushStreamData:
+		s.HandleCmdPushStreamData(pbuf)
+	case packet.AckPushStreamData:
+		s.HandleAckPushStreamData(pbuf)
+	case packet.CmdCloseStream:
+		s.HandleCmdCloseStream(pbuf)
+	case packet.AckCloseStream:
+		s.HandleAckCloseStream(pbuf)
+	default:
+		s.logger.Warn("unexpected packet delivered to stream", "header", pbuf.HeaderString())
+	}
+}
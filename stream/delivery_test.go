@@ -0,0 +1,116 @@
+package stream
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/net-agent/flex/v3/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func dataPacket(payload string) *packet.Buffer {
+	pbuf := packet.NewBufferWithCmd(packet.CmdPushStreamData)
+	pbuf.SetPayload([]byte(payload))
+	return pbuf
+}
+
+func TestDeliverOrder(t *testing.T) {
+	s := New(&mockWriter{}, 0)
+	s.Deliver(dataPacket("hello "))
+	s.Deliver(dataPacket("world"))
+	s.Deliver(packet.NewBufferWithCmd(packet.CmdCloseStream))
+
+	// 关闭包在已收到的数据之后处理
+	got, err := io.ReadAll(s)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello world", string(got))
+}
+
+func TestDeliverDoesNotBlock(t *testing.T) {
+	s := New(&mockWriter{}, 0)
+	s.recvQueue = make(chan recvChunk, 1)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		s.Deliver(dataPacket("x"))
+	}
+	assert.Less(t, time.Since(start), testShortTimeout, "Deliver must not wait for the reader")
+	assert.Eventually(t, func() bool { return s.GetState().DeliverBacklog == 2 }, testLongTimeout, time.Millisecond)
+
+	time.Sleep(testShortTimeout)
+	buf := make([]byte, 1)
+	for i := 0; i < 3; i++ {
+		_, err := s.Read(buf)
+		assert.Nil(t, err)
+	}
+	assert.Eventually(t, func() bool { return s.GetState().DeliverBacklog == 0 }, testLongTimeout, time.Millisecond)
+	assert.GreaterOrEqual(t, s.GetState().DeliverBlocked, testShortTimeout, "time waiting for the reader is recorded")
+}
+
+func TestDeliverOverflow(t *testing.T) {
+	w := &recordingWriter{}
+	s := New(w, 64*KB)
+	s.recvQueue = make(chan recvChunk, 1)
+
+	// 遵守窗口的对端最多发送一个窗口的数据
+	payload := string(make([]byte, 16*KB))
+	for i := 0; i < 4; i++ {
+		s.Deliver(dataPacket(payload))
+	}
+	time.Sleep(testShortTimeout)
+	assert.Nil(t, s.resetError())
+
+	// 超出窗口后丢弃数据，并以 CodeFlowControl 终止 stream
+	for i := 0; i < 8; i++ {
+		s.Deliver(dataPacket(payload))
+	}
+	assert.Eventually(t, func() bool { return s.resetError() != nil }, testLongTimeout, time.Millisecond)
+	var rerr *ResetError
+	if assert.ErrorAs(t, s.resetError(), &rerr) {
+		assert.Equal(t, packet.CodeFlowControl, rerr.Code)
+	}
+	s.inboxMu.Lock()
+	assert.LessOrEqual(t, s.inboxBytes, s.inboxLimit())
+	s.inboxMu.Unlock()
+}
+
+func TestDeliverSlowReader(t *testing.T) {
+	w := &recordingWriter{}
+	s := New(w, 64*KB)
+	s.recvQueue = make(chan recvChunk, 4)
+
+	// 大量小包远多于接收队列的容量，但总量在窗口之内，不终止 stream
+	const count = 2000
+	for i := 0; i < count; i++ {
+		s.Deliver(dataPacket(string(rune('a' + i%26))))
+	}
+	time.Sleep(testMedTimeout)
+	assert.Nil(t, s.resetError())
+
+	buf := make([]byte, 1)
+	for i := 0; i < count; i++ {
+		_, err := io.ReadFull(s, buf)
+		if !assert.Nil(t, err) {
+			return
+		}
+		assert.Equal(t, byte('a'+i%26), buf[0])
+	}
+	assert.Nil(t, s.resetError())
+	assert.Eventually(t, func() bool { return s.GetState().DeliverBacklog == 0 }, testLongTimeout, time.Millisecond)
+}
+
+func TestDeliverResetWhileBlocked(t *testing.T) {
+	s := New(&mockWriter{}, 0)
+	s.recvQueue = make(chan recvChunk, 1)
+	s.Deliver(dataPacket("a"))
+	s.Deliver(dataPacket("b"))
+
+	// 应用不读取时，对端的 Reset 不排在被阻塞的数据之后
+	reset := packet.NewBufferWithCmd(packet.CmdCloseStream)
+	reset.SetPayload(packet.EncodeCloseCode(packet.CodeCanceled))
+	s.Deliver(reset)
+	assert.Eventually(t, func() bool { return s.resetError() != nil }, testLongTimeout, time.Millisecond)
+	_, err := s.Read(make([]byte, 1))
+	assert.Error(t, err)
+}
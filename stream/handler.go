@@ -25,6 +25,7 @@ func (s *Stream) HandleCmdPushStreamData(pbuf *packet.Buffer) {
 
 	if s.readClosed {
 		s.logger.Info("stream closed")
+		pbuf.ReleasePayload()
 		return
 	}
 
@@ -33,22 +34,34 @@ func (s *Stream) HandleCmdPushStreamData(pbuf *packet.Buffer) {
 		return
 	}
 
-	timer := time.NewTimer(s.recvPushTimeout)
-	defer timer.Stop()
-
+	chunk := recvChunk{data: pbuf.Payload, pooled: pbuf.PayloadPooled()}
 	select {
-	case s.recvQueue <- pbuf.Payload:
-		atomic.AddInt64(&s.state.RecvDataSize, int64(pbuf.PayloadSize()))
-		return
-	case <-timer.C:
-		s.logger.Error("append data timeout, closing stream to prevent window desync")
-		// 数据丢失会导致流控窗口不一致（发送端窗口无法恢复），
-		// 必须主动关闭 stream 让两端都能感知异常。
-		go func() {
-			s.CloseWrite()
-			s.CloseRead()
-		}()
+	case s.recvQueue <- chunk:
+		s.onDataQueued(pbuf)
 		return
+	default:
+	}
+
+	// 接收队列已满，等待应用读取并记录等待的时间。这里只阻塞本 stream 的投递，
+	// 遵守窗口的对端不会因为应用读取缓慢被终止，超出窗口的数据由 Deliver 处理
+	start := time.Now()
+	defer func() {
+		atomic.AddInt64((*int64)(&s.state.DeliverBlocked), int64(time.Since(start)))
+	}()
+
+	select {
+	case s.recvQueue <- chunk:
+		s.onDataQueued(pbuf)
+	case <-s.recvStop:
+		pbuf.ReleasePayload()
+	}
+}
+
+func (s *Stream) onDataQueued(pbuf *packet.Buffer) {
+	received := atomic.AddInt64(&s.state.RecvDataSize, int64(pbuf.PayloadSize()))
+	if s.tuner != nil {
+		unread := received - atomic.LoadInt64(&s.state.BytesRead)
+		s.tuner.onData(int(pbuf.PayloadSize()), unread, time.Now())
 	}
 }
 
@@ -64,11 +77,22 @@ func (s *Stream) HandleAckPushStreamData(pbuf *packet.Buffer) {
 // * 由于对端已经请求关闭，所以本地应该尽快关闭write状态，并告知对面
 func (s *Stream) HandleCmdCloseStream(pbuf *packet.Buffer) {
 	atomic.AddInt32(&s.state.RecvBufferCount, 1)
+	if packet.IsCloseWrite(pbuf.Payload) {
+		// 对端只关闭了写方向：读完已收到的数据后 Read 返回 EOF，本端仍可写
+		s.CloseRead()
+		s.sender.SendCloseWriteAck()
+		return
+	}
+	if code := packet.DecodeCloseCode(pbuf.Payload); code != packet.CodeNone {
+		s.handleReset(code)
+		return
+	}
+
 	// step1：响应对端的close请求，停止继续读数据
 	s.CloseRead()
 
 	// step2：停止继续写入
-	s.CloseWrite()
+	s.closeWriteLocal()
 
 	// step3：回复closeAck，让对端停止读取
 	s.sender.SendCloseAck()
@@ -76,6 +100,11 @@ func (s *Stream) HandleCmdCloseStream(pbuf *packet.Buffer) {
 
 func (s *Stream) HandleAckCloseStream(pbuf *packet.Buffer) {
 	atomic.AddInt32(&s.state.RecvBufferCount, 1)
+	// 第一个确认属于 CloseWrite 发出的 close 包，写方向至此完成
+	if s.finSent.Load() && s.finAcked.CompareAndSwap(false, true) {
+		s.markWriteClosed()
+		return
+	}
 	select {
 	case s.closeAckCh <- struct{}{}:
 	default:
@@ -25,14 +25,30 @@ func TestHandleCmdData(t *testing.T) {
 	})
 }
 
-func TestHandleCmdData_AppendTimeout(t *testing.T) {
-	s := New(nil, 0)
+func TestHandleCmdData_QueueFull(t *testing.T) {
+	s := New(&mockWriter{}, 0)
 	pbuf := packet.NewBufferWithCmd(packet.CmdPushStreamData)
 	pbuf.SetPayload([]byte("hello"))
+	s.recvQueue = make(chan recvChunk)
 
-	s.recvQueue = make(chan []byte)
-	s.recvPushTimeout = testMedTimeout
-	s.HandleCmdPushStreamData(pbuf)
+	// 接收队列已满时等待应用读取，不终止 stream
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.HandleCmdPushStreamData(pbuf)
+	}()
+	select {
+	case <-done:
+		t.Fatal("handler must wait for the reader")
+	case <-time.After(testMedTimeout):
+	}
+	assert.Nil(t, s.resetError())
+
+	buf := make([]byte, 5)
+	n, err := s.Read(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(buf[:n]))
+	<-done
 }
 
 func TestHandleAckData(t *testing.T) {
@@ -93,10 +109,9 @@ func TestConcurrentHandleCmdPushStreamData(t *testing.T) {
 func TestHandleCmdPushStreamData_CloseReadWhileChannelFull(t *testing.T) {
 	// 验证当 bytesChan 满时，CloseRead 不会被 HandleCmdPushStreamData 长时间阻塞
 	s := New(&mockWriter{}, 0)
-	s.recvPushTimeout = testMedTimeout
 	// 填满 bytesChan
 	for i := 0; i < cap(s.recvQueue); i++ {
-		s.recvQueue <- []byte("fill")
+		s.recvQueue <- recvChunk{data: []byte("fill")}
 	}
 
 	// 启动一个 handler，它会阻塞在 bytesChan 发送上
@@ -108,7 +123,7 @@ func TestHandleCmdPushStreamData_CloseReadWhileChannelFull(t *testing.T) {
 		s.HandleCmdPushStreamData(pbuf)
 	}()
 
-	// CloseRead 应该在 appendDataTimeout 内完成（handler 释放 RLock 后）
+	// CloseRead 先唤醒 handler，不等待它超时释放 RLock
 	closeDone := make(chan error, 1)
 	go func() {
 		closeDone <- s.CloseRead()
@@ -117,7 +132,7 @@ func TestHandleCmdPushStreamData_CloseReadWhileChannelFull(t *testing.T) {
 	select {
 	case err := <-closeDone:
 		assert.Nil(t, err)
-	case <-time.After(s.recvPushTimeout + testLongTimeout):
+	case <-time.After(testLongTimeout):
 		t.Fatal("CloseRead blocked too long — soft deadlock detected")
 	}
 
@@ -183,8 +198,8 @@ func TestHandleCmdPushStreamData_PayloadBoundedByMaxPayloadSize(t *testing.T) {
 
 	// 从 bytesChan 取出，验证长度
 	select {
-	case buf := <-s.recvQueue:
-		assert.Equal(t, packet.MaxPayloadSize, len(buf),
+	case chunk := <-s.recvQueue:
+		assert.Equal(t, packet.MaxPayloadSize, len(chunk.data),
 			"payload in bytesChan should be exactly MaxPayloadSize")
 	default:
 		t.Fatal("bytesChan should have one entry")
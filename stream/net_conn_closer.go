@@ -2,27 +2,54 @@ package stream
 
 import (
 	"errors"
+	"fmt"
 	"net"
 	"time"
+
+	"github.com/net-agent/flex/v3/packet"
 )
 
 var (
 	ErrWriterIsClosed      = errors.New("writer is closed")
 	ErrReaderIsClosed      = errors.New("reader is closed")
 	ErrWaitCloseAckTimeout = errors.New("wait close ack timeout")
+	ErrStreamReset         = errors.New("stream reset")
 )
 
+// ResetError 是 stream 被 Reset 后 Read、Write 返回的错误，errors.Is(err, ErrStreamReset) 成立
+type ResetError struct {
+	Code   packet.ErrorCode
+	Remote bool // 由对端发起
+}
+
+func (e *ResetError) Error() string {
+	if e.Remote {
+		return fmt.Sprintf("stream reset by peer: %v", e.Code)
+	}
+	return fmt.Sprintf("stream reset: %v", e.Code)
+}
+
+func (e *ResetError) Unwrap() error { return ErrStreamReset }
+
+// Close 完整关闭 stream：关闭两个方向并通知对端，等待对端确认。
+// 对端此后的 Read 返回 io.EOF，Write 返回 ErrWriterIsClosed。
 func (s *Stream) Close() error {
+	// Second call to Close: return net.ErrClosed per net.Conn contract (P3 fix)
+	if !s.closeCalled.CompareAndSwap(false, true) {
+		return net.ErrClosed
+	}
+
 	// 1. Ensure absolute resource cleanup locally
 	defer s.CloseRead()
+	defer s.markWriteClosed()
 
 	// 2. Mark Write as closed locally
-	if err := s.CloseWrite(); err != nil {
-		// Second call to Close: return net.ErrClosed per net.Conn contract (P3 fix)
-		if errors.Is(err, ErrWriterIsClosed) {
-			return net.ErrClosed
+	if !s.shutdownWrite() {
+		// 写方向已关闭：被对端关闭或 Reset 时无需通知；
+		// CloseWrite 之后对端也已结束发送时两个方向都已完成
+		if !s.finSent.Load() || s.resetError() != nil || s.isReadClosed() {
+			return nil
 		}
-		return err // defer will run CloseRead
 	}
 
 	// 3. Notify Remote
@@ -39,7 +66,47 @@ func (s *Stream) Close() error {
 	}
 }
 
+// Reset 异常终止 stream：丢弃未读数据，通知对端以 code 终止，不等待对端确认。
+// 之后两端的 Read、Write 都返回 *ResetError。code 为 CodeNone 时使用 CodeCanceled。
+func (s *Stream) Reset(code packet.ErrorCode) error {
+	if !s.closeCalled.CompareAndSwap(false, true) {
+		return net.ErrClosed
+	}
+	if code == packet.CodeNone {
+		code = packet.CodeCanceled
+	}
+	s.resetErr.CompareAndSwap(nil, &ResetError{Code: code})
+	defer s.CloseRead()
+
+	s.closeWriteLocal()
+	return s.sender.SendReset(code)
+}
+
+// handleReset 处理对端的 Reset，不回复 closeAck（发起方不等待确认）
+func (s *Stream) handleReset(code packet.ErrorCode) {
+	s.resetErr.CompareAndSwap(nil, &ResetError{Code: code, Remote: true})
+	s.CloseRead()
+	s.closeWriteLocal()
+}
+
+// CloseLocal 关闭本地的读写两个方向，不通知对端。用于底层连接已不可用时回收 stream
+func (s *Stream) CloseLocal() {
+	s.closeCalled.Store(true)
+	s.CloseRead()
+	s.closeWriteLocal()
+}
+
+// resetError 返回 Reset 产生的错误，未被 Reset 时返回 nil
+func (s *Stream) resetError() error {
+	if err := s.resetErr.Load(); err != nil {
+		return err
+	}
+	return nil
+}
+
 func (s *Stream) CloseRead() error {
+	// 先唤醒等待接收队列的投递，它持有 rchanMu 的读锁
+	s.stopRecv()
 	s.rchanMu.Lock()
 	if s.readClosed {
 		s.rchanMu.Unlock()
@@ -49,19 +116,51 @@ func (s *Stream) CloseRead() error {
 	s.readClosed = true
 	close(s.recvQueue)
 	s.rchanMu.Unlock()
+	s.stopAck()
 	s.markReadClosed()
 
 	return nil
 }
 
-// CloseWrite sets the write state to closed and signals blocked Write operations
-// to wake up immediately via closeCh.
+// stopRecv 让等待接收队列的投递放弃等待，之后的数据不再交给应用
+func (s *Stream) stopRecv() {
+	s.recvStopOnce.Do(func() { close(s.recvStop) })
+}
+
+// CloseWrite 关闭写方向并通知对端（类似 TCP 的 FIN）：对端读完已发送的数据后
+// Read 返回 io.EOF，本端仍可继续读取对端的数据。对端确认且对端也关闭写方向后
+// stream 才完整结束。阻塞中的 Write 会立即返回 ErrWriterIsClosed。
 func (s *Stream) CloseWrite() error {
+	if !s.shutdownWrite() {
+		return ErrWriterIsClosed
+	}
+	s.finSent.Store(true)
+	if err := s.sender.SendCloseWrite(); err != nil {
+		// 无法通知对端，不再等待确认
+		s.markWriteClosed()
+		return err
+	}
+	return nil
+}
+
+// closeWriteLocal 关闭写方向但不通知对端
+func (s *Stream) closeWriteLocal() error {
+	if !s.shutdownWrite() {
+		return ErrWriterIsClosed
+	}
+	s.markWriteClosed()
+	return nil
+}
+
+// shutdownWrite sets the write state to closed and signals blocked Write
+// operations to wake up immediately via closeCh. It returns false if the
+// write side was already closed.
+func (s *Stream) shutdownWrite() bool {
 	s.writeMu.Lock()
 
 	if s.writeClosed {
 		s.writeMu.Unlock()
-		return ErrWriterIsClosed
+		return false
 	}
 
 	s.writeClosed = true
@@ -76,7 +175,11 @@ func (s *Stream) CloseWrite() error {
 
 	// Best effort: also interrupt an in-flight blocking send.
 	s.sender.InterruptWrite()
-	s.markWriteClosed()
+	return true
+}
 
-	return nil
+func (s *Stream) isReadClosed() bool {
+	s.rchanMu.RLock()
+	defer s.rchanMu.RUnlock()
+	return s.readClosed
 }
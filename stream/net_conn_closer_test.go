@@ -137,8 +137,7 @@ func TestConcurrentCloseExactlyOneSucceeds(t *testing.T) {
 		}
 	}
 
-	// CloseWrite 有互斥保护，只有第一个能成功走完整流程
-	// 其他的会在 CloseWrite 处返回 ErrWriterIsClosed
+	// 只有第一个能成功走完整流程，其他的返回 net.ErrClosed
 	assert.Equal(t, 1, nilCount, "exactly one Close should succeed")
 	assert.Equal(t, goroutines-1, errCount, "others should return error")
 }
@@ -218,3 +217,94 @@ func TestBidirectionalSimultaneousClose(t *testing.T) {
 	}
 	assert.GreaterOrEqual(t, nilCount, 1, "at least one side should close gracefully")
 }
+
+func TestReset(t *testing.T) {
+	s1, s2 := Pipe()
+	code := packet.CodeApplication + 7
+
+	_, err := s1.Write([]byte("unread"))
+	assert.Nil(t, err)
+	time.Sleep(testShortTimeout)
+
+	assert.Nil(t, s1.Reset(code))
+	assert.Equal(t, net.ErrClosed, s1.Reset(code))
+
+	// 本端：Read、Write 返回本地 ResetError
+	_, err = s1.Write([]byte("x"))
+	var re *ResetError
+	if assert.ErrorAs(t, err, &re) {
+		assert.Equal(t, code, re.Code)
+		assert.False(t, re.Remote)
+	}
+	_, err = s1.Read(make([]byte, 8))
+	assert.ErrorIs(t, err, ErrStreamReset)
+
+	// 对端：未读数据被丢弃，Read 直接返回对端 ResetError
+	assert.Eventually(t, func() bool { return s2.resetError() != nil }, time.Second, testShortTimeout)
+	_, err = s2.Read(make([]byte, 8))
+	if assert.ErrorAs(t, err, &re) {
+		assert.Equal(t, code, re.Code)
+		assert.True(t, re.Remote)
+		assert.Equal(t, "stream reset by peer: application error 7", re.Error())
+	}
+	_, err = s2.Write([]byte("x"))
+	assert.ErrorIs(t, err, ErrStreamReset)
+
+	// 正常关闭仍然是 EOF
+	s3, s4 := Pipe()
+	go s3.Close()
+	_, err = s4.Read(make([]byte, 8))
+	assert.Equal(t, io.EOF, err)
+
+	// CodeNone 按 CodeCanceled 处理
+	s5, _ := Pipe()
+	s5.Reset(packet.CodeNone)
+	_, err = s5.Read(make([]byte, 8))
+	if assert.ErrorAs(t, err, &re) {
+		assert.Equal(t, packet.CodeCanceled, re.Code)
+	}
+}
+
+func TestHalfClose(t *testing.T) {
+	s1, s2 := Pipe()
+
+	_, err := s1.Write([]byte("request"))
+	assert.Nil(t, err)
+	assert.Nil(t, s1.CloseWrite())
+	assert.Equal(t, ErrWriterIsClosed, s1.CloseWrite())
+	_, err = s1.Write([]byte("x"))
+	assert.Equal(t, ErrWriterIsClosed, err)
+
+	// 对端先读完数据再得到 EOF，写方向不受影响
+	req, err := io.ReadAll(s2)
+	assert.Nil(t, err)
+	assert.Equal(t, "request", string(req))
+	_, err = s2.Write([]byte("response"))
+	assert.Nil(t, err)
+	assert.Nil(t, s2.CloseWrite())
+
+	resp, err := io.ReadAll(s1)
+	assert.Nil(t, err)
+	assert.Equal(t, "response", string(resp))
+
+	// 两个方向都结束后 stream 完整关闭
+	assert.Eventually(t, func() bool {
+		return isWriteClosed(s1) && isWriteClosed(s2) && isReadClosed(s1) && isReadClosed(s2)
+	}, time.Second, testShortTimeout)
+	assert.Nil(t, s1.Close())
+	assert.Nil(t, s2.Close())
+}
+
+func TestHalfCloseThenClose(t *testing.T) {
+	s1, s2 := Pipe()
+
+	assert.Nil(t, s1.CloseWrite())
+	_, err := s2.Read(make([]byte, 8))
+	assert.Equal(t, io.EOF, err)
+
+	// 对端仍在发送时 Close 会通知对端停止写入
+	assert.Nil(t, s1.Close())
+	time.Sleep(testShortTimeout)
+	_, err = s2.Write([]byte("x"))
+	assert.Equal(t, ErrWriterIsClosed, err)
+}
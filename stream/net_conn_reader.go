@@ -3,19 +3,39 @@ package stream
 import (
 	"io"
 	"sync/atomic"
+
+	"github.com/net-agent/flex/v3/packet"
 )
 
+// recvChunk 是接收队列中的一段数据
+type recvChunk struct {
+	data   []byte
+	pooled bool // data 来自 packet 的 payload 池，读完后由 Read 归还
+}
+
 func (s *Stream) Read(dist []byte) (int, error) {
 	s.readMu.Lock()
 	defer s.readMu.Unlock()
 
+	// Reset 后丢弃未读数据
+	if err := s.resetError(); err != nil {
+		s.releaseReadBuf()
+		return 0, err
+	}
+
 	for len(s.readBuf) == 0 {
 		select {
-		case buf, ok := <-s.recvQueue:
+		case chunk, ok := <-s.recvQueue:
 			if !ok {
+				if err := s.resetError(); err != nil {
+					return 0, err
+				}
 				return 0, io.EOF
 			}
-			s.readBuf = buf
+			s.readBuf = chunk.data
+			if chunk.pooled {
+				s.readPooled = chunk.data
+			}
 
 		case <-s.readDeadline.Done():
 			// Could be a real timeout OR a deadline reset (Set closed old channel).
@@ -33,17 +53,20 @@ func (s *Stream) Read(dist []byte) (int, error) {
 	n := copy(dist, s.readBuf)
 	atomic.AddInt64(&s.state.BytesRead, int64(n))
 	s.readBuf = s.readBuf[n:]
+	if len(s.readBuf) == 0 {
+		s.releaseReadBuf()
+	}
 	if n > 0 {
-		// 发送ack不能阻塞Read，所以放在协程里
-		// 确保发送ack的操作不会因为网络问题而阻塞Read，从而导致连接无法正常关闭或数据无法及时读取
-		go func() {
-			err := s.sender.SendDataAck(uint16(n))
-			if err != nil {
-				s.logger.Warn("SendDataAck failed", "error", err.Error())
-				return
-			}
-			atomic.AddInt64(&s.state.SentAckTotal, int64(n))
-		}()
+		s.consumeAck(n)
 	}
 	return n, nil
 }
+
+// releaseReadBuf 丢弃 readBuf，数据来自 payload 池时归还。调用方需持有 readMu
+func (s *Stream) releaseReadBuf() {
+	s.readBuf = nil
+	if s.readPooled != nil {
+		packet.PutPayload(s.readPooled)
+		s.readPooled = nil
+	}
+}
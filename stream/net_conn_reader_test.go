@@ -114,8 +114,8 @@ func TestParallelIndependentStreamsRead(t *testing.T) {
 	streams := make([]*Stream, goroutines)
 	for i := range streams {
 		streams[i] = New(&mockWriter{}, 0)
-		streams[i].recvQueue <- []byte("data1")
-		streams[i].recvQueue <- []byte("data2")
+		streams[i].recvQueue <- recvChunk{data: []byte("data1")}
+		streams[i].recvQueue <- recvChunk{data: []byte("data2")}
 	}
 
 	done := make(chan struct{}, goroutines)
@@ -135,9 +135,9 @@ func TestParallelIndependentStreamsRead(t *testing.T) {
 func TestReadDrainsBufferedDataAfterCloseRead(t *testing.T) {
 	s := New(&mockWriter{}, 0)
 
-	s.recvQueue <- []byte("aaa")
-	s.recvQueue <- []byte("bbb")
-	s.recvQueue <- []byte("ccc")
+	s.recvQueue <- recvChunk{data: []byte("aaa")}
+	s.recvQueue <- recvChunk{data: []byte("bbb")}
+	s.recvQueue <- recvChunk{data: []byte("ccc")}
 
 	err := s.CloseRead()
 	assert.Nil(t, err)
@@ -155,6 +155,35 @@ func TestReadDrainsBufferedDataAfterCloseRead(t *testing.T) {
 	assert.Equal(t, 9, total, "should drain all buffered data: aaa+bbb+ccc = 9 bytes")
 }
 
+func TestReadReleasesPooledPayload(t *testing.T) {
+	s := New(&mockWriter{}, 0)
+
+	payload := packet.GetPayload(10)
+	copy(payload, "0123456789")
+	s.recvQueue <- recvChunk{data: payload, pooled: true}
+	s.recvQueue <- recvChunk{data: []byte("abc")}
+
+	// 部分读取时保留 payload，读完后归还
+	buf := make([]byte, 4)
+	n, err := s.Read(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "0123", string(buf[:n]))
+	assert.NotNil(t, s.readPooled)
+
+	buf = make([]byte, 64)
+	n, err = s.Read(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "456789", string(buf[:n]))
+	assert.Nil(t, s.readPooled)
+	assert.Nil(t, s.readBuf)
+
+	// 应用自己的切片不会被记录和归还
+	n, err = s.Read(buf[:1])
+	assert.Nil(t, err)
+	assert.Equal(t, "a", string(buf[:n]))
+	assert.Nil(t, s.readPooled)
+}
+
 func TestReadZeroLengthBuffer(t *testing.T) {
 	s := New(&mockWriter{}, 0)
 	s.readBuf = []byte("hello")
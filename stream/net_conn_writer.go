@@ -116,6 +116,9 @@ func (s *Stream) reserveWriteChunk(bufLen int) (int, error) {
 }
 
 func (s *Stream) checkWriteInterruption() error {
+	if err := s.resetError(); err != nil {
+		return err
+	}
 	if s.isWriteClosed() {
 		return ErrWriterIsClosed
 	}
@@ -10,6 +10,7 @@ import (
 
 var (
 	ErrSendDataOversize = errors.New("send data oversize")
+	errNoWriter         = errors.New("sender has no writer")
 )
 
 type sender struct {
@@ -82,13 +83,57 @@ func (s *sender) SendDataAck(n uint16) error {
 func (s *sender) SendClose() error {
 	s.dataMu.Lock()
 	defer s.dataMu.Unlock()
+	if err := s.closeBuf.SetPayload(nil); err != nil {
+		return err
+	}
+	atomic.AddInt32(s.counter, 1)
+	return s.WriteBuffer(s.closeBuf)
+}
+
+// SendCloseWrite 发送只关闭写方向的 close 包
+func (s *sender) SendCloseWrite() error {
+	if s.Writer == nil {
+		return errNoWriter
+	}
+	s.dataMu.Lock()
+	defer s.dataMu.Unlock()
+	if err := s.closeBuf.SetPayload(packet.EncodeCloseWrite()); err != nil {
+		return err
+	}
+	atomic.AddInt32(s.counter, 1)
+	return s.WriteBuffer(s.closeBuf)
+}
+
+// SendReset 发送携带错误码的 close 包，每个 stream 最多调用一次
+func (s *sender) SendReset(code packet.ErrorCode) error {
+	if s.Writer == nil {
+		return errNoWriter
+	}
+	s.dataMu.Lock()
+	defer s.dataMu.Unlock()
+	if err := s.closeBuf.SetPayload(packet.EncodeCloseCode(code)); err != nil {
+		return err
+	}
 	atomic.AddInt32(s.counter, 1)
 	return s.WriteBuffer(s.closeBuf)
 }
 
 func (s *sender) SendCloseAck() error {
+	return s.sendCloseAck(nil)
+}
+
+// SendCloseWriteAck 确认对端的 CloseWrite。payload 与对端的 close 包相同，
+// switcher 据此区分只结束了一个方向的确认
+func (s *sender) SendCloseWriteAck() error {
+	return s.sendCloseAck(packet.EncodeCloseWrite())
+}
+
+func (s *sender) sendCloseAck(payload []byte) error {
 	s.ackMu.Lock()
 	defer s.ackMu.Unlock()
+	if err := s.closeAckBuf.SetPayload(payload); err != nil {
+		return err
+	}
 	atomic.AddInt32(s.counter, 1)
 	return s.WriteBuffer(s.closeAckBuf)
 }
@@ -25,6 +25,17 @@ type State struct {
 
 	BytesRead    int64
 	BytesWritten int64
+
+	// ReadCount 是读到数据的 Read 次数，AckCount 是发送的数据确认包数。
+	// 两者之差是合并确认节省的控制包
+	ReadCount int32
+	AckCount  int32
+
+	DeliverBacklog int32         // 等待投递的包数，见 Stream.Deliver
+	DeliverBlocked time.Duration // 接收队列满时投递等待应用读取的总时间
+
+	Window int32         // 当前授予对端的接收窗口
+	RTT    time.Duration // 自动调整窗口时估计的最小 RTT，未开启时为 0
 }
 
 func (st *State) String() string {
@@ -24,9 +24,8 @@ const (
 
 var (
 	// Flow Control Parameters (Global Defaults)
-	DefaultWindowSize        int32         = 2 * MB
-	DefaultCloseAckTimeout   time.Duration = time.Second * 2
-	DefaultAppendDataTimeout time.Duration = time.Second * 2
+	DefaultWindowSize      int32         = 2 * MB
+	DefaultCloseAckTimeout time.Duration = time.Second * 2
 )
 
 type Stream struct {
@@ -38,11 +37,23 @@ type Stream struct {
 	// for reader
 	rchanMu      sync.RWMutex // 保护 recvQueue 的生命周期（RLock=写入chan, Lock=close chan）
 	readClosed   bool
-	recvQueue    chan []byte
+	recvQueue    chan recvChunk
+	recvStop     chan struct{} // 读方向关闭或收到 Reset 时关闭，唤醒等待 recvQueue 的投递
+	recvStopOnce sync.Once
+	tuner        *windowTuner // 为 nil 时窗口固定
+	ack          ackBatcher
 	readMu       sync.Mutex // 序列化 Read 调用，保护 readBuf（net.Conn 契约）
 	readBuf      []byte
+	readPooled   []byte // readBuf 所在的池化 payload，读完后归还
 	readDeadline *DeadlineGuard
 
+	// for delivery
+	inboxMu       sync.Mutex
+	inbox         []*packet.Buffer // 等待 deliverLoop 处理的包
+	inboxBytes    int64            // inbox 中数据包的字节数
+	inboxOverflow bool             // 对端超出窗口发送，stream 已被 Reset
+	delivering    bool
+
 	// for writer
 	writeMu       sync.Mutex // 序列化 write/CloseWrite，保护 writeClosed 和 closeCh
 	writeClosed   bool
@@ -53,9 +64,11 @@ type Stream struct {
 	closeCh         chan struct{} // closed when CloseWrite is called, to interrupt blocked Write
 	closeAckCh      chan struct{}
 	closeAckTimeout time.Duration
-
-	// variables
-	recvPushTimeout time.Duration
+	resetErr        atomic.Pointer[ResetError]
+	closeCalled     atomic.Bool // Close 或 Reset 已调用
+	finSent         atomic.Bool // CloseWrite 已通知对端
+	finAcked        atomic.Bool
+	metadata        map[string]string
 
 	// lifecycle hook: called once when both read+write are closed.
 	closeMask  atomic.Uint32
@@ -88,6 +101,15 @@ func (s *Stream) GetState() *State {
 	st.SentAckTotal = atomic.LoadInt64(&s.state.SentAckTotal)
 	st.BytesRead = atomic.LoadInt64(&s.state.BytesRead)
 	st.BytesWritten = atomic.LoadInt64(&s.state.BytesWritten)
+	st.DeliverBacklog = atomic.LoadInt32(&s.state.DeliverBacklog)
+	st.DeliverBlocked = time.Duration(atomic.LoadInt64((*int64)(&s.state.DeliverBlocked)))
+	st.ReadCount = atomic.LoadInt32(&s.state.ReadCount)
+	st.AckCount = atomic.LoadInt32(&s.state.AckCount)
+	st.Window = s.state.Window
+	if s.tuner != nil {
+		window, rtt := s.tuner.stats()
+		st.Window, st.RTT = int32(window), rtt
+	}
 
 	return st
 }
@@ -103,31 +125,36 @@ func New(pwriter packet.Writer, initialWindowSize int32) *Stream {
 		initialWindowSize = DefaultWindowSize
 	}
 
-	// Calculate chan cap based on window size
-	chanCap := int(initialWindowSize) / DefaultSplitSize * 4
-	if chanCap < 16 {
-		chanCap = 16
-	}
-
 	state := &State{
 		Index:   atomic.AddInt32(&streamIndex, 1),
 		Created: time.Now(),
+		Window:  initialWindowSize,
 	}
 	return &Stream{
+		ack:             ackBatcher{threshold: int64(DefaultAckThreshold), delay: DefaultAckDelay},
 		state:           state,
 		sender:          newSender(pwriter, &state.SentBufferCount),
-		recvQueue:       make(chan []byte, chanCap),
+		recvQueue:       make(chan recvChunk, recvQueueCap(initialWindowSize)),
+		recvStop:        make(chan struct{}),
 		window:          NewWindowGuard(initialWindowSize, 16),
 		readDeadline:    &DeadlineGuard{},
 		writeDeadline:   &DeadlineGuard{},
 		closeCh:         make(chan struct{}),
 		closeAckCh:      make(chan struct{}, 1),
 		closeAckTimeout: DefaultCloseAckTimeout,
-		recvPushTimeout: DefaultAppendDataTimeout,
 		logger:          slog.Default(),
 	}
 }
 
+// recvQueueCap calculates chan cap based on window size
+func recvQueueCap(windowSize int32) int {
+	chanCap := int(windowSize) / DefaultSplitSize * 4
+	if chanCap < 16 {
+		chanCap = 16
+	}
+	return chanCap
+}
+
 func NewDialStream(w packet.Writer,
 	localDomain string, localIP, localPort uint16,
 	remoteDomain string, remoteIP, remotePort uint16,
@@ -172,6 +199,34 @@ func (s *Stream) SetLogger(l *slog.Logger) {
 	}
 }
 
+// SetMetadata 设置拨号方在 open 请求中附带的元数据，需在使用 stream 前调用
+func (s *Stream) SetMetadata(md map[string]string) { s.metadata = md }
+
+// Metadata 返回拨号方附带的元数据，没有时为 nil，调用方不应修改
+func (s *Stream) Metadata() map[string]string { return s.metadata }
+
+// SetCloseAckTimeout 设置 Close 等待对端 AckCloseStream 的时间，需在使用 stream 前调用
+func (s *Stream) SetCloseAckTimeout(d time.Duration) {
+	if d > 0 {
+		s.closeAckTimeout = d
+	}
+}
+
+// SetWindowTuning 开启接收窗口的自动调整，窗口在 [minSize, maxSize] 之间变化。
+// 对端需要支持打开后调整窗口（open 时声明了 MaxWindowSize），需在使用 stream 前调用
+func (s *Stream) SetWindowTuning(minSize, maxSize int32) {
+	if maxSize <= 0 {
+		return
+	}
+	s.tuner = newWindowTuner(s.state.Window, minSize, maxSize)
+	s.recvQueue = make(chan recvChunk, recvQueueCap(int32(s.tuner.max)))
+}
+
+// SetPeerMaxWindow 设置对端声明的最大接收窗口，允许对端在打开后把发送窗口调大到 size
+func (s *Stream) SetPeerMaxWindow(size int32) {
+	s.window.SetMax(size)
+}
+
 func directionStr(d Direction) string {
 	if d == DirectionOutbound {
 		return "local-remote"
@@ -73,9 +73,9 @@ func (w *WindowGuard) Event() <-chan struct{} {
 	return w.event
 }
 
-// SetSize directly sets the window size. For testing only.
-func (w *WindowGuard) SetSize(n int32) {
-	atomic.StoreInt32(&w.size, n)
+// SetMax raises the upper bound of the window to n, so that the remote
+// peer can grow the window after open by granting extra capacity.
+func (w *WindowGuard) SetMax(n int32) {
 	for {
 		curMax := atomic.LoadInt32(&w.maxWindow)
 		if n <= curMax {
@@ -87,6 +87,12 @@ func (w *WindowGuard) SetSize(n int32) {
 	}
 }
 
+// SetSize directly sets the window size. For testing only.
+func (w *WindowGuard) SetSize(n int32) {
+	atomic.StoreInt32(&w.size, n)
+	w.SetMax(n)
+}
+
 // Signal manually sends an event. For testing only.
 func (w *WindowGuard) Signal() {
 	select {
@@ -0,0 +1,156 @@
+package stream
+
+import (
+	"sync"
+	"time"
+)
+
+var (
+	// 自动调整窗口的默认范围
+	DefaultMinWindowSize int32 = 64 * KB
+	DefaultMaxWindowSize int32 = 16 * MB
+)
+
+const (
+	maxGrantRecords  = 64
+	minRoundInterval = 50 * time.Millisecond
+)
+
+// windowTuner 在接收端自动调整授予对端的窗口。
+//
+// 接收端通过 AckPushStreamData 授予对端发送额度：授予的额度多于应用读取的字节数
+// 时窗口变大，少于时窗口变小，因此调整窗口不需要新的命令。
+//
+// RTT 由授信与数据到达的时间差估计：超出某次授信之前额度的数据，只能在对端收到
+// 这次授信之后发出，因此只有对端用完额度时才有样本。每收到一个窗口的数据或者
+// 经过 8 个 RTT 为一轮，按本轮的交付速率和 RTT 调整窗口：
+//   - 应用读取不及时，数据在本地堆积：窗口减半
+//   - 对端用完了额度且 RTT 没有明显增大：窗口小于 BDP，翻倍
+//   - 对端没有用完额度：收缩到 BDP 的两倍，每轮最多减半
+type windowTuner struct {
+	mu       sync.Mutex
+	min, max int64
+	window   int64 // 当前授予对端的窗口
+	granted  int64 // 累计授信：初始窗口与已发送的 ACK
+	received int64 // 累计收到的字节
+	credit   int64 // 尚未发出的授信，负数表示收缩窗口时需要扣留的字节
+	grants   []grantRecord
+	minRTT   time.Duration
+
+	roundStart    time.Time
+	roundReceived int64
+	roundRTT      time.Duration // 本轮最小的 RTT 样本
+	roundUnread   int64         // 本轮数据到达时最少的未读字节数
+	limited       bool          // 本轮中对端曾经用完额度
+}
+
+type grantRecord struct {
+	limit int64 // 授信之前的累计额度
+	at    time.Time
+}
+
+func newWindowTuner(window, min, max int32) *windowTuner {
+	if min > window {
+		min = window
+	}
+	if max < window {
+		max = window
+	}
+	return &windowTuner{
+		min:     int64(min),
+		max:     int64(max),
+		window:  int64(window),
+		granted: int64(window),
+	}
+}
+
+// onData 记录收到的 n 字节数据，unread 为已收到但应用还没有读取的字节数
+func (t *windowTuner) onData(n int, unread int64, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.roundStart.IsZero() {
+		t.roundStart = now
+		t.roundReceived = t.received
+		t.roundUnread = unread
+	}
+	t.received += int64(n)
+	// 应用能及时读取时，未读数据会周期性地清空
+	t.roundUnread = min(t.roundUnread, unread-int64(n))
+
+	i := 0
+	for i < len(t.grants) && t.grants[i].limit < t.received {
+		i++
+	}
+	if i > 0 {
+		rtt := now.Sub(t.grants[i-1].at)
+		if t.minRTT == 0 || rtt < t.minRTT {
+			t.minRTT = rtt
+		}
+		if t.roundRTT == 0 || rtt < t.roundRTT {
+			t.roundRTT = rtt
+		}
+		t.grants = append(t.grants[:0], t.grants[i:]...)
+	}
+
+	if t.granted-t.received < t.window/8 {
+		t.limited = true
+	}
+	interval := max(8*t.minRTT, minRoundInterval)
+	if t.received-t.roundReceived >= t.window || now.Sub(t.roundStart) >= interval {
+		t.endRound(now)
+	}
+}
+
+func (t *windowTuner) endRound(now time.Time) {
+	next := t.window
+	elapsed := now.Sub(t.roundStart)
+	switch {
+	case t.roundUnread > t.window/2:
+		next = t.window / 2
+	case t.minRTT <= 0:
+	case t.limited:
+		if t.roundRTT < t.minRTT*3/2 {
+			next = t.window * 2
+		}
+	case elapsed > 0:
+		bdp := (t.received - t.roundReceived) * int64(t.minRTT) / int64(elapsed)
+		if 2*bdp < next {
+			next = max(2*bdp, t.window/2)
+		}
+	}
+	next = min(max(next, t.min), t.max)
+
+	t.credit += next - t.window
+	t.window = next
+	t.roundStart = now
+	t.roundReceived = t.received
+	t.roundRTT = 0
+	t.roundUnread = t.window
+	t.limited = false
+}
+
+// onRead 记录应用读取的 n 字节，返回需要授予对端的额度
+func (t *windowTuner) onRead(n int, now time.Time) int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.credit += int64(n)
+	if t.credit <= 0 {
+		return 0
+	}
+	c := t.credit
+	// 记录已满时不再记录，较早的记录被数据消耗后才有 RTT 样本
+	if len(t.grants) < maxGrantRecords {
+		t.grants = append(t.grants, grantRecord{limit: t.granted, at: now})
+	}
+	t.granted += c
+	t.credit = 0
+	return c
+}
+
+func (t *windowTuner) stats() (window int64, rtt time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.window, t.minRTT
+}
@@ -0,0 +1,139 @@
+package stream
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// tunerSim 模拟对端每个 rtt 发送 send(额度) 字节，应用每次读取 read(未读) 字节
+type tunerSim struct {
+	*windowTuner
+	now    time.Time
+	unread int64
+}
+
+func newTunerSim(window, min, max int32) *tunerSim {
+	return &tunerSim{windowTuner: newWindowTuner(window, min, max), now: time.Unix(0, 0)}
+}
+
+func (sim *tunerSim) run(steps int, rtt func(i int) time.Duration, send func(avail int64) int64, read func(unread int64) int64) {
+	for i := 0; i < steps; i++ {
+		sim.now = sim.now.Add(rtt(i))
+		n := send(sim.granted - sim.received)
+		for n > 0 {
+			chunk := min(n, int64(DefaultSplitSize))
+			sim.unread += chunk
+			sim.onData(int(chunk), sim.unread, sim.now)
+			n -= chunk
+		}
+		r := read(sim.unread)
+		sim.unread -= r
+		sim.onRead(int(r), sim.now)
+	}
+}
+
+func fixedRTT(d time.Duration) func(int) time.Duration { return func(int) time.Duration { return d } }
+func sendAll(avail int64) int64                        { return avail }
+func readAll(unread int64) int64                       { return unread }
+
+func TestWindowTuner_Grow(t *testing.T) {
+	tu := newTunerSim(64*KB, 16*KB, MB)
+	tu.run(100, fixedRTT(10*time.Millisecond), sendAll, readAll)
+
+	window, rtt := tu.stats()
+	assert.Equal(t, int64(MB), window, "window-limited sender grows the window up to max")
+	assert.Equal(t, 10*time.Millisecond, rtt)
+}
+
+func TestWindowTuner_HoldOnRTTIncrease(t *testing.T) {
+	tu := newTunerSim(64*KB, 16*KB, MB)
+	// RTT 随窗口增大：数据在链路上排队，窗口不再增长
+	tu.run(100, func(i int) time.Duration {
+		return 10*time.Millisecond + time.Duration(i)*10*time.Millisecond
+	}, sendAll, readAll)
+
+	window, _ := tu.stats()
+	assert.Less(t, window, int64(MB))
+}
+
+func TestWindowTuner_ShrinkSlowReader(t *testing.T) {
+	tu := newTunerSim(MB, 64*KB, 4*MB)
+	tu.run(2000, fixedRTT(10*time.Millisecond), sendAll, func(unread int64) int64 {
+		return min(unread, 4*KB)
+	})
+
+	window, _ := tu.stats()
+	assert.Equal(t, int64(64*KB), window, "slow reader shrinks the window down to min")
+}
+
+func TestWindowTuner_ShrinkAppLimited(t *testing.T) {
+	tu := newTunerSim(MB, 16*KB, 4*MB)
+	tu.run(100, fixedRTT(10*time.Millisecond), sendAll, readAll)
+	window, _ := tu.stats()
+	assert.Equal(t, int64(4*MB), window)
+
+	// 对端每个 RTT 只发送 16KB，BDP 远小于窗口
+	tu.run(500, fixedRTT(10*time.Millisecond), func(avail int64) int64 {
+		return min(avail, 16*KB)
+	}, readAll)
+
+	window, _ = tu.stats()
+	assert.Less(t, window, int64(MB))
+	assert.GreaterOrEqual(t, window, int64(32*KB), "window stays above twice the BDP")
+}
+
+func TestWindowTuner_Credit(t *testing.T) {
+	tu := newWindowTuner(64*KB, 64*KB, MB)
+	now := time.Unix(0, 0)
+	assert.Equal(t, int64(100), tu.onRead(100, now))
+
+	// 收缩窗口时扣留授信，直到补足差额
+	tu.credit = -150
+	assert.Equal(t, int64(0), tu.onRead(100, now))
+	assert.Equal(t, int64(50), tu.onRead(100, now))
+}
+
+func TestWindowTuning(t *testing.T) {
+	s1, s2 := Pipe()
+	s1.SetPeerMaxWindow(DefaultMaxWindowSize)
+	s2.SetWindowTuning(DefaultMinWindowSize, DefaultMaxWindowSize)
+
+	data := make([]byte, 8*MB)
+	rand.Read(data)
+	go func() {
+		s1.Write(data)
+		s1.CloseWrite()
+	}()
+
+	// 先让对端用完初始窗口，之后应用读得慢，数据在接收端堆积，窗口收缩。
+	// 读完之后窗口可能重新增长，因此记录读取过程中的最小窗口
+	assert.Eventually(t, func() bool {
+		return s2.GetState().RecvDataSize >= int64(DefaultWindowSize)
+	}, 5*time.Second, time.Millisecond)
+	minWindow := s2.GetState().Window
+	got := make([]byte, 0, len(data))
+	buf := make([]byte, 64*KB)
+	for {
+		n, err := s2.Read(buf)
+		got = append(got, buf[:n]...)
+		minWindow = min(minWindow, s2.GetState().Window)
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		time.Sleep(time.Millisecond)
+	}
+	assert.True(t, bytes.Equal(data, got))
+	assert.Less(t, minWindow, DefaultWindowSize, "window is tuned after open")
+
+	st := s2.GetState()
+	assert.GreaterOrEqual(t, st.Window, DefaultMinWindowSize)
+	assert.LessOrEqual(t, st.Window, DefaultMaxWindowSize)
+	assert.Greater(t, st.RTT, time.Duration(0))
+	assert.Equal(t, DefaultWindowSize, s1.GetState().Window, "fixed window without tuning")
+}
@@ -22,11 +22,18 @@ type Context struct {
 	Domain string
 	Mac    string
 	IP     uint16
+	Labels map[string]string // 握手时声明的标签，attach 后只读
 	logger *slog.Logger
 
-	mu       sync.Mutex
-	conn     packet.Conn
-	attached bool
+	mu         sync.Mutex
+	conn       packet.Conn
+	attached   bool
+	ports      []uint16            // 节点通过 directory.advertise 公布的端口
+	halfClosed map[uint64]struct{} // 只结束了一个方向的 stream，以本端为源的 SID 为键
+
+	peer     *peerLink // non-nil for domains learned from a federated switcher
+	upstream *Relay    // non-nil for peers reached through the upstream of a relay
+	parent   *Context  // non-nil for children registered through a relay node
 
 	forwardCh   chan *packet.Buffer
 	forwardDone chan struct{}
@@ -35,9 +42,10 @@ type Context struct {
 	AttachTime time.Time
 	DetachTime time.Time
 
-	pingIndex int32
-	pingBack  sync.Map
-	Stats     ContextStats
+	pingIndex    int32
+	pingBack     sync.Map
+	pendingOpens int32 // 已转发、尚未应答的 open 请求
+	Stats        ContextStats
 }
 
 type ContextStats struct {
@@ -70,6 +78,35 @@ func (ctx *Context) GetID() int {
 	return ctx.id
 }
 
+// IsRemote reports whether the context represents a domain hosted by a
+// federated switcher or reached through a relay's upstream, rather than a
+// locally connected node.
+func (ctx *Context) IsRemote() bool {
+	return ctx.peer != nil || ctx.upstream != nil
+}
+
+// Via returns the domain of the relay node the context is registered through,
+// or an empty string for directly connected nodes.
+func (ctx *Context) Via() string {
+	if ctx.parent == nil {
+		return ""
+	}
+	return ctx.parent.Domain
+}
+
+// Ports returns the ports advertised by the node.
+func (ctx *Context) Ports() []uint16 {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	return ctx.ports
+}
+
+func (ctx *Context) setPorts(ports []uint16) {
+	ctx.mu.Lock()
+	ctx.ports = ports
+	ctx.mu.Unlock()
+}
+
 func (ctx *Context) getConn() packet.Conn {
 	ctx.mu.Lock()
 	defer ctx.mu.Unlock()
@@ -110,21 +147,86 @@ func (ctx *Context) writeBuffer(buf *packet.Buffer) error {
 
 	// Update stats
 	atomic.AddInt64(&ctx.Stats.BytesSent, int64(buf.PayloadSize()+packet.HeaderSz))
+	switch buf.Cmd() {
+	case packet.AckOpenStream:
+		if !packet.DecodeOpenStreamACK(buf.Payload).OK {
+			// 连接被拒绝，撤销发起连接时的计数
+			atomic.AddInt32(&ctx.Stats.StreamCount, -1)
+		}
+	case packet.CmdCloseStream:
+		if packet.DecodeCloseCode(buf.Payload) != packet.CodeNone {
+			// 被 Reset 的一端不回复确认，由 switcher 在转发时撤销它的计数
+			ctx.closeStream(reverseSID(buf.SID()), false)
+		}
+	}
 
 	return c.WriteBuffer(buf)
 }
 
 // recordIncoming updates receive stats for an incoming packet.
+// StreamCount covers both ends of a stream: the caller counts it when opening,
+// the callee when accepting, and each end uncounts it on close or close ack.
+// The end receiving a reset is uncounted by writeBuffer. CloseWrite and its
+// ack end only one direction; the stream is uncounted once both have ended.
 func (ctx *Context) recordIncoming(pbuf *packet.Buffer) {
 	atomic.AddInt64(&ctx.Stats.BytesReceived, int64(pbuf.PayloadSize()+packet.HeaderSz))
-	cmd := pbuf.Cmd()
-	if cmd == packet.CmdOpenStream {
+	switch pbuf.Cmd() {
+	case packet.CmdOpenStream:
 		atomic.AddInt32(&ctx.Stats.StreamCount, 1)
-	} else if cmd == packet.CmdCloseStream {
-		atomic.AddInt32(&ctx.Stats.StreamCount, -1)
+	case packet.AckOpenStream:
+		ctx.donePendingOpen()
+		if packet.DecodeOpenStreamACK(pbuf.Payload).OK {
+			atomic.AddInt32(&ctx.Stats.StreamCount, 1)
+		}
+	case packet.CmdCloseStream, packet.AckCloseStream:
+		ctx.closeStream(pbuf.SID(), packet.IsCloseWrite(pbuf.Payload))
 	}
 }
 
+// closeStream 在 ctx 一端的 stream 结束时撤销计数。halfClose 表示只结束了
+// 一个方向（CloseWrite 或对它的确认），另一个方向也结束后才撤销
+func (ctx *Context) closeStream(sid uint64, halfClose bool) {
+	ctx.mu.Lock()
+	_, half := ctx.halfClosed[sid]
+	if halfClose && !half {
+		if ctx.halfClosed == nil {
+			ctx.halfClosed = make(map[uint64]struct{})
+		}
+		ctx.halfClosed[sid] = struct{}{}
+		ctx.mu.Unlock()
+		return
+	}
+	delete(ctx.halfClosed, sid)
+	ctx.mu.Unlock()
+	atomic.AddInt32(&ctx.Stats.StreamCount, -1)
+}
+
+// reverseSID 返回反方向数据包的 SID，即交换源地址与目标地址
+func reverseSID(sid uint64) uint64 {
+	return sid<<32 | sid>>32
+}
+
+// addPendingOpen 记录转发给 ctx、等待应答的 open 请求
+func (ctx *Context) addPendingOpen() {
+	atomic.AddInt32(&ctx.pendingOpens, 1)
+}
+
+// donePendingOpen 在 ctx 应答 open 请求后撤销记录。不经过路由转发的 open
+// （例如 switcher 自身的服务）没有记录，此时不做处理
+func (ctx *Context) donePendingOpen() {
+	for {
+		n := atomic.LoadInt32(&ctx.pendingOpens)
+		if n <= 0 || atomic.CompareAndSwapInt32(&ctx.pendingOpens, n, n-1) {
+			return
+		}
+	}
+}
+
+// load 返回 ctx 上的 stream 数，包含尚未应答的 open 请求
+func (ctx *Context) load() int32 {
+	return atomic.LoadInt32(&ctx.Stats.StreamCount) + atomic.LoadInt32(&ctx.pendingOpens)
+}
+
 func (ctx *Context) release() {
 	ctx.closeOnce.Do(func() {
 		close(ctx.forwardDone)
@@ -1,14 +1,19 @@
 package switcher
 
 import (
+	"io"
 	"log"
+	"net"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/net-agent/flex/v3/internal/admit"
 	"github.com/net-agent/flex/v3/node"
 	"github.com/net-agent/flex/v3/packet"
+	"github.com/net-agent/flex/v3/stream"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestContextPing(t *testing.T) {
@@ -243,3 +248,84 @@ func TestGetID(t *testing.T) {
 		t.Errorf("expected 42, got %v", ctx.GetID())
 	}
 }
+
+// dialCountedStream 在两个节点间建立 stream，返回两端的 Context 和 stream
+func dialCountedStream(t *testing.T) (ctx1, ctx2 *Context, c1, c2 *stream.Stream) {
+	t.Helper()
+	s, node1, node2 := initTestEnv("test1", "test2")
+	t.Cleanup(func() {
+		node1.Close()
+		node2.Close()
+	})
+	ctx1, _ = s.registry.lookupByDomain("test1")
+	ctx2, _ = s.registry.lookupByDomain("test2")
+
+	l, err := node2.Listen(80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := l.Accept()
+		if err == nil {
+			accepted <- c
+		}
+	}()
+
+	c1, err = node1.DialDomain("test2", 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2 = (<-accepted).(*stream.Stream)
+	assert.Eventually(t, func() bool { return streamCount(ctx1) == 1 && streamCount(ctx2) == 1 }, time.Second, 10*time.Millisecond)
+	return ctx1, ctx2, c1, c2
+}
+
+func streamCount(ctx *Context) int32 { return atomic.LoadInt32(&ctx.Stats.StreamCount) }
+
+// assertStreamsClosed 检查两端的计数归零，并且不会被重复撤销
+func assertStreamsClosed(t *testing.T, ctxs ...*Context) {
+	t.Helper()
+	assert.Eventually(t, func() bool {
+		for _, ctx := range ctxs {
+			if streamCount(ctx) != 0 {
+				return false
+			}
+		}
+		return true
+	}, time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	for _, ctx := range ctxs {
+		assert.Equal(t, int32(0), streamCount(ctx), ctx.Domain)
+	}
+}
+
+func TestStreamCountReset(t *testing.T) {
+	ctx1, ctx2, c1, _ := dialCountedStream(t)
+
+	// 被 Reset 的一端不发送任何 close 包，计数也要归零
+	assert.Nil(t, c1.Reset(packet.CodeCanceled))
+	assertStreamsClosed(t, ctx1, ctx2)
+}
+
+func TestStreamCountCloseWrite(t *testing.T) {
+	// 一端 CloseWrite，另一端读到 EOF 后 Close
+	ctx1, ctx2, c1, c2 := dialCountedStream(t)
+	assert.Nil(t, c1.CloseWrite())
+	_, err := io.ReadAll(c2)
+	assert.Nil(t, err)
+	assert.Nil(t, c2.Close())
+	c1.Close()
+	assertStreamsClosed(t, ctx1, ctx2)
+
+	// 两端都 CloseWrite，最后的 Close 不再发送 close 包
+	ctx1, ctx2, c1, c2 = dialCountedStream(t)
+	assert.Nil(t, c1.CloseWrite())
+	assert.Nil(t, c2.CloseWrite())
+	io.ReadAll(c1)
+	io.ReadAll(c2)
+	c1.Close()
+	c2.Close()
+	assertStreamsClosed(t, ctx1, ctx2)
+}
@@ -0,0 +1,237 @@
+package switcher
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+
+	"github.com/net-agent/flex/v3/internal/admit"
+	"github.com/net-agent/flex/v3/internal/selector"
+	"github.com/net-agent/flex/v3/packet"
+)
+
+// presenceSub 是一个节点的上下线事件订阅，all 为 true 时订阅所有节点
+type presenceSub struct {
+	all     bool
+	domains map[string]struct{}
+}
+
+func (sub *presenceSub) matches(domain string) bool {
+	if sub.all {
+		return true
+	}
+	_, ok := sub.domains[domain]
+	return ok
+}
+
+// presenceHub 记录本地节点（不含联邦与中继上游的别名）的上下线事件订阅
+type presenceHub struct {
+	mu   sync.Mutex
+	subs map[*Context]*presenceSub
+}
+
+func (h *presenceHub) subscribe(ctx *Context, domains []string) *presenceSub {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sub, ok := h.subs[ctx]
+	if !ok {
+		sub = &presenceSub{domains: make(map[string]struct{})}
+		h.subs[ctx] = sub
+	}
+	if len(domains) == 0 {
+		sub.all = true
+	}
+	for _, domain := range domains {
+		sub.domains[domain] = struct{}{}
+	}
+	return &presenceSub{all: sub.all, domains: copyDomainSet(sub.domains)}
+}
+
+func (h *presenceHub) unsubscribe(ctx *Context, domains []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sub, ok := h.subs[ctx]
+	if !ok {
+		return
+	}
+	if len(domains) == 0 {
+		delete(h.subs, ctx)
+		return
+	}
+	for _, domain := range domains {
+		delete(sub.domains, domain)
+	}
+	if !sub.all && len(sub.domains) == 0 {
+		delete(h.subs, ctx)
+	}
+}
+
+func (h *presenceHub) remove(ctx *Context) {
+	h.mu.Lock()
+	delete(h.subs, ctx)
+	h.mu.Unlock()
+}
+
+// subscribers 返回订阅了 ctx 上下线事件的节点，不含 ctx 自身
+func (h *presenceHub) subscribers(ctx *Context) []*Context {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var ctxs []*Context
+	for sub, s := range h.subs {
+		if sub != ctx && s.matches(ctx.Domain) {
+			ctxs = append(ctxs, sub)
+		}
+	}
+	return ctxs
+}
+
+func copyDomainSet(set map[string]struct{}) map[string]struct{} {
+	cp := make(map[string]struct{}, len(set))
+	for k := range set {
+		cp[k] = struct{}{}
+	}
+	return cp
+}
+
+func normalizeDomains(domains []string) ([]string, error) {
+	names := make([]string, 0, len(domains))
+	for _, domain := range domains {
+		name, err := admit.NormalizeName(domain)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// publishPresence 向订阅者推送 ctx 的上下线事件
+func (s *Server) publishPresence(ctx *Context, online bool) {
+	subs := s.presence.subscribers(ctx)
+	if len(subs) == 0 {
+		return
+	}
+	ev := packet.PresenceEvent{Domain: ctx.Domain, IP: ctx.IP, Online: online, Labels: ctx.Labels}
+	msg, err := packet.NewMessage(packet.TopicPresenceEvent, ev)
+	if err != nil {
+		return
+	}
+	payload, err := msg.Encode()
+	if err != nil {
+		s.logger.Warn("encode presence event failed", "domain", ctx.Domain, "error", err)
+		return
+	}
+	for _, sub := range subs {
+		pbuf := packet.NewBufferWithCmd(packet.CmdPushMessage)
+		pbuf.SetSrc(packet.SwitcherIP, 0)
+		pbuf.SetDist(sub.IP, 0)
+		_ = pbuf.SetPayload(payload)
+		if err := sub.enqueueForward(pbuf); err != nil {
+			s.logger.Warn("push presence event failed", "ctx_id", sub.id, "domain", sub.Domain, "event_domain", ctx.Domain, "error", err)
+		}
+	}
+}
+
+// maxReplyBodySize 是分页应答中列表编码后的上限，为消息的其他字段留出余量
+const maxReplyBodySize = packet.MaxPayloadSize - 1024
+
+// replyPage 截取 items 的前 limit 项（0 表示不限），并保证编码后不超过 maxReplyBodySize
+func replyPage[T any](items []T, limit int) []T {
+	if limit > 0 && len(items) > limit {
+		items = items[:limit]
+	}
+	size := len("[]")
+	for i, item := range items {
+		data, err := json.Marshal(item)
+		if err != nil {
+			return items[:i]
+		}
+		size += len(data) + len(",")
+		if size > maxReplyBodySize {
+			return items[:i]
+		}
+	}
+	return items
+}
+
+func (s *Server) handleDirectoryList(caller *Context, msg *packet.Message) (any, error) {
+	var req packet.DirectoryQuery
+	if err := msg.Unmarshal(&req); err != nil {
+		return nil, err
+	}
+	sel, err := selector.Parse(req.Selector)
+	if err != nil {
+		return nil, err
+	}
+	ctxs := s.registry.selectContexts(sel)
+	records := make([]packet.NodeRecord, 0, len(ctxs))
+	for _, ctx := range ctxs {
+		if ctx.IP <= req.After {
+			continue
+		}
+		record := packet.NodeRecord{Domain: ctx.Domain, IP: ctx.IP}
+		if req.Labels {
+			record.Labels = ctx.Labels
+		}
+		if req.Ports {
+			record.Ports = ctx.Ports()
+		}
+		records = append(records, record)
+	}
+	return replyPage(records, req.Limit), nil
+}
+
+func (s *Server) handleDirectoryAdvertise(caller *Context, msg *packet.Message) (any, error) {
+	var req packet.DirectoryAdvertisement
+	if err := msg.Unmarshal(&req); err != nil {
+		return nil, err
+	}
+	ports := make([]uint16, 0, len(req.Ports))
+	seen := make(map[uint16]bool, len(req.Ports))
+	for _, port := range req.Ports {
+		if !seen[port] {
+			seen[port] = true
+			ports = append(ports, port)
+		}
+	}
+	sort.Slice(ports, func(i, j int) bool { return ports[i] < ports[j] })
+	caller.setPorts(ports)
+	return nil, nil
+}
+
+// handlePresenceSubscribe 登记订阅，并返回订阅范围内当前在线的节点。
+// 订阅登记后才生成快照，事件可能与快照重复，但不会遗漏。
+func (s *Server) handlePresenceSubscribe(caller *Context, msg *packet.Message) (any, error) {
+	var req packet.PresenceSubscription
+	if err := msg.Unmarshal(&req); err != nil {
+		return nil, err
+	}
+	domains, err := normalizeDomains(req.Domains)
+	if err != nil {
+		return nil, err
+	}
+	sub := s.presence.subscribe(caller, domains)
+
+	ctxs := s.localContexts()
+	sort.Slice(ctxs, func(i, j int) bool { return ctxs[i].IP < ctxs[j].IP })
+	events := make([]packet.PresenceEvent, 0, len(ctxs))
+	for _, ctx := range ctxs {
+		if ctx != caller && ctx.IP > req.After && sub.matches(ctx.Domain) {
+			events = append(events, packet.PresenceEvent{Domain: ctx.Domain, IP: ctx.IP, Online: true, Labels: ctx.Labels})
+		}
+	}
+	return replyPage(events, req.Limit), nil
+}
+
+func (s *Server) handlePresenceUnsubscribe(caller *Context, msg *packet.Message) (any, error) {
+	var req packet.PresenceSubscription
+	if err := msg.Unmarshal(&req); err != nil {
+		return nil, err
+	}
+	domains, err := normalizeDomains(req.Domains)
+	if err != nil {
+		return nil, err
+	}
+	s.presence.unsubscribe(caller, domains)
+	return nil, nil
+}
@@ -0,0 +1,139 @@
+package switcher
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/net-agent/flex/v3/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDirectoryList(t *testing.T) {
+	s := NewServer("testpswd", nil, nil)
+	a := connectLabeledNode(t, s, "a", map[string]string{"role": "chat"})
+	connectTestNode(t, s, "b")
+
+	assert.Nil(t, a.AdvertisePorts(8080, 80, 80))
+	records, err := a.ListDirectory(packet.DirectoryQuery{})
+	if assert.Nil(t, err) && assert.Equal(t, 2, len(records)) {
+		assert.Equal(t, "a", records[0].Domain)
+		assert.Nil(t, records[0].Labels)
+		assert.Nil(t, records[0].Ports)
+		assert.Equal(t, "b", records[1].Domain)
+	}
+
+	records, err = a.ListDirectory(packet.DirectoryQuery{Selector: "role=chat", Labels: true, Ports: true})
+	if assert.Nil(t, err) && assert.Equal(t, 1, len(records)) {
+		assert.Equal(t, map[string]string{"role": "chat"}, records[0].Labels)
+		assert.Equal(t, []uint16{80, 8080}, records[0].Ports)
+	}
+	infos, _ := s.QueryClients("role=chat")
+	if assert.Equal(t, 1, len(infos)) {
+		assert.Equal(t, []uint16{80, 8080}, infos[0].Ports)
+	}
+
+	_, err = a.ListDirectory(packet.DirectoryQuery{Selector: "a b"})
+	assert.NotNil(t, err)
+}
+
+func TestDirectoryPaging(t *testing.T) {
+	s := NewServer("testpswd", nil, nil)
+	watcher := connectTestNode(t, s, "watcher")
+
+	// 每个节点的标签约 2KB，完整列表超过一个数据包
+	labels := map[string]string{}
+	for i := 0; i < 8; i++ {
+		labels[fmt.Sprintf("k%v", i)] = strings.Repeat("x", 250)
+	}
+	const total = 40
+	for i := 0; i < total; i++ {
+		connectLabeledNode(t, s, fmt.Sprintf("n%02d", i), labels)
+	}
+
+	caller, _ := s.registry.lookupByDomain("watcher")
+	msg, _ := packet.NewMessage(packet.TopicDirectoryList, packet.DirectoryQuery{Labels: true})
+	body, err := s.handleDirectoryList(caller, msg)
+	if assert.Nil(t, err) {
+		page := body.([]packet.NodeRecord)
+		assert.Less(t, len(page), total)
+		reply, _ := packet.NewMessage(packet.TopicDirectoryList, page)
+		_, err = reply.Encode()
+		assert.Nil(t, err)
+	}
+
+	for _, limit := range []int{0, 7} {
+		records, err := watcher.ListDirectory(packet.DirectoryQuery{Labels: true, Limit: limit})
+		if assert.Nil(t, err) && assert.Equal(t, total+1, len(records)) {
+			for i := 1; i < len(records); i++ {
+				assert.Less(t, records[i-1].IP, records[i].IP)
+			}
+		}
+	}
+
+	online, err := watcher.SubscribePresence(func(ev packet.PresenceEvent) {})
+	assert.Nil(t, err)
+	assert.Equal(t, total, len(online))
+}
+
+func TestPresenceSubscribe(t *testing.T) {
+	s := NewServer("testpswd", nil, nil)
+	watcher := connectTestNode(t, s, "watcher")
+	connectTestNode(t, s, "a")
+
+	events := make(chan packet.PresenceEvent, 16)
+	online, err := watcher.SubscribePresence(func(ev packet.PresenceEvent) { events <- ev }, "A", "b")
+	if assert.Nil(t, err) && assert.Equal(t, 1, len(online)) {
+		assert.Equal(t, "a", online[0].Domain)
+		assert.True(t, online[0].Online)
+	}
+
+	next := func() packet.PresenceEvent {
+		select {
+		case ev := <-events:
+			return ev
+		case <-time.After(time.Second):
+			t.Fatal("presence event timeout")
+			return packet.PresenceEvent{}
+		}
+	}
+
+	// 未订阅的节点不产生事件
+	connectTestNode(t, s, "c")
+	b := connectTestNode(t, s, "b")
+	ev := next()
+	assert.Equal(t, "b", ev.Domain)
+	assert.Equal(t, b.GetIP(), ev.IP)
+	assert.True(t, ev.Online)
+
+	b.Close()
+	ev = next()
+	assert.Equal(t, "b", ev.Domain)
+	assert.False(t, ev.Online)
+
+	assert.Nil(t, watcher.UnsubscribePresence("b"))
+	connectTestNode(t, s, "b")
+	select {
+	case ev := <-events:
+		t.Errorf("unexpected event %v", ev)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// 订阅所有节点
+	_, err = watcher.SubscribePresence(nil)
+	assert.Nil(t, err)
+	connectTestNode(t, s, "d")
+	assert.Equal(t, "d", next().Domain)
+
+	_, err = watcher.SubscribePresence(nil, "bad domain")
+	assert.NotNil(t, err)
+
+	// 订阅者下线后清理订阅
+	watcher.Close()
+	assert.Eventually(t, func() bool {
+		s.presence.mu.Lock()
+		defer s.presence.mu.Unlock()
+		return len(s.presence.subs) == 0
+	}, time.Second, 10*time.Millisecond)
+}
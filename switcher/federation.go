@@ -0,0 +1,488 @@
+package switcher
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/net-agent/flex/v3/internal/admit"
+	"github.com/net-agent/flex/v3/packet"
+)
+
+var (
+	errPeerExist        = errors.New("peer exist")
+	errPeerConnNoRead   = errors.New("peer conn of remote context is write only")
+	errInvalidPeerEntry = errors.New("invalid peer entry")
+)
+
+const (
+	topicPeerAdd = "peer.add"
+	topicPeerDel = "peer.del"
+
+	// peerEntriesPerMessage 单个消息中携带的域名条目上限，保证消息不超过 MaxPayloadSize
+	peerEntriesPerMessage = 500
+
+	// maxPendingAdverts 链路上等待发送的通告上限，超过时认为对端已经卡住并断开链路
+	maxPendingAdverts = 1024
+)
+
+// PeerInfo describes a federated switcher connected to this server.
+type PeerInfo struct {
+	Name        string    `json:"name"`
+	Domains     int       `json:"domains"`
+	ConnectedAt time.Time `json:"connected_at"`
+}
+
+// peerEntry 是对端通告的一条域名记录，IP 位于对端 switcher 的地址空间
+type peerEntry struct {
+	Domain string `json:"domain"`
+	IP     uint16 `json:"ip"`
+}
+
+// peerLink 是与另一个 switcher 之间的联邦连接。
+//
+// 两个 switcher 拥有各自独立的 IP 空间，对端的每个域名在本地都对应一个
+// remote Context（别名 IP）。跨链路的数据包遵循以下转换规则：
+//   - 发出：DistIP 由本地别名改写为对端真实 IP（peerConn.WriteBuffer）
+//   - 收到：SrcIP 由对端真实 IP 改写为本地别名（Server.readPeer）
+type peerLink struct {
+	name        string
+	conn        packet.Conn
+	connectedAt time.Time
+
+	mu      sync.Mutex
+	entries map[string]uint16   // domain -> peer ip
+	aliases map[uint16]*Context // peer ip -> local alias context
+
+	// 通告由单独的 goroutine 按顺序写出，上线/下线不等待对端
+	advMu       sync.Mutex
+	adverts     []peerAdvert
+	advertising bool
+	advClosed   bool
+}
+
+type peerAdvert struct {
+	topic   string
+	entries []peerEntry
+	resolve func() []peerEntry // 不为 nil 时在发送时取得 entries
+}
+
+func newPeerLink(name string, conn packet.Conn) *peerLink {
+	return &peerLink{
+		name:        name,
+		conn:        conn,
+		connectedAt: time.Now(),
+		entries:     make(map[string]uint16),
+		aliases:     make(map[uint16]*Context),
+	}
+}
+
+func (link *peerLink) lookupAlias(peerIP uint16) *Context {
+	link.mu.Lock()
+	defer link.mu.Unlock()
+	return link.aliases[peerIP]
+}
+
+// advertise sends entries to the peer, split into several messages if needed.
+func (link *peerLink) advertise(topic string, entries []peerEntry) error {
+	for len(entries) > 0 {
+		n := min(len(entries), peerEntriesPerMessage)
+		msg, err := packet.NewMessage(topic, entries[:n])
+		if err != nil {
+			return err
+		}
+		payload, err := msg.Encode()
+		if err != nil {
+			return err
+		}
+		pbuf := packet.NewBufferWithCmd(packet.CmdPushMessage)
+		pbuf.SetSrc(packet.SwitcherIP, 0)
+		pbuf.SetDist(packet.SwitcherIP, 0)
+		if err := pbuf.SetPayload(payload); err != nil {
+			return err
+		}
+		if err := link.conn.WriteBuffer(pbuf); err != nil {
+			return err
+		}
+		entries = entries[n:]
+	}
+	return nil
+}
+
+// queueAdvert 把通告排入链路的发送队列后立即返回。队列积压超过 maxPendingAdverts
+// 时认为对端已经卡住，关闭链路
+func (link *peerLink) queueAdvert(topic string, entries []peerEntry, logger *slog.Logger) {
+	link.queue(peerAdvert{topic: topic, entries: entries}, logger)
+}
+
+// queueSnapshot 排入一条在发送时才取得内容的通告。快照与之后排入的上线/下线通告
+// 按队列顺序发出，不会出现快照晚于某个下线通告、让对端保留已下线域名的情况
+func (link *peerLink) queueSnapshot(topic string, resolve func() []peerEntry, logger *slog.Logger) {
+	link.queue(peerAdvert{topic: topic, resolve: resolve}, logger)
+}
+
+func (link *peerLink) queue(adv peerAdvert, logger *slog.Logger) {
+	link.advMu.Lock()
+	if link.advClosed {
+		link.advMu.Unlock()
+		return
+	}
+	if len(link.adverts) >= maxPendingAdverts {
+		link.advClosed = true
+		link.adverts = nil
+		link.advMu.Unlock()
+		logger.Warn("peer advert queue full, closing link", "peer", link.name)
+		link.conn.Close()
+		return
+	}
+	link.adverts = append(link.adverts, adv)
+	if link.advertising {
+		link.advMu.Unlock()
+		return
+	}
+	link.advertising = true
+	link.advMu.Unlock()
+
+	go link.runAdverts(logger)
+}
+
+func (link *peerLink) runAdverts(logger *slog.Logger) {
+	for {
+		link.advMu.Lock()
+		if len(link.adverts) == 0 || link.advClosed {
+			link.adverts = nil
+			link.advertising = false
+			link.advMu.Unlock()
+			return
+		}
+		adv := link.adverts[0]
+		link.adverts = link.adverts[1:]
+		link.advMu.Unlock()
+
+		if adv.resolve != nil {
+			adv.entries = adv.resolve()
+		}
+		if err := link.advertise(adv.topic, adv.entries); err != nil {
+			logger.Warn("advertise to peer failed", "peer", link.name, "topic", adv.topic, "error", err)
+			link.closeAdverts()
+			link.conn.Close()
+			return
+		}
+	}
+}
+
+// closeAdverts 丢弃尚未发送的通告，之后的通告也不再发送
+func (link *peerLink) closeAdverts() {
+	link.advMu.Lock()
+	link.advClosed = true
+	link.adverts = nil
+	link.advMu.Unlock()
+}
+
+// peerConn is the packet.Conn of a remote context. It rewrites the destination
+// to the address used by the owning switcher and writes through the peer link.
+type peerConn struct {
+	link *peerLink
+	ip   uint16
+}
+
+func (c *peerConn) WriteBuffer(buf *packet.Buffer) error {
+	buf.SetDistIP(c.ip)
+	return c.link.conn.WriteBuffer(buf)
+}
+
+func (c *peerConn) ReadBuffer() (*packet.Buffer, error) { return nil, errPeerConnNoRead }
+func (c *peerConn) SetReadTimeout(time.Duration) error  { return nil }
+func (c *peerConn) SetWriteTimeout(time.Duration)       {}
+func (c *peerConn) Close() error                        { return nil } // 链路由 peerLink 自身管理
+func (c *peerConn) GetRawConn() net.Conn                { return c.link.conn.GetRawConn() }
+
+// SetName sets the name this server presents to federated peers.
+func (s *Server) SetName(name string) { s.name = name }
+
+// GetName returns the name this server presents to federated peers.
+func (s *Server) GetName() string { return s.name }
+
+// ConnectPeer federates this server with the switcher at the other end of pc.
+// Both switchers must share the same password. Domains of each side become
+// reachable from nodes of the other side. It blocks until the link is closed;
+// reconnecting is left to the caller.
+func (s *Server) ConnectPeer(pc packet.Conn) error {
+	defer pc.Close()
+
+	name, err := admit.HandshakePeer(pc, s.name, s.password)
+	if err != nil {
+		s.logger.Warn("peer handshake failed", "error", err)
+		return err
+	}
+
+	link := newPeerLink(name, pc)
+	if err := s.addPeer(link); err != nil {
+		s.logger.Warn("add peer failed", "peer", name, "error", err)
+		return err
+	}
+	return s.runPeer(link)
+}
+
+// acceptPeer 处理对端 switcher 发起的联邦连接（握手请求已经通过校验）
+func (s *Server) acceptPeer(pc packet.Conn, name string) error {
+	link := newPeerLink(name, pc)
+	if err := s.addPeer(link); err != nil {
+		resp := admit.NewErrResponse(-3, "peer rejected")
+		resp.WriteTo(pc, s.password)
+		s.logger.Warn("add peer failed", "peer", name, "error", err)
+		return err
+	}
+
+	resp := admit.NewPeerResponse(s.name)
+	if err := resp.WriteTo(pc, s.password); err != nil {
+		s.removePeer(link)
+		return errHandlePCWriteFailed
+	}
+	return s.runPeer(link)
+}
+
+// GetPeers returns the federated switchers currently connected.
+func (s *Server) GetPeers() []PeerInfo {
+	links := s.peerLinks()
+	infos := make([]PeerInfo, 0, len(links))
+	for _, link := range links {
+		link.mu.Lock()
+		n := len(link.entries)
+		link.mu.Unlock()
+		infos = append(infos, PeerInfo{Name: link.name, Domains: n, ConnectedAt: link.connectedAt})
+	}
+	return infos
+}
+
+func (s *Server) addPeer(link *peerLink) error {
+	s.peersMu.Lock()
+	defer s.peersMu.Unlock()
+	if _, exists := s.peers[link.name]; exists {
+		return errPeerExist
+	}
+	s.peers[link.name] = link
+	return nil
+}
+
+func (s *Server) removePeer(link *peerLink) {
+	s.peersMu.Lock()
+	if cur, ok := s.peers[link.name]; ok && cur == link {
+		delete(s.peers, link.name)
+	}
+	s.peersMu.Unlock()
+	link.closeAdverts()
+
+	link.mu.Lock()
+	aliases := make([]*Context, 0, len(link.aliases))
+	for _, ctx := range link.aliases {
+		aliases = append(aliases, ctx)
+	}
+	link.entries = make(map[string]uint16)
+	link.aliases = make(map[uint16]*Context)
+	link.mu.Unlock()
+
+	for _, ctx := range aliases {
+		s.registry.detach(ctx)
+	}
+}
+
+func (s *Server) peerLinks() []*peerLink {
+	s.peersMu.Lock()
+	defer s.peersMu.Unlock()
+	links := make([]*peerLink, 0, len(s.peers))
+	for _, link := range s.peers {
+		links = append(links, link)
+	}
+	return links
+}
+
+func (s *Server) runPeer(link *peerLink) error {
+	defer s.removePeer(link)
+	s.logger.Info("peer connected", "peer", link.name)
+
+	link.queueSnapshot(topicPeerAdd, s.localEntries, s.logger)
+
+	err := s.readPeer(link)
+	s.logger.Info("peer disconnected", "peer", link.name, "error", err)
+	return err
+}
+
+// readPeer 读取对端发来的数据包：控制消息更新域名表，其余数据包转换源地址后转发给本地节点
+func (s *Server) readPeer(link *peerLink) error {
+	for {
+		pbuf, err := link.conn.ReadBuffer()
+		if err != nil {
+			return err
+		}
+
+		if pbuf.DistIP() == packet.SwitcherIP {
+			if pbuf.Cmd() == packet.CmdPushMessage {
+				s.handlePeerMessage(link, pbuf)
+			} else {
+				s.router.logger.Warn("unexpected packet from peer", "peer", link.name, "header", pbuf.HeaderString())
+			}
+			continue
+		}
+
+		src := link.lookupAlias(pbuf.SrcIP())
+		if src == nil {
+			s.router.logger.Warn("peer src ip not advertised", "peer", link.name, "src_ip", pbuf.SrcIP())
+			continue
+		}
+		src.recordIncoming(pbuf)
+		pbuf.SetSrcIP(src.IP)
+
+		// 只转发给本地节点，避免两个 switcher 之间出现路由环路
+		dist, err := s.registry.lookupByIP(pbuf.DistIP())
+		if err != nil || dist.IsRemote() {
+			s.router.logger.Warn("route peer pbuf failed", "peer", link.name, "dist_ip", pbuf.DistIP(), "error", err)
+			continue
+		}
+		if err := dist.enqueueForward(pbuf); err != nil {
+			s.router.logger.Warn("forward peer pbuf failed", "peer", link.name, "dist_ip", pbuf.DistIP(), "error", err)
+		}
+	}
+}
+
+func (s *Server) handlePeerMessage(link *peerLink, pbuf *packet.Buffer) {
+	msg, err := packet.DecodeMessage(pbuf.Payload)
+	if err != nil {
+		s.logger.Warn("decode peer message failed", "peer", link.name, "error", err)
+		return
+	}
+
+	var entries []peerEntry
+	if err := msg.Unmarshal(&entries); err != nil {
+		s.logger.Warn("decode peer entries failed", "peer", link.name, "topic", msg.Topic, "error", err)
+		return
+	}
+
+	switch msg.Topic {
+	case topicPeerAdd:
+		for _, e := range entries {
+			s.learnRemote(link, e)
+		}
+	case topicPeerDel:
+		for _, e := range entries {
+			s.forgetRemote(link, e)
+		}
+	default:
+		s.logger.Warn("unknown peer message", "peer", link.name, "topic", msg.Topic)
+	}
+}
+
+func (s *Server) learnRemote(link *peerLink, e peerEntry) {
+	if e.IP == packet.LocalIP || e.IP == packet.SwitcherIP {
+		s.logger.Warn("ignore peer entry", "peer", link.name, "domain", e.Domain, "error", errInvalidPeerEntry)
+		return
+	}
+
+	link.mu.Lock()
+	oldIP, had := link.entries[e.Domain]
+	if had && oldIP == e.IP && link.aliases[oldIP] != nil {
+		link.mu.Unlock()
+		return
+	}
+	link.entries[e.Domain] = e.IP
+	var old *Context
+	if had {
+		old = link.aliases[oldIP]
+		delete(link.aliases, oldIP)
+	}
+	link.mu.Unlock()
+
+	if old != nil {
+		s.registry.detach(old)
+	}
+	s.attachAlias(link, e.Domain, e.IP)
+}
+
+func (s *Server) forgetRemote(link *peerLink, e peerEntry) {
+	link.mu.Lock()
+	cur, ok := link.entries[e.Domain]
+	if !ok || cur != e.IP {
+		link.mu.Unlock()
+		return
+	}
+	delete(link.entries, e.Domain)
+	ctx := link.aliases[e.IP]
+	delete(link.aliases, e.IP)
+	link.mu.Unlock()
+
+	if ctx != nil {
+		s.registry.detach(ctx)
+	}
+}
+
+// attachAlias 为对端通告的域名创建本地别名 Context。
+// 如果域名已被本地节点或其他对端占用则放弃，返回 false。
+func (s *Server) attachAlias(link *peerLink, domain string, peerIP uint16) bool {
+	link.mu.Lock()
+	defer link.mu.Unlock()
+
+	if cur, ok := link.entries[domain]; !ok || cur != peerIP {
+		return false
+	}
+	if link.aliases[peerIP] != nil {
+		return true
+	}
+
+	id := int(atomic.AddInt32(&s.nextCtxID, 1))
+	ctx := NewContext(id, &peerConn{link: link, ip: peerIP}, domain, "", s.ctxLogger)
+	ctx.peer = link
+	if err := s.registry.attachRemote(ctx); err != nil {
+		ctx.release()
+		s.logger.Debug("remote domain not attached", "peer", link.name, "domain", domain, "error", err)
+		return false
+	}
+	link.aliases[peerIP] = ctx
+	return true
+}
+
+// restoreRemote 在域名空出时，尝试用对端通告的记录重新占用它
+func (s *Server) restoreRemote(domain string) {
+	for _, link := range s.peerLinks() {
+		link.mu.Lock()
+		ip, ok := link.entries[domain]
+		link.mu.Unlock()
+		if ok && s.attachAlias(link, domain, ip) {
+			return
+		}
+	}
+}
+
+// localEntries 返回需要通告给对端的本地域名（不包含从对端学到的域名，联邦不做转接）
+func (s *Server) localEntries() []peerEntry {
+	ctxs := s.registry.activeContexts()
+	entries := make([]peerEntry, 0, len(ctxs))
+	for _, ctx := range ctxs {
+		if ctx == nil || ctx.IsRemote() {
+			continue
+		}
+		entries = append(entries, peerEntry{Domain: ctx.Domain, IP: ctx.IP})
+	}
+	return entries
+}
+
+// broadcastPeers 把通告排入每条链路的发送队列，不会因为某个对端卡住而阻塞
+func (s *Server) broadcastPeers(topic string, entries []peerEntry) {
+	for _, link := range s.peerLinks() {
+		link.queueAdvert(topic, entries, s.logger)
+	}
+}
+
+// dropAlias 将被摘除的别名从链路上移除（别名可能是被本地节点挤掉的），以便之后恢复
+func (s *Server) dropAlias(ctx *Context) {
+	link := ctx.peer
+	link.mu.Lock()
+	defer link.mu.Unlock()
+	for ip, alias := range link.aliases {
+		if alias == ctx {
+			delete(link.aliases, ip)
+		}
+	}
+}
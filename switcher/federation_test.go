@@ -0,0 +1,231 @@
+package switcher
+
+import (
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/net-agent/flex/v3/internal/admit"
+	"github.com/net-agent/flex/v3/node"
+	"github.com/net-agent/flex/v3/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func connectTestNode(t *testing.T, s *Server, domain string) *node.Node {
+	t.Helper()
+	pc1, pc2 := packet.Pipe()
+	go s.ServeConn(pc2)
+
+	ip, err := admit.Handshake(pc1, domain, "", s.password)
+	if err != nil {
+		t.Fatalf("handshake failed: %v", err)
+	}
+	n := node.New(pc1)
+	n.SetIP(ip)
+	n.SetDomain(domain)
+	go n.Serve()
+	return n
+}
+
+func initFederation(t *testing.T) (*Server, *Server, packet.Conn) {
+	t.Helper()
+	pswd := "testpswd"
+	sa := NewServer(pswd, nil, nil)
+	sa.SetName("region-a")
+	sb := NewServer(pswd, nil, nil)
+	sb.SetName("region-b")
+
+	pc1, pc2 := packet.Pipe()
+	go sb.ServeConn(pc2)
+	go sa.ConnectPeer(pc1)
+
+	assert.Eventually(t, func() bool {
+		return len(sa.GetPeers()) == 1 && len(sb.GetPeers()) == 1
+	}, time.Second, 10*time.Millisecond)
+	return sa, sb, pc1
+}
+
+func waitDomain(t *testing.T, s *Server, domain string) {
+	t.Helper()
+	assert.Eventually(t, func() bool {
+		_, err := s.registry.lookupByDomain(domain)
+		return err == nil
+	}, time.Second, 10*time.Millisecond, "domain %v not reachable", domain)
+}
+
+func TestFederationDialAcrossSwitchers(t *testing.T) {
+	sa, sb, _ := initFederation(t)
+	assert.Equal(t, "region-b", sa.GetPeers()[0].Name)
+	assert.Equal(t, "region-a", sb.GetPeers()[0].Name)
+
+	nodeA := connectTestNode(t, sa, "node-a")
+	nodeB := connectTestNode(t, sb, "node-b")
+	waitDomain(t, sa, "node-b")
+	waitDomain(t, sb, "node-a")
+
+	l, err := nodeB.Listen(80)
+	assert.Nil(t, err)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(c, c)
+		}
+	}()
+
+	_, err = nodeA.PingDomain("node-b", time.Second)
+	assert.Nil(t, err, "ping across federation")
+
+	s, err := nodeA.Dial("node-b:80")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer s.Close()
+	assert.Equal(t, "node-b", s.GetState().RemoteDomain)
+
+	payload := []byte("hello federation")
+	_, err = s.Write(payload)
+	assert.Nil(t, err)
+	buf := make([]byte, len(payload))
+	_, err = io.ReadFull(s, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, payload, buf)
+
+	// 远端域名不计入本地连接
+	assert.Equal(t, 1, len(sa.GetClients()))
+	assert.Equal(t, 1, sa.GetStats().ActiveConnections)
+}
+
+func TestFederationLocalDomainWins(t *testing.T) {
+	sa, sb, _ := initFederation(t)
+
+	connectTestNode(t, sb, "dup")
+	waitDomain(t, sa, "dup")
+	remote, _ := sa.registry.lookupByDomain("dup")
+	assert.True(t, remote.IsRemote())
+
+	// 本地节点接入后应立即覆盖远端别名
+	local := connectTestNode(t, sa, "dup")
+	assert.Eventually(t, func() bool {
+		ctx, err := sa.registry.lookupByDomain("dup")
+		return err == nil && !ctx.IsRemote()
+	}, time.Second, 10*time.Millisecond)
+
+	// 本地节点断开后恢复远端别名
+	local.Close()
+	assert.Eventually(t, func() bool {
+		ctx, err := sa.registry.lookupByDomain("dup")
+		return err == nil && ctx.IsRemote()
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestFederationPeerDisconnect(t *testing.T) {
+	sa, sb, pc := initFederation(t)
+
+	nodeB := connectTestNode(t, sb, "node-b")
+	waitDomain(t, sa, "node-b")
+
+	// 节点下线后对端撤销域名
+	nodeB.Close()
+	assert.Eventually(t, func() bool {
+		_, err := sa.registry.lookupByDomain("node-b")
+		return err != nil
+	}, time.Second, 10*time.Millisecond)
+
+	connectTestNode(t, sb, "node-c")
+	waitDomain(t, sa, "node-c")
+
+	// 链路断开后清理所有远端域名
+	pc.Close()
+	assert.Eventually(t, func() bool {
+		_, err := sa.registry.lookupByDomain("node-c")
+		return err != nil && len(sa.GetPeers()) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestFederationDuplicatePeer(t *testing.T) {
+	sa, sb, _ := initFederation(t)
+	_ = sb
+
+	pc1, pc2 := packet.Pipe()
+	go sb.ServeConn(pc2)
+	err := sa.ConnectPeer(pc1)
+	assert.NotNil(t, err, "second link with the same name should be rejected")
+}
+
+func TestFederationStuckPeerDoesNotBlockLogin(t *testing.T) {
+	pswd := "testpswd"
+	sa := NewServer(pswd, nil, nil)
+	sa.SetName("region-a")
+
+	// 对端握手后不再读取，通告无法写出
+	pc1, pc2 := packet.Pipe()
+	defer pc1.Close()
+	go sa.ServeConn(pc2)
+	_, err := admit.HandshakePeer(pc1, "stuck", pswd)
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool { return len(sa.GetPeers()) == 1 }, time.Second, 10*time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		connectTestNode(t, sa, "node-a")
+		connectTestNode(t, sa, "node-b")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("login blocked by stuck peer")
+	}
+	waitDomain(t, sa, "node-b")
+}
+
+func TestPeerLinkSnapshotResolvedOnSend(t *testing.T) {
+	pc1, pc2 := packet.Pipe()
+	defer pc1.Close()
+	defer pc2.Close()
+	link := newPeerLink("peer", pc1)
+	logger := slog.Default()
+
+	readEntries := func() (string, []peerEntry) {
+		pbuf, err := pc2.ReadBuffer()
+		if !assert.Nil(t, err) {
+			return "", nil
+		}
+		msg, err := packet.DecodeMessage(pbuf.Payload)
+		assert.Nil(t, err)
+		var entries []peerEntry
+		assert.Nil(t, msg.Unmarshal(&entries))
+		return msg.Topic, entries
+	}
+
+	// 对端没有读取时，第一条通告卡在写出上，快照排在它之后
+	var mu sync.Mutex
+	local := []peerEntry{{Domain: "x", IP: 10}, {Domain: "y", IP: 11}}
+	link.queueAdvert(topicPeerAdd, []peerEntry{{Domain: "w", IP: 9}}, logger)
+	link.queueSnapshot(topicPeerAdd, func() []peerEntry {
+		mu.Lock()
+		defer mu.Unlock()
+		return local
+	}, logger)
+
+	// 快照排队之后 x 下线，下线通告排在快照之后
+	mu.Lock()
+	local = local[1:]
+	mu.Unlock()
+	link.queueAdvert(topicPeerDel, []peerEntry{{Domain: "x", IP: 10}}, logger)
+
+	topic, entries := readEntries()
+	assert.Equal(t, topicPeerAdd, topic)
+	assert.Equal(t, []peerEntry{{Domain: "w", IP: 9}}, entries)
+	topic, entries = readEntries()
+	assert.Equal(t, topicPeerAdd, topic)
+	assert.Equal(t, []peerEntry{{Domain: "y", IP: 11}}, entries, "snapshot is taken when it is sent")
+	topic, entries = readEntries()
+	assert.Equal(t, topicPeerDel, topic)
+	assert.Equal(t, []peerEntry{{Domain: "x", IP: 10}}, entries)
+}
@@ -0,0 +1,280 @@
+package switcher
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/net-agent/flex/v3/internal/admit"
+	"github.com/net-agent/flex/v3/packet"
+)
+
+var (
+	errGroupNotFound    = errors.New("group not found")
+	errGroupNameIsTaken = errors.New("group name is taken by a domain")
+)
+
+// groupProbeInterval 组成员 RTT 的刷新间隔（仅 GroupLowestRTT 策略）
+var groupProbeInterval = 30 * time.Second
+
+// GroupPolicy selects the member of a service group that serves a new stream.
+type GroupPolicy int
+
+const (
+	GroupRoundRobin   GroupPolicy = iota // 轮询
+	GroupLeastStreams                    // 活跃 stream 最少（ContextStats.StreamCount）
+	GroupLowestRTT                       // 最近一次 RTT 最低，未测得 RTT 的成员排在最后
+)
+
+func (p GroupPolicy) String() string {
+	switch p {
+	case GroupLeastStreams:
+		return "least-streams"
+	case GroupLowestRTT:
+		return "lowest-rtt"
+	default:
+		return "round-robin"
+	}
+}
+
+// GroupInfo describes a service group and its members.
+type GroupInfo struct {
+	Name    string   `json:"name"`
+	Policy  string   `json:"policy"`
+	Members []string `json:"members"`
+}
+
+type groupMember struct {
+	ctx      *Context
+	probedAt time.Time
+}
+
+// serviceGroup 是多个 Context 共享的服务名称，新的 stream 按策略分配给其中一个成员
+type serviceGroup struct {
+	name   string
+	policy GroupPolicy
+
+	mu      sync.Mutex
+	members []*groupMember
+	next    uint32
+}
+
+func (g *serviceGroup) add(ctx *Context) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, m := range g.members {
+		if m.ctx == ctx {
+			return
+		}
+	}
+	g.members = append(g.members, &groupMember{ctx: ctx})
+}
+
+// remove 移除成员，返回剩余成员数
+func (g *serviceGroup) remove(ctx *Context) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for i, m := range g.members {
+		if m.ctx == ctx {
+			g.members = append(g.members[:i], g.members[i+1:]...)
+			break
+		}
+	}
+	return len(g.members)
+}
+
+// pick 按策略选出一个成员。各策略在比较结果相同时轮流选择，避免总是落在同一个成员上。
+func (g *serviceGroup) pick() *Context {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	n := len(g.members)
+	if n == 0 {
+		return nil
+	}
+	start := int(g.next % uint32(n))
+	g.next++
+
+	best := g.members[start]
+	switch g.policy {
+	case GroupLeastStreams:
+		for i := 1; i < n; i++ {
+			m := g.members[(start+i)%n]
+			if m.ctx.load() < best.ctx.load() {
+				best = m
+			}
+		}
+	case GroupLowestRTT:
+		g.probeStale()
+		for i := 1; i < n; i++ {
+			m := g.members[(start+i)%n]
+			if rttLess(m.ctx, best.ctx) {
+				best = m
+			}
+		}
+	}
+	return best.ctx
+}
+
+// probeStale 在后台重新测量过期成员的 RTT，调用方需持有 g.mu
+func (g *serviceGroup) probeStale() {
+	now := time.Now()
+	for _, m := range g.members {
+		if now.Sub(m.probedAt) < groupProbeInterval {
+			continue
+		}
+		m.probedAt = now
+		go m.ctx.ping(time.Second * 3)
+	}
+}
+
+func rttLess(a, b *Context) bool {
+	ra := atomic.LoadInt64(&a.Stats.LastRTT)
+	rb := atomic.LoadInt64(&b.Stats.LastRTT)
+	if ra == 0 || rb == 0 {
+		return ra != 0
+	}
+	return ra < rb
+}
+
+func (g *serviceGroup) info() GroupInfo {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	info := GroupInfo{Name: g.name, Policy: g.policy.String(), Members: make([]string, 0, len(g.members))}
+	for _, m := range g.members {
+		info.Members = append(info.Members, m.ctx.Domain)
+	}
+	return info
+}
+
+// joinGroup 将 ctx 加入服务组，服务组不存在时创建。组名不能与已有域名相同。
+func (r *contextRegistry) joinGroup(name string, ctx *Context) error {
+	name, err := admit.NormalizeName(name)
+	if err != nil {
+		return err
+	}
+	if _, err := r.lookupByDomain(name); err == nil {
+		return errGroupNameIsTaken
+	}
+
+	r.groupsMu.Lock()
+	defer r.groupsMu.Unlock()
+	// detach 先释放 ctx 再清理服务组，在锁内检查可以保证不会留下已下线的成员
+	if !ctx.isAttached() {
+		return errNilContextConn
+	}
+	g, ok := r.groups[name]
+	if !ok {
+		g = &serviceGroup{name: name, policy: r.groupPolicies[name]}
+		r.groups[name] = g
+	}
+	g.add(ctx)
+	r.logger.Info("context joined group", "ctx_id", ctx.id, "domain", ctx.Domain, "group", name)
+	return nil
+}
+
+// leaveGroup 将 ctx 移出服务组，服务组为空时删除
+func (r *contextRegistry) leaveGroup(name string, ctx *Context) error {
+	name, err := admit.NormalizeName(name)
+	if err != nil {
+		return err
+	}
+
+	r.groupsMu.Lock()
+	defer r.groupsMu.Unlock()
+	g, ok := r.groups[name]
+	if !ok {
+		return errGroupNotFound
+	}
+	if g.remove(ctx) == 0 {
+		delete(r.groups, name)
+	}
+	return nil
+}
+
+// leaveAllGroups 将 ctx 移出所有服务组（ctx 下线时调用）
+func (r *contextRegistry) leaveAllGroups(ctx *Context) {
+	r.groupsMu.Lock()
+	defer r.groupsMu.Unlock()
+	for name, g := range r.groups {
+		if g.remove(ctx) == 0 {
+			delete(r.groups, name)
+		}
+	}
+}
+
+// lookupGroup 按服务组的策略选出一个成员
+func (r *contextRegistry) lookupGroup(name string) (*Context, error) {
+	name, err := admit.NormalizeName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	r.groupsMu.Lock()
+	g, ok := r.groups[name]
+	r.groupsMu.Unlock()
+	if !ok {
+		return nil, errGroupNotFound
+	}
+	ctx := g.pick()
+	if ctx == nil {
+		return nil, errGroupNotFound
+	}
+	return ctx, nil
+}
+
+func (r *contextRegistry) setGroupPolicy(name string, policy GroupPolicy) error {
+	name, err := admit.NormalizeName(name)
+	if err != nil {
+		return err
+	}
+
+	r.groupsMu.Lock()
+	defer r.groupsMu.Unlock()
+	r.groupPolicies[name] = policy
+	if g, ok := r.groups[name]; ok {
+		g.mu.Lock()
+		g.policy = policy
+		g.mu.Unlock()
+	}
+	return nil
+}
+
+func (r *contextRegistry) groupInfos() []GroupInfo {
+	r.groupsMu.Lock()
+	defer r.groupsMu.Unlock()
+	infos := make([]GroupInfo, 0, len(r.groups))
+	for _, g := range r.groups {
+		infos = append(infos, g.info())
+	}
+	return infos
+}
+
+// SetGroupPolicy sets how members of a service group are selected. It applies
+// to the group immediately and to groups created later with the same name.
+// Groups default to GroupRoundRobin.
+func (s *Server) SetGroupPolicy(group string, policy GroupPolicy) error {
+	return s.registry.setGroupPolicy(group, policy)
+}
+
+// GetGroups returns the service groups with at least one member.
+func (s *Server) GetGroups() []GroupInfo {
+	return s.registry.groupInfos()
+}
+
+func (s *Server) handleGroupJoin(caller *Context, msg *packet.Message) (any, error) {
+	var req packet.GroupRequest
+	if err := msg.Unmarshal(&req); err != nil {
+		return nil, err
+	}
+	return nil, s.registry.joinGroup(req.Group, caller)
+}
+
+func (s *Server) handleGroupLeave(caller *Context, msg *packet.Message) (any, error) {
+	var req packet.GroupRequest
+	if err := msg.Unmarshal(&req); err != nil {
+		return nil, err
+	}
+	return nil, s.registry.leaveGroup(req.Group, caller)
+}
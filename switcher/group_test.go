@@ -0,0 +1,204 @@
+package switcher
+
+import (
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/net-agent/flex/v3/node"
+	"github.com/net-agent/flex/v3/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+// serveDomain 在 port 上监听，对每个连接回复节点自身的域名
+func serveDomain(t *testing.T, n *node.Node, port uint16) {
+	t.Helper()
+	l, err := n.Listen(port)
+	if !assert.Nil(t, err) {
+		return
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Write([]byte(n.GetDomain()))
+			c.Close()
+		}
+	}()
+}
+
+func dialDomain(t *testing.T, n *node.Node, addr string) string {
+	t.Helper()
+	c, err := n.Dial(addr)
+	if !assert.Nil(t, err) {
+		return ""
+	}
+	defer c.Close()
+	buf, _ := io.ReadAll(c)
+	return string(buf)
+}
+
+func initGroup(t *testing.T, members ...string) (*Server, *node.Node, []*node.Node) {
+	t.Helper()
+	s := NewServer("testpswd", nil, nil)
+	client := connectTestNode(t, s, "client")
+	nodes := make([]*node.Node, 0, len(members))
+	for _, domain := range members {
+		n := connectTestNode(t, s, domain)
+		serveDomain(t, n, 80)
+		assert.Nil(t, n.JoinGroup("svc"))
+		nodes = append(nodes, n)
+	}
+	return s, client, nodes
+}
+
+func TestGroupRoundRobin(t *testing.T) {
+	s, client, _ := initGroup(t, "a", "b", "c")
+
+	counts := map[string]int{}
+	for i := 0; i < 6; i++ {
+		counts[dialDomain(t, client, "svc:80")]++
+	}
+	assert.Equal(t, map[string]int{"a": 2, "b": 2, "c": 2}, counts)
+
+	_, err := client.PingDomain("svc", time.Second)
+	assert.Nil(t, err)
+
+	groups := s.GetGroups()
+	if assert.Equal(t, 1, len(groups)) {
+		assert.Equal(t, "svc", groups[0].Name)
+		assert.Equal(t, "round-robin", groups[0].Policy)
+		assert.ElementsMatch(t, []string{"a", "b", "c"}, groups[0].Members)
+	}
+}
+
+func TestGroupLeastStreams(t *testing.T) {
+	s, client, nodes := initGroup(t, "a", "b")
+	assert.Nil(t, s.SetGroupPolicy("svc", GroupLeastStreams))
+	serveEcho(t, nodes[0], 81)
+	serveEcho(t, nodes[1], 81)
+
+	// 第一个连接保持打开，之后的连接都应分配给另一个成员
+	first, err := client.Dial("svc:81")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer first.Close()
+	busy, _ := s.registry.lookupByDomain("a")
+	if atomic.LoadInt32(&busy.Stats.StreamCount) == 0 {
+		busy, _ = s.registry.lookupByDomain("b")
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&busy.Stats.StreamCount))
+
+	for i := 0; i < 3; i++ {
+		got := dialDomain(t, client, "svc:80")
+		assert.NotEqual(t, busy.Domain, got)
+	}
+}
+
+func TestGroupLeastStreamsPendingOpens(t *testing.T) {
+	a := NewContext(1, nil, "a", "", nil)
+	b := NewContext(2, nil, "b", "", nil)
+	g := &serviceGroup{name: "svc", policy: GroupLeastStreams, members: []*groupMember{{ctx: a}, {ctx: b}}}
+
+	// 已转发但尚未应答的 open 也计入负载，突发的连接不会落在同一个成员上
+	for i := 0; i < 4; i++ {
+		g.pick().addPendingOpen()
+	}
+	assert.Equal(t, int32(2), a.load())
+	assert.Equal(t, int32(2), b.load())
+
+	// 失败的应答撤销记录，成功的应答转为 stream
+	fail := packet.OpenStreamACK{Code: packet.CodeAccessDenied, Error: "denied"}
+	ok := packet.OpenStreamACK{OK: true, WindowSize: 1}
+	ack := packet.NewBufferWithCmd(packet.AckOpenStream)
+	ack.SetPayload(fail.Encode())
+	a.recordIncoming(ack)
+	a.recordIncoming(ack)
+	ack.SetPayload(ok.Encode())
+	b.recordIncoming(ack)
+	assert.Equal(t, int32(0), a.load())
+	assert.Equal(t, int32(2), b.load())
+	assert.Equal(t, a, g.pick())
+
+	// 没有记录时应答不会让计数变为负数
+	a.recordIncoming(ack)
+	a.donePendingOpen()
+	assert.Equal(t, int32(1), a.load())
+}
+
+func TestGroupLowestRTT(t *testing.T) {
+	a := NewContext(1, nil, "a", "", nil)
+	b := NewContext(2, nil, "b", "", nil)
+	c := NewContext(3, nil, "c", "", nil)
+	now := time.Now()
+	g := &serviceGroup{name: "svc", policy: GroupLowestRTT, members: []*groupMember{
+		{ctx: a, probedAt: now}, {ctx: b, probedAt: now}, {ctx: c, probedAt: now},
+	}}
+
+	// 未测得 RTT 的成员排在最后
+	atomic.StoreInt64(&b.Stats.LastRTT, int64(20*time.Millisecond))
+	atomic.StoreInt64(&c.Stats.LastRTT, int64(10*time.Millisecond))
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, c, g.pick())
+	}
+
+	atomic.StoreInt64(&a.Stats.LastRTT, int64(5*time.Millisecond))
+	assert.Equal(t, a, g.pick())
+}
+
+func TestGroupMembership(t *testing.T) {
+	s, client, nodes := initGroup(t, "a", "b")
+
+	// 组名不能与已有域名冲突
+	assert.NotNil(t, nodes[0].JoinGroup("client"))
+
+	// 主动退出
+	assert.Nil(t, nodes[0].LeaveGroup("svc"))
+	assert.NotNil(t, nodes[0].LeaveGroup("nogroup"))
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, "b", dialDomain(t, client, "svc:80"))
+	}
+
+	// 成员断开后自动移除，服务组为空时删除
+	nodes[1].Close()
+	assert.Eventually(t, func() bool {
+		return len(s.GetGroups()) == 0
+	}, time.Second, 10*time.Millisecond)
+	_, err := client.Dial("svc:80")
+	assert.NotNil(t, err)
+}
+
+func TestRecordOpenStreamAck(t *testing.T) {
+	pc1, pc2 := packet.Pipe()
+	go func() {
+		for {
+			if _, err := pc2.ReadBuffer(); err != nil {
+				return
+			}
+		}
+	}()
+	ctx := NewContext(1, pc1, "test", "", nil)
+
+	// 接受连接计入被叫方
+	ack := packet.OpenStreamACK{OK: true}
+	pbuf := packet.NewBufferWithCmd(packet.AckOpenStream)
+	_ = pbuf.SetPayload(ack.Encode())
+	ctx.recordIncoming(pbuf)
+	assert.Equal(t, int32(1), ctx.Stats.StreamCount)
+
+	// 对端回复 CloseAck 时撤销
+	ctx.recordIncoming(packet.NewBufferWithCmd(packet.AckCloseStream))
+	assert.Equal(t, int32(0), ctx.Stats.StreamCount)
+
+	// 发起方收到拒绝应答时撤销
+	ctx.recordIncoming(packet.NewBufferWithCmd(packet.CmdOpenStream))
+	ack = packet.OpenStreamACK{Error: "rejected"}
+	pbuf = packet.NewBufferWithCmd(packet.AckOpenStream)
+	_ = pbuf.SetPayload(ack.Encode())
+	assert.Nil(t, ctx.writeBuffer(pbuf))
+	assert.Equal(t, int32(0), ctx.Stats.StreamCount)
+}
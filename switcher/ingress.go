@@ -0,0 +1,139 @@
+package switcher
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	errIngressNoRoute = errors.New("no ingress route for host")
+)
+
+// DefaultIngressPort 是按 "<domain>.<base-host>" 路由时目标节点的默认端口
+const DefaultIngressPort = 80
+
+type ingressTargetKey struct{}
+
+// Ingress is an HTTP front end that proxies requests to flex nodes by Host
+// header. A host is routed by the explicit table first, then as
+// "<domain>.<base-host>" to DefaultPort of the node. Streams to nodes are kept
+// alive between requests, X-Forwarded-* headers are added and WebSocket
+// upgrades pass through.
+type Ingress struct {
+	s           *Server
+	baseHost    string
+	DefaultPort uint16
+
+	mu     sync.RWMutex
+	routes map[string]string // host -> "domain:port"
+
+	proxy     *httputil.ReverseProxy
+	transport *http.Transport
+}
+
+// NewIngress creates an ingress of s. baseHost may be empty, in which case
+// only the routing table is used.
+func NewIngress(s *Server, baseHost string) *Ingress {
+	in := &Ingress{
+		s:           s,
+		baseHost:    normalizeHost(baseHost),
+		DefaultPort: DefaultIngressPort,
+		routes:      make(map[string]string),
+	}
+	in.transport = &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			st, err := s.DialContext(ctx, addr)
+			if err != nil {
+				return nil, err
+			}
+			return st, nil
+		},
+		MaxIdleConnsPerHost: 16,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	in.proxy = &httputil.ReverseProxy{
+		Rewrite:      in.rewrite,
+		Transport:    in.transport,
+		ErrorHandler: in.handleError,
+	}
+	return in
+}
+
+// Route maps host to target ("domain:port" or "ip:port"), overriding the
+// base-host rule.
+func (in *Ingress) Route(host, target string) error {
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		return err
+	}
+	in.mu.Lock()
+	in.routes[normalizeHost(host)] = target
+	in.mu.Unlock()
+	return nil
+}
+
+// RemoveRoute removes the route of host.
+func (in *Ingress) RemoveRoute(host string) {
+	in.mu.Lock()
+	delete(in.routes, normalizeHost(host))
+	in.mu.Unlock()
+}
+
+// Close closes the idle streams kept for reuse.
+func (in *Ingress) Close() {
+	in.transport.CloseIdleConnections()
+}
+
+// lookup returns the flex address serving host.
+func (in *Ingress) lookup(host string) (string, error) {
+	host = normalizeHost(host)
+	in.mu.RLock()
+	target, ok := in.routes[host]
+	in.mu.RUnlock()
+	if ok {
+		return target, nil
+	}
+
+	if in.baseHost != "" {
+		if domain, ok := strings.CutSuffix(host, "."+in.baseHost); ok && domain != "" {
+			return net.JoinHostPort(domain, strconv.Itoa(int(in.DefaultPort))), nil
+		}
+	}
+	return "", errIngressNoRoute
+}
+
+func (in *Ingress) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	target, err := in.lookup(r.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	in.proxy.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ingressTargetKey{}, target)))
+}
+
+func (in *Ingress) rewrite(pr *httputil.ProxyRequest) {
+	target := pr.In.Context().Value(ingressTargetKey{}).(string)
+	pr.SetURL(&url.URL{Scheme: "http", Host: target})
+	pr.Out.Host = pr.In.Host // 保留原始 Host，后端据此生成链接
+	pr.SetXForwarded()
+}
+
+func (in *Ingress) handleError(w http.ResponseWriter, r *http.Request, err error) {
+	in.s.logger.Warn("ingress proxy failed", "host", r.Host, "path", r.URL.Path, "error", err)
+	w.WriteHeader(http.StatusBadGateway)
+}
+
+// normalizeHost 去掉端口并转为小写
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
@@ -0,0 +1,113 @@
+package switcher
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/net-agent/flex/v3/node"
+	"github.com/stretchr/testify/assert"
+)
+
+// serveHTTP 在节点端口上运行 http 服务，应答请求的 Host 与转发头；/ws 升级后原样回显
+// 返回值为已建立的连接数
+func serveHTTP(t *testing.T, n *node.Node, port uint16) *atomic.Int32 {
+	var conns atomic.Int32
+	l, err := n.Listen(port)
+	if !assert.Nil(t, err) {
+		return &conns
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%v %v %v %v", n.GetDomain(), r.Host, r.Header.Get("X-Forwarded-Host"), r.Header.Get("X-Forwarded-For") != "")
+	})
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+		rw.Flush()
+		io.Copy(conn, rw)
+	})
+	srv := &http.Server{Handler: mux, ConnState: func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}}
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+	return &conns
+}
+
+func TestIngress(t *testing.T) {
+	s := NewServer("testpswd", nil, nil)
+	web := connectTestNode(t, s, "web")
+	conns := serveHTTP(t, web, 80)
+	serveHTTP(t, web, 8080)
+
+	in := NewIngress(s, "flex.local")
+	defer in.Close()
+	assert.Nil(t, in.Route("admin.example.com", "web:8080"))
+	assert.NotNil(t, in.Route("bad", "web"))
+	hs := httptest.NewServer(in)
+	defer hs.Close()
+
+	get := func(host string) (int, string) {
+		req, _ := http.NewRequest("GET", hs.URL+"/", nil)
+		req.Host = host
+		resp, err := http.DefaultClient.Do(req)
+		if !assert.Nil(t, err) {
+			return 0, ""
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	code, body := get("web.flex.local")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "web web.flex.local web.flex.local true", body)
+
+	// keep-alive：同一目标的请求复用 stream
+	get("WEB.flex.local:8000")
+	assert.Equal(t, int32(1), conns.Load())
+
+	code, body = get("admin.example.com")
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, strings.HasPrefix(body, "web admin.example.com"))
+
+	code, _ = get("unknown.example.com")
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = get("nobody.flex.local")
+	assert.Equal(t, http.StatusBadGateway, code)
+
+	in.RemoveRoute("admin.example.com")
+	code, _ = get("admin.example.com")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	// websocket 升级后双向透传
+	conn, err := net.Dial("tcp", hs.Listener.Addr().String())
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET /ws HTTP/1.1\r\nHost: web.flex.local\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	io.WriteString(conn, "hello\n")
+	line, err := br.ReadString('\n')
+	assert.Nil(t, err)
+	assert.Equal(t, "hello\n", line)
+}
@@ -19,6 +19,7 @@ var (
 	errDomainNotFound         = errors.New("domain not found")
 	errInvalidContextIP       = errors.New("invalid context ip")
 	errContextIPNotFound      = errors.New("context ip not found")
+	errDomainExist            = errors.New("domain exist")
 )
 
 type contextRegistry struct {
@@ -33,6 +34,22 @@ type contextRegistry struct {
 
 	recordsMu sync.Mutex
 	records   []*Context
+
+	groupsMu      sync.Mutex
+	groups        map[string]*serviceGroup
+	groupPolicies map[string]GroupPolicy
+
+	selectNext uint32 // 选择器匹配多个节点时轮流选择
+
+	// onAttach/onDetach are invoked after a context enters or leaves the
+	// indexes, without any registry lock held. They are not leaf callbacks:
+	// onDetach may re-enter the registry (restoreRemote → attachRemote →
+	// onAttach, detachChildren → detach), and onAttach of a remote context runs
+	// under the owning peerLink.mu (attachAlias). Hooks must therefore not hold
+	// registry locks or peerLink.mu while calling back into the registry, and
+	// callers of attach/attachRemote/detach must not hold registry locks.
+	onAttach func(ctx *Context)
+	onDetach func(ctx *Context)
 }
 
 func newContextRegistry(ipm *idpool.Pool, logger *slog.Logger) *contextRegistry {
@@ -44,19 +61,31 @@ func newContextRegistry(ipm *idpool.Pool, logger *slog.Logger) *contextRegistry
 		logger:      logger,
 		domainIndex: make(map[string]*Context),
 		ipIndex:     make(map[uint16]*Context),
+
+		groups:        make(map[string]*serviceGroup),
+		groupPolicies: make(map[string]GroupPolicy),
 	}
 }
 
 func (r *contextRegistry) attach(ctx *Context) error {
-	normalized, err := admit.NormalizeDomain(ctx.Domain)
+	normalized, err := admit.NormalizeName(ctx.Domain)
 	if err != nil {
 		r.logger.Warn("attach failed: invalid domain", "ctx_id", ctx.id, "domain", ctx.Domain)
 		return err
 	}
 	ctx.Domain = normalized
 
+	// 先分配 IP 再公开域名，按域名查到的 ctx 总是带有 IP
+	ip, err := r.ipm.Allocate()
+	if err != nil {
+		r.logger.Warn("attach failed: IP exhausted", "ctx_id", ctx.id, "domain", ctx.Domain, "error", err)
+		return errGetFreeContextIPFailed
+	}
+	ctx.IP = ip
+
 	prev, acquired := r.acquireDomain(ctx)
 	if !acquired {
+		r.ipm.Release(ip)
 		r.logger.Warn("attach failed: replace domain failed", "ctx_id", ctx.id, "domain", ctx.Domain)
 		return errReplaceDomainFailed
 	}
@@ -66,13 +95,6 @@ func (r *contextRegistry) attach(ctx *Context) error {
 		r.ipm.Release(prev.IP)
 	}
 
-	ip, err := r.ipm.Allocate()
-	if err != nil {
-		r.logger.Warn("attach failed: IP exhausted", "ctx_id", ctx.id, "domain", ctx.Domain, "error", err)
-		return errGetFreeContextIPFailed
-	}
-	ctx.IP = ip
-
 	r.ipMu.Lock()
 	if _, exists := r.ipIndex[ctx.IP]; exists {
 		r.ipMu.Unlock()
@@ -89,6 +111,52 @@ func (r *contextRegistry) attach(ctx *Context) error {
 
 	ctx.AttachTime = time.Now()
 	r.logger.Info("context attached", "ctx_id", ctx.id, "domain", ctx.Domain, "ip", ctx.IP, "mac", ctx.Mac)
+	if r.onAttach != nil {
+		r.onAttach(ctx)
+	}
+	return nil
+}
+
+// attachRemote indexes a context reached through another switcher.
+// Unlike attach, it never displaces an existing holder of the domain:
+// locally connected nodes always take precedence over remote ones.
+// A context without domain is only reachable by IP.
+func (r *contextRegistry) attachRemote(ctx *Context) error {
+	if ctx.Domain != "" {
+		normalized, err := admit.NormalizeName(ctx.Domain)
+		if err != nil {
+			return err
+		}
+		ctx.Domain = normalized
+	}
+
+	ip, err := r.ipm.Allocate()
+	if err != nil {
+		return errGetFreeContextIPFailed
+	}
+	ctx.AttachTime = time.Now()
+
+	r.domainMu.Lock()
+	if ctx.Domain != "" {
+		if _, exists := r.domainIndex[ctx.Domain]; exists {
+			r.domainMu.Unlock()
+			r.ipm.Release(ip)
+			return errDomainExist
+		}
+		r.domainIndex[ctx.Domain] = ctx
+	}
+	ctx.IP = ip
+	r.domainMu.Unlock()
+
+	r.ipMu.Lock()
+	r.ipIndex[ctx.IP] = ctx
+	r.ipMu.Unlock()
+
+	ctx.setAttached(true)
+	r.logger.Info("remote context attached", "ctx_id", ctx.id, "domain", ctx.Domain, "ip", ctx.IP)
+	if r.onAttach != nil {
+		r.onAttach(ctx)
+	}
 	return nil
 }
 
@@ -108,10 +176,13 @@ func (r *contextRegistry) acquireDomain(newCtx *Context) (prev *Context, ok bool
 	}
 	r.domainMu.Unlock()
 
-	// Ping outside the lock to avoid holding it during network I/O
-	_, err := existing.ping(time.Second * 3)
-	if err == nil {
-		return existing, false
+	// Remote contexts never win against a local node, so skip the liveness check.
+	if !existing.IsRemote() {
+		// Ping outside the lock to avoid holding it during network I/O
+		_, err := existing.ping(time.Second * 3)
+		if err == nil {
+			return existing, false
+		}
 	}
 
 	// Ping failed — re-lock and verify the domain still points to the same context
@@ -152,14 +223,18 @@ func (r *contextRegistry) detach(ctx *Context) {
 	r.ipMu.Unlock()
 
 	ctx.release()
+	r.leaveAllGroups(ctx)
 	r.ipm.Release(ctx.IP)
 
 	duration := ctx.DetachTime.Sub(ctx.AttachTime)
 	r.logger.Info("context detached", "ctx_id", ctx.id, "domain", ctx.Domain, "duration", duration)
+	if r.onDetach != nil {
+		r.onDetach(ctx)
+	}
 }
 
 func (r *contextRegistry) lookupByDomain(domain string) (*Context, error) {
-	domain, err := admit.NormalizeDomain(domain)
+	domain, err := admit.NormalizeName(domain)
 	if err != nil {
 		return nil, err
 	}
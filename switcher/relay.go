@@ -0,0 +1,413 @@
+package switcher
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/net-agent/flex/v3/internal/selector"
+	"github.com/net-agent/flex/v3/node"
+	"github.com/net-agent/flex/v3/packet"
+)
+
+var (
+	errRelayedConnNoRead     = errors.New("relayed conn is write only")
+	errRelayNotAllowed       = errors.New("relay registration not allowed")
+	errRelaySrcNotRegistered = errors.New("relay source not registered upstream")
+)
+
+const (
+	topicRelayRegister   = "relay.register"
+	topicRelayUnregister = "relay.unregister"
+
+	// maxRelayChildBacklog 上游发给一个子节点、尚未交给它的数据包上限
+	maxRelayChildBacklog = 4096
+)
+
+// RelayAliasIdleTimeout 上游对端的别名在没有 stream、且超过该时间没有数据包后释放
+var RelayAliasIdleTimeout = 5 * time.Minute
+
+// relayRegistration 是 relay.register/relay.unregister 的请求与应答。
+// 请求中 Domain 为子节点在中继上的名称；应答中为上游分配的完整名称与 IP。
+type relayRegistration struct {
+	Domain string            `json:"domain"`
+	IP     uint16            `json:"ip,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// relayedConn is the packet.Conn of a child registered through a relay node.
+// Packets are written unchanged to the relay's connection, which delivers them
+// to the child by DistIP.
+type relayedConn struct {
+	parent *Context
+}
+
+func (c *relayedConn) WriteBuffer(buf *packet.Buffer) error {
+	pc := c.parent.getConn()
+	if pc == nil {
+		return errNilContextConn
+	}
+	return pc.WriteBuffer(buf)
+}
+
+func (c *relayedConn) ReadBuffer() (*packet.Buffer, error) { return nil, errRelayedConnNoRead }
+func (c *relayedConn) SetReadTimeout(time.Duration) error  { return nil }
+func (c *relayedConn) SetWriteTimeout(time.Duration)       {}
+func (c *relayedConn) Close() error                        { return nil } // 连接归父 Context 所有
+func (c *relayedConn) GetRawConn() net.Conn {
+	if pc := c.parent.getConn(); pc != nil {
+		return pc.GetRawConn()
+	}
+	return nil
+}
+
+// handleRelayRegister 为中继节点的子节点注册子域名 "<child>.<relay>"
+func (s *Server) handleRelayRegister(caller *Context, msg *packet.Message) (any, error) {
+	if caller.IsRemote() {
+		return nil, errRelayNotAllowed
+	}
+	var req relayRegistration
+	if err := msg.Unmarshal(&req); err != nil {
+		return nil, err
+	}
+	if err := selector.ValidateLabels(req.Labels); err != nil {
+		return nil, err
+	}
+
+	id := int(atomic.AddInt32(&s.nextCtxID, 1))
+	child := NewContext(id, &relayedConn{parent: caller}, req.Domain+"."+caller.Domain, "", s.ctxLogger)
+	child.parent = caller
+	child.Labels = req.Labels
+	if err := s.registry.attach(child); err != nil {
+		child.release()
+		return nil, err
+	}
+
+	// 中继节点可能在注册过程中断开
+	if !caller.isAttached() {
+		s.registry.detach(child)
+		return nil, errNilContextConn
+	}
+	s.onContextServing(child)
+	return relayRegistration{Domain: child.Domain, IP: child.IP}, nil
+}
+
+// handleRelayUnregister 注销中继节点的子域名
+func (s *Server) handleRelayUnregister(caller *Context, msg *packet.Message) (any, error) {
+	var req relayRegistration
+	if err := msg.Unmarshal(&req); err != nil {
+		return nil, err
+	}
+	child, err := s.registry.lookupByDomain(req.Domain + "." + caller.Domain)
+	if err != nil || child.parent != caller {
+		return nil, errDomainNotFound
+	}
+	s.registry.detach(child)
+	return nil, nil
+}
+
+// detachChildren 中继节点下线时注销其所有子域名
+func (s *Server) detachChildren(parent *Context) {
+	for _, ctx := range s.registry.activeContexts() {
+		if ctx != nil && ctx.parent == parent {
+			s.registry.detach(ctx)
+		}
+	}
+}
+
+// Relay runs a switcher for local child nodes on top of a node connected to an
+// upstream switcher. Children are registered upstream as "<child>.<node domain>"
+// and their traffic to the rest of the network shares the node's connection.
+// Relays can be nested to build a tree.
+//
+// Addresses are translated at the relay:
+//   - every child owns an upstream IP, assigned when it is registered
+//   - every upstream peer a child talks to gets a local alias context
+//   - downstream: DistIP upstream IP -> child IP, SrcIP peer IP -> alias IP
+//   - upstream: DistIP alias IP -> peer IP, SrcIP child IP -> upstream IP
+//
+// Domains unknown to the relay are resolved by the upstream switcher. When the
+// upstream node is closed the children stay connected but are only reachable
+// locally; create a new Relay to attach them to a new upstream.
+type Relay struct {
+	*Server
+	upstream *node.Node
+
+	mu       sync.Mutex
+	children map[uint16]*relayChild // upstream ip -> child
+	uplinks  map[*Context]uint16    // child -> upstream ip
+	aliases  map[uint16]*relayAlias // upstream peer ip -> alias
+}
+
+// relayChild 是注册到上游的子节点。上游发给它的数据包先进入它自己的队列，由单独的
+// goroutine 按顺序交给子节点，慢的子节点不会阻塞上游读循环和其他子节点
+type relayChild struct {
+	ctx *Context
+
+	mu      sync.Mutex
+	queue   []*packet.Buffer
+	running bool
+}
+
+// push 把数据包排入队列后立即返回。队列积压超过 maxRelayChildBacklog 时丢弃数据包
+func (c *relayChild) push(pbuf *packet.Buffer, logger *slog.Logger) {
+	c.mu.Lock()
+	if len(c.queue) >= maxRelayChildBacklog {
+		c.mu.Unlock()
+		logger.Warn("relay child queue full, dropping packet", "domain", c.ctx.Domain, "cmd", pbuf.CmdName())
+		return
+	}
+	c.queue = append(c.queue, pbuf)
+	if c.running {
+		c.mu.Unlock()
+		return
+	}
+	c.running = true
+	c.mu.Unlock()
+
+	go c.run(logger)
+}
+
+func (c *relayChild) run(logger *slog.Logger) {
+	for {
+		c.mu.Lock()
+		if len(c.queue) == 0 {
+			c.queue = nil
+			c.running = false
+			c.mu.Unlock()
+			return
+		}
+		pbuf := c.queue[0]
+		c.queue[0] = nil
+		c.queue = c.queue[1:]
+		c.mu.Unlock()
+
+		if err := c.ctx.enqueueForward(pbuf); err != nil {
+			logger.Warn("relay forward failed", "dist_ip", c.ctx.IP, "domain", c.ctx.Domain, "error", err)
+		}
+	}
+}
+
+// relayAlias 是上游对端在本地的别名
+type relayAlias struct {
+	ctx  *Context
+	conn *upstreamConn
+}
+
+func NewRelay(upstream *node.Node, password string, logger *slog.Logger, logCfg *LogConfig) *Relay {
+	r := &Relay{
+		Server:   NewServer(password, logger, logCfg),
+		upstream: upstream,
+		children: make(map[uint16]*relayChild),
+		uplinks:  make(map[*Context]uint16),
+		aliases:  make(map[uint16]*relayAlias),
+	}
+	r.Server.relay = r
+	r.router.fallback = r.forwardUpstream
+	upstream.SetRelayHandler(r.handleDownstream)
+	go r.watchUpstream()
+	go r.sweepAliases()
+	return r
+}
+
+// register 将子节点注册到上游
+func (r *Relay) register(child *Context) {
+	var resp relayRegistration
+	err := r.upstream.Request(topicRelayRegister, relayRegistration{Domain: child.Domain, Labels: child.Labels}, &resp, node.DefaultRequestTimeout)
+	if err != nil {
+		r.logger.Warn("register child upstream failed", "domain", child.Domain, "error", err)
+		return
+	}
+
+	r.mu.Lock()
+	r.children[resp.IP] = &relayChild{ctx: child}
+	r.uplinks[child] = resp.IP
+	r.mu.Unlock()
+	r.logger.Info("child registered upstream", "domain", child.Domain, "name", resp.Domain, "upstream_ip", resp.IP)
+
+	if !child.isAttached() {
+		r.unregister(child)
+	}
+}
+
+// unregister 从上游注销子节点
+func (r *Relay) unregister(child *Context) {
+	r.mu.Lock()
+	ip, ok := r.uplinks[child]
+	if ok {
+		delete(r.uplinks, child)
+		if c := r.children[ip]; c != nil && c.ctx == child {
+			delete(r.children, ip)
+		}
+	}
+	r.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	go func() {
+		err := r.upstream.Request(topicRelayUnregister, relayRegistration{Domain: child.Domain}, nil, node.DefaultRequestTimeout)
+		if err != nil {
+			r.logger.Warn("unregister child upstream failed", "domain", child.Domain, "error", err)
+		}
+	}()
+}
+
+// upstreamIP 返回本地 IP 对应子节点的上游 IP
+func (r *Relay) upstreamIP(localIP uint16) (uint16, bool) {
+	child, err := r.registry.lookupByIP(localIP)
+	if err != nil {
+		return 0, false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ip, ok := r.uplinks[child]
+	return ip, ok
+}
+
+// aliasFor 返回上游对端 IP 在本地的别名，不存在时创建
+func (r *Relay) aliasFor(peerIP uint16) (*Context, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if alias := r.aliases[peerIP]; alias != nil {
+		alias.conn.touch()
+		return alias.ctx, nil
+	}
+
+	id := int(atomic.AddInt32(&r.nextCtxID, 1))
+	conn := &upstreamConn{relay: r, ip: peerIP}
+	conn.touch()
+	ctx := NewContext(id, conn, "", "", r.ctxLogger)
+	ctx.upstream = r
+	if err := r.registry.attachRemote(ctx); err != nil {
+		ctx.release()
+		return nil, err
+	}
+	r.aliases[peerIP] = &relayAlias{ctx: ctx, conn: conn}
+	return ctx, nil
+}
+
+// sweepAliases 定期释放空闲的别名，避免长期运行的中继不断占用 IP
+func (r *Relay) sweepAliases() {
+	ticker := time.NewTicker(max(RelayAliasIdleTimeout/2, time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.upstream.Done():
+			return
+		case <-ticker.C:
+			r.releaseIdleAliases(time.Now().Add(-RelayAliasIdleTimeout))
+		}
+	}
+}
+
+// releaseIdleAliases 释放没有 stream 且 before 之后没有数据包的别名
+func (r *Relay) releaseIdleAliases(before time.Time) {
+	var idle []*Context
+	r.mu.Lock()
+	for ip, alias := range r.aliases {
+		if atomic.LoadInt32(&alias.ctx.Stats.StreamCount) > 0 || alias.conn.lastActive.Load() > before.UnixNano() {
+			continue
+		}
+		delete(r.aliases, ip)
+		idle = append(idle, alias.ctx)
+	}
+	r.mu.Unlock()
+
+	for _, ctx := range idle {
+		r.registry.detach(ctx)
+	}
+}
+
+// forwardUpstream 将本地无法解析的请求（OpenStream、PingDomain）转交给上游 switcher
+func (r *Relay) forwardUpstream(caller *Context, pbuf *packet.Buffer) bool {
+	ip, ok := r.upstreamIP(pbuf.SrcIP())
+	if !ok {
+		return false
+	}
+	pbuf.SetSrcIP(ip)
+	if err := r.upstream.WriteBuffer(pbuf); err != nil {
+		r.router.logger.Warn("forward upstream failed", "ctx_id", caller.id, "domain", caller.Domain, "error", err)
+	}
+	return true
+}
+
+// handleDownstream 处理上游发给子节点的数据包（在上游节点的读循环中调用）
+func (r *Relay) handleDownstream(pbuf *packet.Buffer) {
+	r.mu.Lock()
+	c := r.children[pbuf.DistIP()]
+	r.mu.Unlock()
+	if c == nil {
+		r.router.logger.Warn("relay dist ip not registered", "dist_ip", pbuf.DistIP(), "cmd", pbuf.CmdName())
+		return
+	}
+
+	if src := pbuf.SrcIP(); src != packet.LocalIP && src != packet.SwitcherIP {
+		alias, err := r.aliasFor(src)
+		if err != nil {
+			r.router.logger.Warn("relay alias failed", "src_ip", src, "error", err)
+			return
+		}
+		alias.recordIncoming(pbuf)
+		pbuf.SetSrcIP(alias.IP)
+	}
+
+	// 上游以完整名称探测子节点，子节点只认识自己在中继上的名称
+	if pbuf.Cmd() == packet.CmdPingDomain {
+		_ = pbuf.SetPayload([]byte(c.ctx.Domain))
+	}
+
+	pbuf.SetDistIP(c.ctx.IP)
+	c.push(pbuf, r.router.logger)
+}
+
+// watchUpstream 上游节点关闭后清理所有上游状态
+func (r *Relay) watchUpstream() {
+	<-r.upstream.Done()
+	r.upstream.SetRelayHandler(nil)
+
+	r.mu.Lock()
+	aliases := make([]*Context, 0, len(r.aliases))
+	for _, alias := range r.aliases {
+		aliases = append(aliases, alias.ctx)
+	}
+	r.children = make(map[uint16]*relayChild)
+	r.uplinks = make(map[*Context]uint16)
+	r.aliases = make(map[uint16]*relayAlias)
+	r.mu.Unlock()
+
+	for _, ctx := range aliases {
+		r.registry.detach(ctx)
+	}
+	r.logger.Info("relay upstream closed", "domain", r.upstream.GetDomain())
+}
+
+// upstreamConn is the packet.Conn of an alias for an upstream peer. It
+// translates both addresses and writes through the upstream node.
+type upstreamConn struct {
+	relay      *Relay
+	ip         uint16
+	lastActive atomic.Int64 // 最近一个数据包的时间（UnixNano）
+}
+
+func (c *upstreamConn) touch() { c.lastActive.Store(time.Now().UnixNano()) }
+
+func (c *upstreamConn) WriteBuffer(buf *packet.Buffer) error {
+	c.touch()
+	src, ok := c.relay.upstreamIP(buf.SrcIP())
+	if !ok {
+		return errRelaySrcNotRegistered
+	}
+	buf.SetSrcIP(src)
+	buf.SetDistIP(c.ip)
+	return c.relay.upstream.WriteBuffer(buf)
+}
+
+func (c *upstreamConn) ReadBuffer() (*packet.Buffer, error) { return nil, errRelayedConnNoRead }
+func (c *upstreamConn) SetReadTimeout(time.Duration) error  { return nil }
+func (c *upstreamConn) SetWriteTimeout(time.Duration)       {}
+func (c *upstreamConn) Close() error                        { return nil } // 上游连接归节点所有
+func (c *upstreamConn) GetRawConn() net.Conn                { return c.relay.upstream.GetRawConn() }
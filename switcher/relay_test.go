@@ -0,0 +1,251 @@
+package switcher
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/net-agent/flex/v3/internal/admit"
+	"github.com/net-agent/flex/v3/node"
+	"github.com/net-agent/flex/v3/packet"
+	"github.com/net-agent/flex/v3/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+func serveEcho(t *testing.T, n *node.Node, port uint16) {
+	t.Helper()
+	l, err := n.Listen(port)
+	if !assert.Nil(t, err) {
+		return
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(c, c)
+		}
+	}()
+}
+
+func assertEcho(t *testing.T, n *node.Node, addr, remoteDomain string) {
+	t.Helper()
+	s, err := n.Dial(addr)
+	if !assert.Nil(t, err, "dial %v", addr) {
+		return
+	}
+	defer s.Close()
+	assert.Equal(t, remoteDomain, s.GetState().RemoteDomain)
+
+	payload := []byte("hello relay")
+	_, err = s.Write(payload)
+	assert.Nil(t, err)
+	buf := make([]byte, len(payload))
+	_, err = io.ReadFull(s, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, payload, buf)
+}
+
+// initRelay 创建上游 switcher 与连接在其上的中继节点 gw
+func initRelay(t *testing.T) (*Server, *node.Node, *Relay) {
+	t.Helper()
+	s := NewServer("testpswd", nil, nil)
+	gw := connectTestNode(t, s, "gw")
+	return s, gw, NewRelay(gw, "testpswd", nil, nil)
+}
+
+func TestRelayDial(t *testing.T) {
+	s, gw, relay := initRelay(t)
+	x := connectTestNode(t, s, "x")
+	c1 := connectTestNode(t, relay.Server, "c1")
+	c2 := connectTestNode(t, relay.Server, "c2")
+	waitDomain(t, s, "c1.gw")
+	waitDomain(t, s, "c2.gw")
+
+	serveEcho(t, x, 80)
+	serveEcho(t, gw, 80)
+	serveEcho(t, c1, 80)
+	serveEcho(t, c2, 80)
+
+	// 上游节点访问子节点
+	assertEcho(t, x, "c1.gw:80", "c1.gw")
+	_, err := x.PingDomain("c1.gw", time.Second)
+	assert.Nil(t, err)
+
+	// 子节点访问上游节点，对端看到的是子节点的完整名称
+	assertEcho(t, c1, "x:80", "x")
+	assertEcho(t, c1, "gw:80", "gw")
+	_, err = c1.PingDomain("x", time.Second)
+	assert.Nil(t, err)
+
+	// 子节点之间直接在中继上互通，也可以绕经上游
+	assertEcho(t, c1, "c2:80", "c2")
+	assertEcho(t, c1, "c2.gw:80", "c2.gw")
+
+	_, err = c1.PingDomain("notexists", time.Second)
+	assert.NotNil(t, err)
+
+	vias := map[string]string{}
+	for _, info := range s.GetClients() {
+		vias[info.Domain] = info.Via
+	}
+	assert.Equal(t, map[string]string{"gw": "", "x": "", "c1.gw": "gw", "c2.gw": "gw"}, vias)
+	assert.Equal(t, 2, len(relay.GetClients()))
+}
+
+func TestRelayAcceptSourceDomain(t *testing.T) {
+	s, _, relay := initRelay(t)
+	x := connectTestNode(t, s, "x")
+	c1 := connectTestNode(t, relay.Server, "c1")
+	waitDomain(t, s, "c1.gw")
+
+	l, err := x.Listen(80)
+	assert.Nil(t, err)
+	go func() {
+		st, err := c1.Dial("x:80")
+		if err == nil {
+			st.Close()
+		}
+	}()
+
+	c, err := l.Accept()
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer c.Close()
+	assert.Equal(t, "c1.gw", c.(*stream.Stream).GetState().RemoteDomain)
+}
+
+func TestRelayNested(t *testing.T) {
+	s, _, relay := initRelay(t)
+	x := connectTestNode(t, s, "x")
+	g2 := connectTestNode(t, relay.Server, "g2")
+	relay2 := NewRelay(g2, "testpswd", nil, nil)
+	c := connectTestNode(t, relay2.Server, "c")
+	waitDomain(t, relay.Server, "c.g2")
+	waitDomain(t, s, "c.g2.gw")
+
+	serveEcho(t, x, 80)
+	serveEcho(t, c, 80)
+	assertEcho(t, x, "c.g2.gw:80", "c.g2.gw")
+	assertEcho(t, c, "x:80", "x")
+}
+
+func TestRelayDetach(t *testing.T) {
+	s, gw, relay := initRelay(t)
+	c1 := connectTestNode(t, relay.Server, "c1")
+	connectTestNode(t, relay.Server, "c2")
+	waitDomain(t, s, "c1.gw")
+	waitDomain(t, s, "c2.gw")
+
+	// 子节点下线后从上游注销
+	c1.Close()
+	assert.Eventually(t, func() bool {
+		_, err := s.registry.lookupByDomain("c1.gw")
+		return err != nil
+	}, time.Second, 10*time.Millisecond)
+
+	// 中继节点下线后上游清理其所有子节点
+	gw.Close()
+	assert.Eventually(t, func() bool {
+		_, err := s.registry.lookupByDomain("c2.gw")
+		return err != nil && len(s.GetClients()) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestRelaySlowUpstreamDoesNotDelayLogin(t *testing.T) {
+	s := NewServer("testpswd", nil, nil)
+	register := s.handleRelayRegister
+	s.router.handleTopic(topicRelayRegister, func(caller *Context, msg *packet.Message) (any, error) {
+		time.Sleep(time.Second)
+		return register(caller, msg)
+	})
+	gw := connectTestNode(t, s, "gw")
+	relay := NewRelay(gw, "testpswd", nil, nil)
+
+	// 子节点的登录不等待上游的注册应答
+	start := time.Now()
+	connectTestNode(t, relay.Server, "c1")
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		_, err := s.registry.lookupByDomain("c1.gw")
+		return err == nil
+	}, 3*time.Second, 10*time.Millisecond)
+}
+
+func TestRelayReleaseIdleAliases(t *testing.T) {
+	s, _, relay := initRelay(t)
+	x := connectTestNode(t, s, "x")
+	c1 := connectTestNode(t, relay.Server, "c1")
+	waitDomain(t, s, "c1.gw")
+	serveEcho(t, c1, 80)
+
+	aliases := func() int {
+		relay.mu.Lock()
+		defer relay.mu.Unlock()
+		return len(relay.aliases)
+	}
+
+	st, err := x.Dial("c1.gw:80")
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, 1, aliases())
+	contexts := len(relay.registry.activeContexts())
+
+	// 有 stream 时保留别名
+	relay.releaseIdleAliases(time.Now().Add(time.Hour))
+	assert.Equal(t, 1, aliases())
+
+	st.Close()
+	assert.Eventually(t, func() bool {
+		relay.releaseIdleAliases(time.Now().Add(time.Hour))
+		return aliases() == 0
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, contexts-1, len(relay.registry.activeContexts()))
+
+	// 释放后再次访问会重新创建别名
+	assertEcho(t, x, "c1.gw:80", "c1.gw")
+	assert.Equal(t, 1, aliases())
+}
+
+func TestRelaySlowChildDoesNotBlockOthers(t *testing.T) {
+	s, _, relay := initRelay(t)
+	x := connectTestNode(t, s, "x")
+	c1 := connectTestNode(t, relay.Server, "c1")
+	serveEcho(t, c1, 80)
+
+	// stuck 握手后不再读取
+	pc1, pc2 := packet.Pipe()
+	defer pc1.Close()
+	go relay.ServeConn(pc2)
+	_, err := admit.Handshake(pc1, "stuck", "", relay.password)
+	assert.Nil(t, err)
+	waitDomain(t, s, "c1.gw")
+	waitDomain(t, s, "stuck.gw")
+	stuck, err := s.registry.lookupByDomain("stuck.gw")
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// 发给 stuck 的数据包超过它的转发队列，上游读循环不能因此阻塞
+	for i := 0; i < 512; i++ {
+		pbuf := packet.NewBufferWithCmd(packet.CmdPushStreamData)
+		pbuf.SetSrc(x.GetIP(), 1000)
+		pbuf.SetDist(stuck.IP, 80)
+		pbuf.SetPayload([]byte("data"))
+		assert.Nil(t, x.WriteBuffer(pbuf))
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assertEcho(t, x, "c1.gw:80", "c1.gw")
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("slow child blocks the other children")
+	}
+}
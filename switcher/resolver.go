@@ -0,0 +1,107 @@
+package switcher
+
+import (
+	"sync"
+	"time"
+
+	"github.com/net-agent/flex/v3/internal/admit"
+	"github.com/net-agent/flex/v3/packet"
+)
+
+// DefaultResolveTTL 是 dns.resolve 应答的默认缓存时间
+var DefaultResolveTTL = time.Minute
+
+// resolveWatchers 记录解析过各个域名的节点，域名对应的节点下线时通知它们丢弃缓存
+type resolveWatchers struct {
+	mu       sync.Mutex
+	watchers map[string]map[*Context]struct{}
+}
+
+func (w *resolveWatchers) watch(domain string, ctx *Context) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	set, ok := w.watchers[domain]
+	if !ok {
+		set = make(map[*Context]struct{})
+		w.watchers[domain] = set
+	}
+	set[ctx] = struct{}{}
+}
+
+// release 返回解析过 ctx 域名的节点，并清理 ctx 相关的记录
+func (w *resolveWatchers) release(ctx *Context) []*Context {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var ctxs []*Context
+	for watcher := range w.watchers[ctx.Domain] {
+		if watcher != ctx {
+			ctxs = append(ctxs, watcher)
+		}
+	}
+	delete(w.watchers, ctx.Domain)
+	for domain, set := range w.watchers {
+		delete(set, ctx)
+		if len(set) == 0 {
+			delete(w.watchers, domain)
+		}
+	}
+	return ctxs
+}
+
+// SetResolveTTL sets how long nodes may cache dns.resolve answers.
+// A ttl under one second disables caching.
+func (s *Server) SetResolveTTL(ttl time.Duration) {
+	s.resolveTTL.Store(int64(ttl))
+}
+
+func (s *Server) handleDNSResolve(caller *Context, msg *packet.Message) (any, error) {
+	var req packet.DNSQuery
+	if err := msg.Unmarshal(&req); err != nil {
+		return nil, err
+	}
+	name, err := admit.NormalizeName(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	dist, err := s.router.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+
+	record := packet.DNSRecord{Name: name, Domain: dist.Domain, IP: dist.IP}
+	if dist.Domain != name {
+		// 服务组每次解析都可能选出不同成员，不允许缓存
+		record.Alias = true
+		return record, nil
+	}
+	record.TTL = uint32(time.Duration(s.resolveTTL.Load()) / time.Second)
+	if record.TTL > 0 {
+		s.resolved.watch(dist.Domain, caller)
+	}
+	return record, nil
+}
+
+// invalidateResolved 通知解析过 ctx 域名的节点丢弃缓存（ctx 下线时调用）
+func (s *Server) invalidateResolved(ctx *Context) {
+	watchers := s.resolved.release(ctx)
+	if len(watchers) == 0 {
+		return
+	}
+	msg, err := packet.NewMessage(packet.TopicDNSInvalidate, packet.DNSInvalidation{Name: ctx.Domain, IP: ctx.IP})
+	if err != nil {
+		return
+	}
+	payload, err := msg.Encode()
+	if err != nil {
+		return
+	}
+	for _, watcher := range watchers {
+		pbuf := packet.NewBufferWithCmd(packet.CmdPushMessage)
+		pbuf.SetSrc(packet.DNSIP, 0)
+		pbuf.SetDist(watcher.IP, 0)
+		_ = pbuf.SetPayload(payload)
+		if err := watcher.enqueueForward(pbuf); err != nil {
+			s.logger.Warn("push dns invalidation failed", "ctx_id", watcher.id, "domain", watcher.Domain, "name", ctx.Domain, "error", err)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package switcher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/net-agent/flex/v3/node"
+	"github.com/net-agent/flex/v3/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolverDial(t *testing.T) {
+	s := NewServer("testpswd", nil, nil)
+	client := connectTestNode(t, s, "client")
+	server := connectTestNode(t, s, "server")
+	r := node.NewResolver(client)
+	client.SetResolver(r)
+
+	l, err := server.Listen(80)
+	if !assert.Nil(t, err) {
+		return
+	}
+	accepted := make(chan string, 1)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- c.(*stream.Stream).GetState().RemoteDomain
+			c.Close()
+		}
+	}()
+
+	record, err := r.Resolve("Server")
+	if assert.Nil(t, err) {
+		assert.Equal(t, "server", record.Domain)
+		assert.Equal(t, server.GetIP(), record.IP)
+		assert.Equal(t, uint32(60), record.TTL)
+	}
+
+	// 通过缓存的 IP 连接，两端仍然看到对方的域名
+	c, err := client.Dial("server:80")
+	if assert.Nil(t, err) {
+		assert.Equal(t, "server", c.GetState().RemoteDomain)
+		c.Close()
+	}
+	assert.Equal(t, "client", <-accepted)
+
+	// 无法解析时由 switcher 按域名处理
+	_, err = client.Dial("notexists:80")
+	assert.NotNil(t, err)
+
+	_, err = r.Resolve("notexists")
+	assert.NotNil(t, err)
+}
+
+func TestResolverInvalidate(t *testing.T) {
+	s := NewServer("testpswd", nil, nil)
+	client := connectTestNode(t, s, "client")
+	r := node.NewResolver(client)
+	client.SetResolver(r)
+	old := connectTestNode(t, s, "server")
+	serveDomain(t, old, 80)
+	assert.Equal(t, "server", dialDomain(t, client, "server:80"))
+
+	// 节点下线后 switcher 推送失效通知，重新上线后按新的 IP 解析
+	oldIP := old.GetIP()
+	old.Close()
+	assert.Eventually(t, func() bool {
+		_, err := s.registry.lookupByDomain("server")
+		return err != nil
+	}, time.Second, 10*time.Millisecond)
+	connectTestNode(t, s, "placeholder")
+	n := connectTestNode(t, s, "server")
+	assert.NotEqual(t, oldIP, n.GetIP())
+	serveDomain(t, n, 80)
+
+	assert.Eventually(t, func() bool {
+		record, err := r.Resolve("server")
+		return err == nil && record.IP == n.GetIP()
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "server", dialDomain(t, client, "server:80"))
+
+	s.resolved.mu.Lock()
+	assert.Equal(t, 1, len(s.resolved.watchers))
+	s.resolved.mu.Unlock()
+}
+
+func TestResolveGroupAndTTL(t *testing.T) {
+	s, client, _ := initGroup(t, "a", "b")
+	r := node.NewResolver(client)
+
+	record, err := r.Resolve("svc")
+	if assert.Nil(t, err) {
+		assert.True(t, record.Alias)
+		assert.Equal(t, uint32(0), record.TTL)
+		assert.Contains(t, []string{"a", "b"}, record.Domain)
+	}
+
+	s.SetResolveTTL(0)
+	record, err = r.Resolve("a")
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0), record.TTL)
+	s.resolved.mu.Lock()
+	assert.Equal(t, 0, len(s.resolved.watchers))
+	s.resolved.mu.Unlock()
+}
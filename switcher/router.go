@@ -3,24 +3,70 @@ package switcher
 import (
 	"errors"
 	"log/slog"
+	"sync/atomic"
 
 	"github.com/net-agent/flex/v3/packet"
 )
 
 var (
 	errResolveDomainFailed = errors.New("resolve domain failed")
+	errUnknownTopic        = errors.New("unknown message topic")
 )
 
+// messageHandler 处理节点发给 switcher 的控制消息，返回值作为应答的消息体。
+// caller 为消息的真正发送方（经由中继转发时为子节点）。
+type messageHandler func(caller *Context, msg *packet.Message) (any, error)
+
 type packetRouter struct {
 	registry *contextRegistry
 	logger   *slog.Logger
+	handlers map[string]messageHandler
+
+	// fallback 在域名无法解析时被调用，返回 true 表示请求已被接管（见 Relay）
+	fallback func(caller *Context, pbuf *packet.Buffer) bool
+
+	// host 是 switcher 自身的服务节点，未启用服务时为 nil（见 Server.Listen）
+	host atomic.Pointer[Context]
 }
 
 func newPacketRouter(registry *contextRegistry, logger *slog.Logger) *packetRouter {
 	return &packetRouter{
 		registry: registry,
 		logger:   logger,
+		handlers: make(map[string]messageHandler),
+	}
+}
+
+// handleTopic registers the handler of a message topic. It must be called
+// before serving any connection.
+func (rt *packetRouter) handleTopic(topic string, fn messageHandler) {
+	rt.handlers[topic] = fn
+}
+
+// resolve returns the context serving name: a domain, or a member of the
+// service group with that name.
+func (rt *packetRouter) resolve(name string) (*Context, error) {
+	ctx, err := rt.registry.lookupByDomain(name)
+	if err != errDomainNotFound {
+		return ctx, err
+	}
+	if ctx, gerr := rt.registry.lookupGroup(name); gerr == nil {
+		return ctx, nil
+	}
+	return nil, err
+}
+
+// sourceContext returns the actual sender of a packet read from caller.
+// Packets forwarded by a relay node carry the IP of one of its children.
+func (rt *packetRouter) sourceContext(caller *Context, srcIP uint16) *Context {
+	if srcIP == caller.IP {
+		return caller
+	}
+	ctx, err := rt.registry.lookupByIP(srcIP)
+	if err != nil || ctx.parent != caller {
+		return caller
 	}
+	return ctx
 }
 
 // serve reads packets from ctx in a loop and routes each one.
@@ -31,18 +77,71 @@ func (rt *packetRouter) serve(ctx *Context) error {
 			return err
 		}
 		ctx.recordIncoming(pbuf)
+		if pbuf.Cmd() == packet.AckOpenStream {
+			rt.stampAckSource(ctx, pbuf)
+		}
 
 		if pbuf.DistIP() != packet.SwitcherIP {
+			if pbuf.Cmd() == packet.CmdOpenStream {
+				rt.stampSource(ctx, pbuf)
+			}
 			// 需要保证发送顺序，不能使用协程并行
 			rt.forward(pbuf)
 			continue
 		}
 
+		if host := rt.host.Load(); host != nil && host != ctx && isHostPacket(pbuf) {
+			// stream 数据需要保证顺序，与 open 请求一起同步交给服务节点
+			if pbuf.Cmd() == packet.CmdOpenStream {
+				rt.stampSource(ctx, pbuf)
+			}
+			if err := host.enqueueForward(pbuf); err != nil {
+				rt.logger.Warn("forward to host failed", "src_ip", pbuf.SrcIP(), "cmd", pbuf.CmdName(), "error", err)
+			}
+			continue
+		}
+
 		// 无需保证顺序
 		go rt.dispatch(ctx, pbuf)
 	}
 }
 
+// isHostPacket 判断发往 SwitcherIP 的数据包是否属于 switcher 自身服务的 stream：
+// 不带域名的 open 请求，以及 stream 的数据、关闭和应答
+func isHostPacket(pbuf *packet.Buffer) bool {
+	switch pbuf.Cmd() {
+	case packet.CmdOpenStream:
+		req := packet.DecodeOpenStreamRequest(pbuf.Payload)
+		return req.Domain == "" && req.Selector == ""
+	case packet.AckOpenStream,
+		packet.CmdPushStreamData,
+		packet.AckPushStreamData,
+		packet.CmdCloseStream,
+		packet.AckCloseStream:
+		return true
+	}
+	return false
+}
+
+// stampSource 将按 IP 发起的 open-stream 请求中的域名改写为发送方的域名，
+// 与按域名连接时一致，被叫方据此识别对端，发送方也无法冒充其他域名
+func (rt *packetRouter) stampSource(caller *Context, pbuf *packet.Buffer) {
+	req := packet.DecodeOpenStreamRequest(pbuf.Payload)
+	fwd := packet.OpenStreamRequest{Domain: rt.sourceContext(caller, pbuf.SrcIP()).Domain, WindowSize: req.WindowSize, Metadata: req.Metadata, MaxWindowSize: req.MaxWindowSize}
+	_ = pbuf.SetPayload(fwd.Encode())
+}
+
+// stampAckSource 在成功的 open 应答中填写应答方的域名。按选择器或 IP 连接时，
+// 发起方据此得知对端的域名
+func (rt *packetRouter) stampAckSource(callee *Context, pbuf *packet.Buffer) {
+	ack := packet.DecodeOpenStreamACK(pbuf.Payload)
+	if !ack.OK {
+		return
+	}
+	ack.Domain = rt.sourceContext(callee, pbuf.SrcIP()).Domain
+	_ = pbuf.SetPayload(ack.Encode())
+}
+
 // forward forwards a packet to its destination by IP lookup.
 func (rt *packetRouter) forward(pbuf *packet.Buffer) {
 	dist, err := rt.registry.lookupByIP(pbuf.DistIP())
@@ -71,9 +170,53 @@ func (rt *packetRouter) dispatch(ctx *Context, pbuf *packet.Buffer) {
 		} else {
 			rt.handlePingDomain(ctx, pbuf)
 		}
+
+	case packet.CmdPushMessage:
+		if !pbuf.IsACK() {
+			rt.handleMessage(ctx, pbuf)
+		}
+	}
+}
+
+// handleMessage runs the handler of the message topic and replies with its result.
+func (rt *packetRouter) handleMessage(caller *Context, pbuf *packet.Buffer) {
+	reply := rt.runMessage(rt.sourceContext(caller, pbuf.SrcIP()), pbuf.Payload)
+	payload, err := reply.Encode()
+	if err != nil {
+		reply = &packet.Message{Topic: reply.Topic, Error: err.Error()}
+		payload, _ = reply.Encode()
+	}
+
+	pbuf.SwapSrcDist()
+	pbuf.SetCmd(packet.AckPushMessage)
+	_ = pbuf.SetPayload(payload)
+	if err := caller.writeBuffer(pbuf); err != nil {
+		rt.logger.Warn("message reply write failed", "ctx_id", caller.id, "domain", caller.Domain, "topic", reply.Topic, "error", err)
 	}
 }
 
+func (rt *packetRouter) runMessage(caller *Context, payload []byte) *packet.Message {
+	msg, err := packet.DecodeMessage(payload)
+	if err != nil {
+		return &packet.Message{Error: err.Error()}
+	}
+
+	fn, ok := rt.handlers[msg.Topic]
+	if !ok {
+		return &packet.Message{Topic: msg.Topic, Error: errUnknownTopic.Error()}
+	}
+
+	body, err := fn(caller, msg)
+	if err == nil {
+		var reply *packet.Message
+		if reply, err = packet.NewMessage(msg.Topic, body); err == nil {
+			return reply
+		}
+	}
+	rt.logger.Debug("handle message failed", "ctx_id", caller.id, "domain", caller.Domain, "topic", msg.Topic, "error", err)
+	return &packet.Message{Topic: msg.Topic, Error: err.Error()}
+}
+
 // handlePingDomain resolves a domain and forwards the ping, or responds directly for empty domain.
 func (rt *packetRouter) handlePingDomain(caller *Context, pbuf *packet.Buffer) {
 	domain := string(pbuf.Payload)
@@ -87,8 +230,11 @@ func (rt *packetRouter) handlePingDomain(caller *Context, pbuf *packet.Buffer) {
 		return
 	}
 
-	dist, err := rt.registry.lookupByDomain(string(pbuf.Payload))
+	dist, err := rt.resolve(domain)
 	if err != nil {
+		if rt.fallback != nil && rt.fallback(caller, pbuf) {
+			return
+		}
 		pbuf.SwapSrcDist()
 		pbuf.SetCmd(pbuf.Cmd() | packet.CmdACKFlag)
 		_ = pbuf.SetPayload([]byte(err.Error()))
@@ -98,7 +244,9 @@ func (rt *packetRouter) handlePingDomain(caller *Context, pbuf *packet.Buffer) {
 		return
 	}
 
+	// 目标可能是服务组的成员，按成员自身的域名转发
 	pbuf.SetDistIP(dist.IP)
+	_ = pbuf.SetPayload([]byte(dist.Domain))
 	if err := dist.writeBuffer(pbuf); err != nil {
 		rt.logger.Warn("ping forward write failed", "ctx_id", dist.id, "domain", dist.Domain, "error", err)
 	}
@@ -114,11 +262,21 @@ func (rt *packetRouter) handleAckPingDomain(caller *Context, pbuf *packet.Buffer
 // handleOpenStream resolves the destination domain and forwards the open-stream request.
 func (rt *packetRouter) handleOpenStream(caller *Context, pbuf *packet.Buffer) {
 	req := packet.DecodeOpenStreamRequest(pbuf.Payload)
+	src := rt.sourceContext(caller, pbuf.SrcIP())
 
-	distCtx, err := rt.registry.lookupByDomain(req.Domain)
+	var distCtx *Context
+	var err error
+	if req.Selector != "" {
+		distCtx, err = rt.registry.lookupBySelector(req.Selector, src)
+	} else {
+		distCtx, err = rt.resolve(req.Domain)
+	}
 	if err != nil {
-		rt.logger.Warn("resolve domain failed", "caller_id", caller.id, "caller_domain", caller.Domain, "target_domain", req.Domain, "error", err)
-		ack := packet.OpenStreamACK{Error: errResolveDomainFailed.Error()}
+		if rt.fallback != nil && rt.fallback(caller, pbuf) {
+			return
+		}
+		rt.logger.Warn("resolve domain failed", "caller_id", caller.id, "caller_domain", caller.Domain, "target_domain", req.Domain, "selector", req.Selector, "error", err)
+		ack := packet.OpenStreamACK{Code: packet.CodeDomainNotFound, Error: errResolveDomainFailed.Error()}
 		pbuf.SetCmd(packet.AckOpenStream)
 		pbuf.SwapSrcDist()
 		_ = pbuf.SetPayload(ack.Encode())
@@ -129,10 +287,12 @@ func (rt *packetRouter) handleOpenStream(caller *Context, pbuf *packet.Buffer) {
 		return
 	}
 
-	fwd := packet.OpenStreamRequest{Domain: caller.Domain, WindowSize: req.WindowSize}
+	fwd := packet.OpenStreamRequest{Domain: src.Domain, WindowSize: req.WindowSize, Metadata: req.Metadata, MaxWindowSize: req.MaxWindowSize}
 	pbuf.SetDistIP(distCtx.IP)
 	_ = pbuf.SetPayload(fwd.Encode())
+	distCtx.addPendingOpen()
 	if err := distCtx.writeBuffer(pbuf); err != nil {
+		distCtx.donePendingOpen()
 		rt.logger.Warn("open-stream forward write failed", "ctx_id", distCtx.id, "domain", distCtx.Domain, "error", err)
 	}
 }
@@ -1,11 +1,16 @@
 package switcher
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"testing"
 	"time"
 
+	"github.com/net-agent/flex/v3/node"
 	"github.com/net-agent/flex/v3/packet"
+	"github.com/net-agent/flex/v3/stream"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestRouterPingDomain(t *testing.T) {
@@ -83,6 +88,7 @@ func TestRouterOpenStream(t *testing.T) {
 		t.Error("unexpected nil err")
 		return
 	}
+	assert.ErrorIs(t, err, node.ErrListenerNotFound)
 	log.Printf("dial err=%v\n", err)
 
 	// 错误用例：dial不存在的domain
@@ -91,6 +97,7 @@ func TestRouterOpenStream(t *testing.T) {
 		t.Error("unexpected nil err")
 		return
 	}
+	assert.ErrorIs(t, err, node.ErrDomainNotFound)
 	log.Printf("dial err=%v\n", err)
 }
 
@@ -106,3 +113,67 @@ func TestRouterAckPingDomain(t *testing.T) {
 	caller.pingBack.Store(uint16(0), 100)
 	s.router.handleAckPingDomain(caller, pbuf)
 }
+
+func TestRouterMessage(t *testing.T) {
+	s, node1, _ := initTestEnv("test1", "test2")
+	s.router.handleTopic("echo", func(caller *Context, msg *packet.Message) (any, error) {
+		var text string
+		if err := msg.Unmarshal(&text); err != nil {
+			return nil, err
+		}
+		return caller.Domain + ":" + text, nil
+	})
+
+	var resp string
+	err := node1.Request("echo", "hello", &resp, time.Second)
+	if err != nil || resp != "test1:hello" {
+		t.Errorf("unexpected resp=%v err=%v\n", resp, err)
+		return
+	}
+
+	// 错误用例：未注册的 topic
+	err = node1.Request("notexists", nil, nil, time.Second)
+	if err == nil || err.Error() != errUnknownTopic.Error() {
+		t.Errorf("unexpected err=%v\n", err)
+	}
+}
+
+func TestRouterOpenStreamMetadata(t *testing.T) {
+	_, node1, node2 := initTestEnv("test1", "test2")
+	l, err := node2.Listen(80)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	accepted := make(chan map[string]string, 2)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			s := c.(*stream.Stream)
+			accepted <- s.Metadata()
+			s.Close()
+		}
+	}()
+
+	md := map[string]string{"trace-id": "abc123", "proto": "h2"}
+	ctx := node.WithMetadata(context.Background(), md)
+
+	// 按域名和按 IP 拨号，switcher 都应原样转发元数据
+	for _, addr := range []string{"test2:80", fmt.Sprintf("%v:80", node2.GetIP())} {
+		s, err := node1.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			t.Error(addr, err)
+			return
+		}
+		s.Close()
+		select {
+		case got := <-accepted:
+			assert.Equal(t, md, got, addr)
+		case <-time.After(time.Second):
+			t.Error("accept timeout", addr)
+		}
+	}
+}
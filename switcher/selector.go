@@ -0,0 +1,79 @@
+package switcher
+
+import (
+	"errors"
+	"sort"
+	"sync/atomic"
+
+	"github.com/net-agent/flex/v3/internal/selector"
+	"github.com/net-agent/flex/v3/packet"
+)
+
+var errNoSelectorMatch = errors.New("no node matches selector")
+
+// selectContexts 返回标签与 sel 匹配的本地节点（不含联邦与中继上游的别名），按 IP 排序
+func (r *contextRegistry) selectContexts(sel selector.Selector) []*Context {
+	var matched []*Context
+	for _, ctx := range r.activeContexts() {
+		if ctx == nil || ctx.IsRemote() || !sel.Matches(ctx.Labels) {
+			continue
+		}
+		matched = append(matched, ctx)
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i].IP < matched[j].IP })
+	return matched
+}
+
+// lookupBySelector 在匹配 sel 的节点中轮流选出一个，exclude（发起方）不参与选择
+func (r *contextRegistry) lookupBySelector(sel string, exclude *Context) (*Context, error) {
+	parsed, err := selector.Parse(sel)
+	if err != nil {
+		return nil, err
+	}
+	matched := r.selectContexts(parsed)
+	for i, ctx := range matched {
+		if ctx == exclude {
+			matched = append(matched[:i], matched[i+1:]...)
+			break
+		}
+	}
+	if len(matched) == 0 {
+		return nil, errNoSelectorMatch
+	}
+	n := atomic.AddUint32(&r.selectNext, 1)
+	return matched[int(n%uint32(len(matched)))], nil
+}
+
+// QueryClients returns the connected nodes whose labels match sel, using the
+// same syntax as node.DialSelector. An empty sel matches every node.
+func (s *Server) QueryClients(sel string) ([]ClientInfo, error) {
+	parsed, err := selector.Parse(sel)
+	if err != nil {
+		return nil, err
+	}
+	infos := s.GetClients()
+	matched := infos[:0]
+	for _, info := range infos {
+		if parsed.Matches(info.Labels) {
+			matched = append(matched, info)
+		}
+	}
+	return matched, nil
+}
+
+func (s *Server) handleNodeQuery(caller *Context, msg *packet.Message) (any, error) {
+	var req packet.NodeQuery
+	if err := msg.Unmarshal(&req); err != nil {
+		return nil, err
+	}
+	parsed, err := selector.Parse(req.Selector)
+	if err != nil {
+		return nil, err
+	}
+	ctxs := s.registry.selectContexts(parsed)
+	records := make([]packet.NodeRecord, 0, len(ctxs))
+	for _, ctx := range ctxs {
+		records = append(records, packet.NodeRecord{Domain: ctx.Domain, IP: ctx.IP, Labels: ctx.Labels})
+	}
+	return records, nil
+}
@@ -0,0 +1,161 @@
+package switcher
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/net-agent/flex/v3/internal/admit"
+	"github.com/net-agent/flex/v3/node"
+	"github.com/net-agent/flex/v3/packet"
+	"github.com/net-agent/flex/v3/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+func connectLabeledNode(t *testing.T, s *Server, domain string, labels map[string]string) *node.Node {
+	t.Helper()
+	pc1, pc2 := packet.Pipe()
+	go s.ServeConn(pc2)
+
+	ip, err := admit.HandshakeWithLabels(pc1, domain, "", s.password, labels)
+	if err != nil {
+		t.Fatalf("handshake failed: %v", err)
+	}
+	n := node.New(pc1)
+	n.SetIP(ip)
+	n.SetDomain(domain)
+	go n.Serve()
+	return n
+}
+
+func dialSelectorDomain(t *testing.T, n *node.Node, sel string) string {
+	t.Helper()
+	c, err := n.DialSelector(sel, 80)
+	if !assert.Nil(t, err, "dial %v", sel) {
+		return ""
+	}
+	defer c.Close()
+	buf := make([]byte, 64)
+	k, _ := c.Read(buf)
+	return string(buf[:k])
+}
+
+func TestDialSelector(t *testing.T) {
+	s := NewServer("testpswd", nil, nil)
+	client := connectLabeledNode(t, s, "client", map[string]string{"role": "db"})
+	for domain, labels := range map[string]map[string]string{
+		"db1":  {"role": "db", "region": "eu"},
+		"db2":  {"role": "db", "region": "eu", "legacy": "true"},
+		"db3":  {"role": "db", "region": "us"},
+		"web1": {"role": "web", "region": "eu"},
+	} {
+		serveDomain(t, connectLabeledNode(t, s, domain, labels), 80)
+	}
+
+	// 多个节点匹配时轮流选择，发起方自身不参与
+	counts := map[string]int{}
+	for i := 0; i < 6; i++ {
+		counts[dialSelectorDomain(t, client, "role=db, region=eu")]++
+	}
+	assert.Equal(t, map[string]int{"db1": 3, "db2": 3}, counts)
+
+	assert.Equal(t, "db1", dialSelectorDomain(t, client, "region=eu,role!=web,!legacy"))
+	assert.Equal(t, "db3", dialSelectorDomain(t, client, "role=db,region=us"))
+
+	c, err := client.DialSelector("role=db,region=us", 80)
+	if assert.Nil(t, err) {
+		db3, _ := s.registry.lookupByDomain("db3")
+		assert.Equal(t, "db3", c.GetState().RemoteDomain)
+		assert.Equal(t, db3.IP, c.GetState().RemoteAddr.IP)
+		c.Close()
+	}
+
+	_, err = client.DialSelector("role=cache", 80)
+	assert.NotNil(t, err)
+	_, err = client.DialSelector("", 80)
+	assert.NotNil(t, err)
+	_, err = client.DialSelector("role=db,", 80)
+	assert.NotNil(t, err)
+}
+
+func TestDialSelectorMetadata(t *testing.T) {
+	s := NewServer("testpswd", nil, nil)
+	client := connectTestNode(t, s, "client")
+	db := connectLabeledNode(t, s, "db1", map[string]string{"role": "db"})
+	l, err := db.Listen(80)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer l.Close()
+	accepted := make(chan map[string]string, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		accepted <- c.(*stream.Stream).Metadata()
+		c.Close()
+	}()
+
+	md := map[string]string{"trace-id": "abc123"}
+	c, err := client.DialSelectorContext(node.WithMetadata(context.Background(), md), "role=db", 80)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer c.Close()
+	select {
+	case got := <-accepted:
+		assert.Equal(t, md, got)
+	case <-time.After(time.Second):
+		t.Error("accept timeout")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = client.DialSelectorContext(ctx, "role=db", 80)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestQueryClients(t *testing.T) {
+	s := NewServer("testpswd", nil, nil)
+	n := connectLabeledNode(t, s, "a", map[string]string{"role": "db", "gpu": "a100"})
+	connectLabeledNode(t, s, "b", map[string]string{"role": "web"})
+	connectTestNode(t, s, "c")
+
+	infos, err := s.QueryClients("gpu")
+	if assert.Nil(t, err) && assert.Equal(t, 1, len(infos)) {
+		assert.Equal(t, "a", infos[0].Domain)
+		assert.Equal(t, map[string]string{"role": "db", "gpu": "a100"}, infos[0].Labels)
+	}
+	infos, err = s.QueryClients("")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(infos))
+	_, err = s.QueryClients("a b")
+	assert.NotNil(t, err)
+
+	// 节点通过 switcher 查询，结果按 IP 排序
+	records, err := n.QueryNodes("role!=db")
+	if assert.Nil(t, err) && assert.Equal(t, 2, len(records)) {
+		assert.Equal(t, "b", records[0].Domain)
+		assert.Equal(t, map[string]string{"role": "web"}, records[0].Labels)
+		assert.Equal(t, "c", records[1].Domain)
+		assert.Less(t, records[0].IP, records[1].IP)
+	}
+	_, err = n.QueryNodes("!")
+	assert.NotNil(t, err)
+}
+
+func TestRelaySelector(t *testing.T) {
+	s, _, relay := initRelay(t)
+	x := connectTestNode(t, s, "x")
+	c1 := connectLabeledNode(t, relay.Server, "c1", map[string]string{"role": "sensor"})
+	waitDomain(t, s, "c1.gw")
+	serveDomain(t, c1, 80)
+
+	// 中继子节点的标签同步到上游
+	records, err := x.QueryNodes("role=sensor")
+	if assert.Nil(t, err) && assert.Equal(t, 1, len(records)) {
+		assert.Equal(t, "c1.gw", records[0].Domain)
+	}
+	assert.Equal(t, "c1", dialSelectorDomain(t, x, "role=sensor"))
+}
@@ -25,9 +25,11 @@ type Server struct {
 	listenerMu sync.Mutex
 	listener   net.Listener
 	password   string
+	name       string
 	nextCtxID  int32
 
 	enableFairConn atomic.Bool
+	coalesce       atomic.Pointer[packet.CoalesceConfig] // nil 时不合并写入
 
 	OnContextStart OnContextStartHandler
 	OnContextStop  OnContextStopHandler
@@ -36,6 +38,19 @@ type Server struct {
 	router    *packetRouter
 	logger    *slog.Logger
 	ctxLogger *slog.Logger
+
+	peersMu sync.Mutex
+	peers   map[string]*peerLink
+
+	relay *Relay // non-nil when the server runs as a relay
+
+	presence presenceHub
+
+	resolveTTL atomic.Int64 // time.Duration
+	resolved   resolveWatchers
+
+	host    serviceHost
+	tunnels tunnelTable
 }
 
 type ServerError struct {
@@ -51,12 +66,15 @@ type StatsResponse struct {
 }
 
 type ClientInfo struct {
-	ID          int         `json:"id"`
-	Domain      string      `json:"domain"`
-	IP          uint16      `json:"ip"`
-	Mac         string      `json:"mac"`
-	ConnectedAt time.Time   `json:"connected_at"`
-	Stats       ClientStats `json:"stats"`
+	ID          int               `json:"id"`
+	Domain      string            `json:"domain"`
+	IP          uint16            `json:"ip"`
+	Mac         string            `json:"mac"`
+	Via         string            `json:"via,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Ports       []uint16          `json:"ports,omitempty"`
+	ConnectedAt time.Time         `json:"connected_at"`
+	Stats       ClientStats       `json:"stats"`
 }
 
 type ClientStats struct {
@@ -82,31 +100,111 @@ func NewServer(password string, logger *slog.Logger, logCfg *LogConfig) *Server
 
 	s := &Server{
 		password:  password,
+		name:      "switcher",
+		peers:     make(map[string]*peerLink),
 		registry:  reg,
 		logger:    newModuleLogger(logger, cfg.Server, "server"),
 		ctxLogger: newModuleLogger(logger, cfg.Context, "context"),
+		presence:  presenceHub{subs: make(map[*Context]*presenceSub)},
+		resolved:  resolveWatchers{watchers: make(map[string]map[*Context]struct{})},
+		tunnels:   tunnelTable{tunnels: make(map[string]*tunnel)},
 	}
 	s.enableFairConn.Store(true)
+	s.resolveTTL.Store(int64(DefaultResolveTTL))
 	s.router = newPacketRouter(reg, newModuleLogger(logger, cfg.Router, "router"))
+	s.router.handleTopic(topicRelayRegister, s.handleRelayRegister)
+	s.router.handleTopic(topicRelayUnregister, s.handleRelayUnregister)
+	s.router.handleTopic(packet.TopicGroupJoin, s.handleGroupJoin)
+	s.router.handleTopic(packet.TopicGroupLeave, s.handleGroupLeave)
+	s.router.handleTopic(packet.TopicNodeQuery, s.handleNodeQuery)
+	s.router.handleTopic(packet.TopicDirectoryList, s.handleDirectoryList)
+	s.router.handleTopic(packet.TopicDirectoryAdvertise, s.handleDirectoryAdvertise)
+	s.router.handleTopic(packet.TopicPresenceSubscribe, s.handlePresenceSubscribe)
+	s.router.handleTopic(packet.TopicPresenceUnsubscribe, s.handlePresenceUnsubscribe)
+	s.router.handleTopic(packet.TopicDNSResolve, s.handleDNSResolve)
+	s.router.handleTopic(packet.TopicTunnelOpen, s.handleTunnelOpen)
+	s.router.handleTopic(packet.TopicTunnelClose, s.handleTunnelClose)
+	reg.onAttach = s.onContextAttach
+	reg.onDetach = s.onContextDetach
 	return s
 }
 
+func (s *Server) onContextAttach(ctx *Context) {
+	if ctx.IsRemote() {
+		return
+	}
+	s.broadcastPeers(topicPeerAdd, []peerEntry{{Domain: ctx.Domain, IP: ctx.IP}})
+	s.publishPresence(ctx, true)
+}
+
+// onContextServing 在 ctx 完成握手、可以收发数据包后调用。
+// 中继的注册需要等待上游应答，放在单独的 goroutine 中，不延迟子节点的登录
+func (s *Server) onContextServing(ctx *Context) {
+	if s.relay != nil {
+		go s.relay.register(ctx)
+	}
+}
+
+func (s *Server) onContextDetach(ctx *Context) {
+	s.invalidateResolved(ctx)
+	switch {
+	case ctx.peer != nil:
+		s.dropAlias(ctx)
+	case ctx.IsRemote():
+		return
+	default:
+		s.broadcastPeers(topicPeerDel, []peerEntry{{Domain: ctx.Domain, IP: ctx.IP}})
+		s.presence.remove(ctx)
+		s.publishPresence(ctx, false)
+		s.closeTunnels(ctx)
+		s.detachChildren(ctx)
+		if s.relay != nil {
+			s.relay.unregister(ctx)
+		}
+	}
+	// 在 detach 的调用栈中重新进入 registry（attachRemote），见 contextRegistry.onDetach
+	s.restoreRemote(ctx.Domain)
+}
+
 // SetEnableFairConn controls whether incoming connections are wrapped with
 // sched.FairConn for fair stream scheduling. Default is true (enabled).
 func (s *Server) SetEnableFairConn(enable bool) {
 	s.enableFairConn.Store(enable)
 }
 
+// SetWriteCoalescing enables write coalescing (see packet.CoalesceConfig) on
+// connections accepted afterwards. A nil cfg disables it, which is the default.
+func (s *Server) SetWriteCoalescing(cfg *packet.CoalesceConfig) {
+	if cfg != nil {
+		c := *cfg
+		cfg = &c
+	}
+	s.coalesce.Store(cfg)
+}
+
 func (s *Server) GetStats() *StatsResponse {
-	ctxs := s.registry.activeContexts()
 	return &StatsResponse{
-		ActiveConnections: len(ctxs),
+		ActiveConnections: len(s.localContexts()),
 		TotalContexts:     int64(atomic.LoadInt32(&s.nextCtxID)),
 	}
 }
 
-func (s *Server) GetClients() []ClientInfo {
+// localContexts returns the contexts of directly connected nodes,
+// excluding domains learned from federated switchers.
+func (s *Server) localContexts() []*Context {
 	ctxs := s.registry.activeContexts()
+	local := ctxs[:0]
+	for _, ctx := range ctxs {
+		if ctx == nil || ctx.IsRemote() {
+			continue
+		}
+		local = append(local, ctx)
+	}
+	return local
+}
+
+func (s *Server) GetClients() []ClientInfo {
+	ctxs := s.localContexts()
 	infos := make([]ClientInfo, 0, len(ctxs))
 
 	for _, ctx := range ctxs {
@@ -118,6 +216,9 @@ func (s *Server) GetClients() []ClientInfo {
 			Domain:      ctx.Domain,
 			IP:          ctx.IP,
 			Mac:         ctx.Mac,
+			Via:         ctx.Via(),
+			Labels:      ctx.Labels,
+			Ports:       ctx.Ports(),
 			ConnectedAt: ctx.AttachTime,
 			Stats: ClientStats{
 				StreamCount:   atomic.LoadInt32(&ctx.Stats.StreamCount),
@@ -153,7 +254,12 @@ func (s *Server) Serve(l net.Listener) error {
 			return err
 		}
 
-		pconn := packet.NewWithConn(conn)
+		var pconn packet.Conn
+		if cfg := s.coalesce.Load(); cfg != nil {
+			pconn = packet.NewBufferedConn(conn, *cfg)
+		} else {
+			pconn = packet.NewWithConn(conn)
+		}
 		if s.enableFairConn.Load() {
 			pconn = sched.NewFairConn(pconn)
 		}
@@ -162,6 +268,8 @@ func (s *Server) Serve(l net.Listener) error {
 }
 
 func (s *Server) Close() error {
+	s.closeTunnels(nil)
+
 	s.listenerMu.Lock()
 	l := s.listener
 	s.listener = nil
@@ -186,8 +294,13 @@ func (s *Server) ServeConn(pc packet.Conn) error {
 		return err
 	}
 
+	if req.Peer {
+		return s.acceptPeer(pc, req.Domain)
+	}
+
 	// 第二步：将ctx映射到map中
 	ctx := NewContext(int(atomic.AddInt32(&s.nextCtxID, 1)), pc, req.Domain, req.Mac, s.ctxLogger)
+	ctx.Labels = req.Labels
 	err = s.registry.attach(ctx)
 	if err != nil {
 		resp := admit.NewErrResponse(-2, "handshake rejected")
@@ -207,6 +320,7 @@ func (s *Server) ServeConn(pc packet.Conn) error {
 
 	// 第四步：绑定底层连接
 	ctx.setConn(pc)
+	s.onContextServing(ctx)
 
 	// 记录服务时长
 	start := time.Now()
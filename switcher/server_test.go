@@ -1,6 +1,9 @@
 package switcher
 
 import (
+	"bytes"
+	"crypto/rand"
+	"io"
 	"log"
 	"net"
 	"sync"
@@ -10,6 +13,7 @@ import (
 	"github.com/net-agent/flex/v3/internal/admit"
 	"github.com/net-agent/flex/v3/node"
 	"github.com/net-agent/flex/v3/packet"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestServerRun(t *testing.T) {
@@ -119,6 +123,34 @@ func TestHandlePCErr_Password(t *testing.T) {
 	log.Printf("expected err=%v\n", err)
 }
 
+// 带写合并的连接在关闭前要写出拒绝应答，客户端才能看到被拒绝的原因
+func TestHandlePCErr_BufferedReject(t *testing.T) {
+	pswd := "testpswd"
+	s := NewServer(pswd, nil, nil)
+	c1, c2 := net.Pipe()
+	pc1 := packet.NewWithConn(c1)
+	pc2 := packet.NewBufferedConn(c2, packet.CoalesceConfig{IdleDelay: time.Hour})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var req admit.Request
+		req.Domain = "test"
+		req.Version = packet.VERSION
+		req.Timestamp = time.Now().UnixNano()
+		req.Sum = req.CalcSum(pswd + "_badpswd")
+		req.WriteTo(pc1, pswd+"_badpswd")
+
+		var resp admit.Response
+		if assert.NoError(t, resp.ReadFrom(pc1, pswd)) {
+			assert.Equal(t, -1, resp.ErrCode)
+		}
+	}()
+
+	assert.Error(t, s.ServeConn(pc2))
+	<-done
+}
+
 // 模拟服务端在应答之前连接断开的情况
 func TestHandlePCErr_WriteResponse(t *testing.T) {
 	pswd := "testpswd"
@@ -182,3 +214,64 @@ func TestGetClientsWithData(t *testing.T) {
 		}
 	}
 }
+
+func TestServeWriteCoalescing(t *testing.T) {
+	pswd := "testpswd"
+	s := NewServer(pswd, nil, nil)
+	s.SetWriteCoalescing(&packet.DefaultCoalesceConfig)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.Nil(t, err) {
+		return
+	}
+	go s.Serve(l)
+	defer s.Close()
+
+	connect := func(domain string) *node.Node {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if !assert.Nil(t, err) {
+			return nil
+		}
+		pc := packet.NewBufferedConn(c, packet.DefaultCoalesceConfig)
+		ip, err := admit.Handshake(pc, domain, "", pswd)
+		if !assert.Nil(t, err) {
+			return nil
+		}
+		n := node.New(pc)
+		n.SetIP(ip)
+		n.SetDomain(domain)
+		go n.Serve()
+		t.Cleanup(func() { n.Close() })
+		return n
+	}
+	n1, n2 := connect("test1"), connect("test2")
+	if n1 == nil || n2 == nil {
+		return
+	}
+
+	ln, err := n2.Listen(80)
+	if !assert.Nil(t, err) {
+		return
+	}
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		io.Copy(c, c)
+		c.Close()
+	}()
+
+	c, err := n1.Dial("test2:80")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer c.Close()
+
+	data := make([]byte, 1024*1024)
+	rand.Read(data)
+	go c.Write(data)
+	got := make([]byte, len(data))
+	_, err = io.ReadFull(c, got)
+	assert.Nil(t, err)
+	assert.True(t, bytes.Equal(data, got))
+}
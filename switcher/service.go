@@ -0,0 +1,189 @@
+package switcher
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/net-agent/flex/v3/node"
+	"github.com/net-agent/flex/v3/packet"
+	"github.com/net-agent/flex/v3/stream"
+)
+
+var errUnknownAdminCommand = errors.New("unknown admin command")
+
+// Ports of the built-in services on SwitcherIP.
+const (
+	PortEcho    uint16 = 7  // 回显收到的数据
+	PortDiscard uint16 = 9  // 丢弃收到的数据
+	PortAdmin   uint16 = 10 // 按行读取管理命令，每条命令应答一行 JSON
+	PortDaytime uint16 = 13 // 返回当前时间（RFC 3339）后关闭
+)
+
+// serviceHost 是 switcher 自身在 SwitcherIP 上的节点，首次使用时创建。
+// 它通过内存管道接入 router，与普通节点一样收发 stream。
+type serviceHost struct {
+	once sync.Once
+	ctx  *Context
+	node *node.Node
+}
+
+func (s *Server) hostNode() *node.Node {
+	s.host.once.Do(func() {
+		pc1, pc2 := packet.Pipe()
+		ctx := NewContext(0, pc1, s.name, "", s.ctxLogger)
+		ctx.IP = packet.SwitcherIP
+
+		n := node.New(pc2)
+		n.SetIP(packet.SwitcherIP)
+		n.SetDomain(s.name)
+		n.SetLogger(s.logger)
+		// 发往 SwitcherIP 的数据包会被节点直接回送给自己，无法用 ping 探活；内存管道也无需探活
+		n.Heartbeat.SetChecker(func() error { return nil })
+		go n.Serve()
+		go s.router.serve(ctx)
+
+		s.host.ctx = ctx
+		s.host.node = n
+		s.router.host.Store(ctx)
+	})
+	return s.host.node
+}
+
+// Listen listens on a virtual port of the switcher itself. Nodes reach it by
+// dialing packet.SwitcherIP, e.g. n.DialIP(packet.SwitcherIP, port).
+func (s *Server) Listen(port uint16) (net.Listener, error) {
+	return s.hostNode().Listen(port)
+}
+
+// Dial opens a stream from the switcher to addr ("domain:port", a service
+// group, or "ip:port") of a node connected to this switcher. The switcher
+// resolves the domain itself, since the host node cannot send control
+// requests to SwitcherIP.
+func (s *Server) Dial(addr string) (*stream.Stream, error) {
+	return s.DialContext(context.Background(), addr)
+}
+
+// DialContext 与 Dial 相同，ctx 取消时放弃等待 open 应答并回收端口
+func (s *Server) DialContext(ctx context.Context, addr string) (*stream.Stream, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, err
+	}
+
+	ip, err := strconv.ParseUint(host, 10, 16)
+	if err != nil {
+		dist, err := s.router.resolve(host)
+		if err != nil {
+			return nil, err
+		}
+		ip = uint64(dist.IP)
+	}
+	st, err := s.hostNode().DialIPContext(ctx, uint16(ip), uint16(port))
+	if err != nil {
+		return nil, err
+	}
+	st.SetRemoteDomain(host)
+	return st, nil
+}
+
+// HandleService serves every stream accepted on port with fn, each in its
+// own goroutine. The stream is closed when fn returns.
+func (s *Server) HandleService(port uint16, fn func(c net.Conn)) error {
+	l, err := s.Listen(port)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				fn(c)
+			}()
+		}
+	}()
+	return nil
+}
+
+// ServeBuiltinServices starts the echo, discard and daytime services.
+func (s *Server) ServeBuiltinServices() error {
+	services := []struct {
+		port uint16
+		fn   func(net.Conn)
+	}{
+		{PortEcho, echoService},
+		{PortDiscard, discardService},
+		{PortDaytime, daytimeService},
+	}
+	for _, svc := range services {
+		if err := s.HandleService(svc.port, svc.fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ServeAdmin starts the admin service on port (usually PortAdmin). Every line
+// read is a command and is answered by one line of JSON:
+//
+//	stats | clients [selector] | groups | peers | help
+//
+// The admin service exposes the same read-only data as GetStats, QueryClients,
+// GetGroups and GetPeers to every node that can reach the switcher.
+func (s *Server) ServeAdmin(port uint16) error {
+	return s.HandleService(port, s.serveAdmin)
+}
+
+func echoService(c net.Conn)    { io.Copy(c, c) }
+func discardService(c net.Conn) { io.Copy(io.Discard, c) }
+func daytimeService(c net.Conn) { io.WriteString(c, time.Now().Format(time.RFC3339)+"\r\n") }
+
+func (s *Server) serveAdmin(c net.Conn) {
+	enc := json.NewEncoder(c)
+	scanner := bufio.NewScanner(c)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		resp, err := s.runAdmin(fields[0], strings.Join(fields[1:], " "))
+		if err != nil {
+			resp = map[string]string{"error": err.Error()}
+		}
+		if err := enc.Encode(resp); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) runAdmin(cmd, arg string) (any, error) {
+	switch cmd {
+	case "stats":
+		return s.GetStats(), nil
+	case "clients":
+		return s.QueryClients(arg)
+	case "groups":
+		return s.GetGroups(), nil
+	case "peers":
+		return s.GetPeers(), nil
+	case "help":
+		return []string{"stats", "clients [selector]", "groups", "peers", "help"}, nil
+	default:
+		return nil, errUnknownAdminCommand
+	}
+}
@@ -0,0 +1,191 @@
+package switcher
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/net-agent/flex/v3/internal/admit"
+	"github.com/net-agent/flex/v3/packet"
+	"github.com/net-agent/flex/v3/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuiltinServices(t *testing.T) {
+	s := NewServer("testpswd", nil, nil)
+	n := connectTestNode(t, s, "client")
+
+	// 未启用服务时无法连接
+	_, err := n.DialIP(packet.SwitcherIP, PortEcho)
+	assert.NotNil(t, err)
+
+	assert.Nil(t, s.ServeBuiltinServices())
+	assert.NotNil(t, s.ServeBuiltinServices())
+
+	c, err := n.DialIP(packet.SwitcherIP, PortEcho)
+	if assert.Nil(t, err) {
+		payload := []byte("hello switcher")
+		c.Write(payload)
+		buf := make([]byte, len(payload))
+		_, err = io.ReadFull(c, buf)
+		assert.Nil(t, err)
+		assert.Equal(t, payload, buf)
+		c.Close()
+	}
+
+	c, err = n.DialIP(packet.SwitcherIP, PortDaytime)
+	if assert.Nil(t, err) {
+		buf, _ := io.ReadAll(c)
+		ts, err := time.Parse(time.RFC3339, string(buf[:len(buf)-2]))
+		assert.Nil(t, err)
+		assert.WithinDuration(t, time.Now(), ts, 2*time.Second)
+		c.Close()
+	}
+
+	c, err = n.DialIP(packet.SwitcherIP, PortDiscard)
+	if assert.Nil(t, err) {
+		_, err = c.Write(make([]byte, 64*1024))
+		assert.Nil(t, err)
+		c.Close()
+	}
+
+	_, err = n.DialIP(packet.SwitcherIP, 12345)
+	assert.NotNil(t, err)
+}
+
+func TestHandleService(t *testing.T) {
+	s := NewServer("testpswd", nil, nil)
+	n := connectTestNode(t, s, "client")
+
+	// 服务节点看到的对端是节点的域名
+	assert.Nil(t, s.HandleService(2000, func(c net.Conn) {
+		io.WriteString(c, c.(*stream.Stream).GetState().RemoteDomain)
+	}))
+	assert.NotNil(t, s.HandleService(2000, func(net.Conn) {}))
+
+	c, err := n.DialIP(packet.SwitcherIP, 2000)
+	if assert.Nil(t, err) {
+		buf, _ := io.ReadAll(c)
+		assert.Equal(t, "client", string(buf))
+		c.Close()
+	}
+
+	// 按域名连接不受影响，ping switcher 仍由 router 处理
+	serveEcho(t, n, 80)
+	other := connectTestNode(t, s, "other")
+	assertEcho(t, other, "client:80", "client")
+	_, err = n.PingDomain("", time.Second)
+	assert.Nil(t, err)
+}
+
+func TestAdminService(t *testing.T) {
+	s := NewServer("testpswd", nil, nil)
+	n := connectLabeledNode(t, s, "client", map[string]string{"role": "ops"})
+	connectTestNode(t, s, "other")
+	assert.Nil(t, s.ServeAdmin(PortAdmin))
+
+	c, err := n.DialIP(packet.SwitcherIP, PortAdmin)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer c.Close()
+	r := bufio.NewReader(c)
+	query := func(cmd string, v any) {
+		t.Helper()
+		_, err := io.WriteString(c, cmd+"\n")
+		assert.Nil(t, err)
+		line, err := r.ReadBytes('\n')
+		assert.Nil(t, err)
+		assert.Nil(t, json.Unmarshal(line, v))
+	}
+
+	var stats StatsResponse
+	query("stats", &stats)
+	assert.Equal(t, 2, stats.ActiveConnections)
+
+	var clients []ClientInfo
+	query("clients role=ops", &clients)
+	if assert.Equal(t, 1, len(clients)) {
+		assert.Equal(t, "client", clients[0].Domain)
+	}
+	query("clients", &clients)
+	assert.Equal(t, 2, len(clients))
+
+	var resp map[string]string
+	query("reboot", &resp)
+	assert.Equal(t, errUnknownAdminCommand.Error(), resp["error"])
+	query("clients a b", &resp)
+	assert.NotEmpty(t, resp["error"])
+}
+
+func TestServerDial(t *testing.T) {
+	s, _, nodes := initGroup(t, "a", "b")
+	serveEcho(t, nodes[0], 81)
+
+	l, err := nodes[0].Listen(82)
+	if !assert.Nil(t, err) {
+		return
+	}
+	go func() {
+		c, err := l.Accept()
+		if err == nil {
+			// 对端看到的是 switcher 的名称
+			io.WriteString(c, c.(*stream.Stream).GetState().RemoteDomain)
+			c.Close()
+		}
+	}()
+
+	c, err := s.Dial("a:82")
+	if assert.Nil(t, err) {
+		buf, _ := io.ReadAll(c)
+		assert.Equal(t, s.GetName(), string(buf))
+		assert.Equal(t, "a", c.GetState().RemoteDomain)
+		c.Close()
+	}
+
+	c, err = s.Dial(fmt.Sprintf("%v:81", nodes[0].GetIP()))
+	if assert.Nil(t, err) {
+		c.Write([]byte("x"))
+		buf := make([]byte, 1)
+		_, err = io.ReadFull(c, buf)
+		assert.Nil(t, err)
+		c.Close()
+	}
+
+	// 服务组按策略选择成员
+	c, err = s.Dial("svc:80")
+	if assert.Nil(t, err) {
+		buf, _ := io.ReadAll(c)
+		assert.Contains(t, []string{"a", "b"}, string(buf))
+		c.Close()
+	}
+
+	_, err = s.Dial("notexists:80")
+	assert.NotNil(t, err)
+	_, err = s.Dial("a")
+	assert.NotNil(t, err)
+}
+
+func TestServerDialContext(t *testing.T) {
+	s := NewServer("testpswd", nil, nil)
+
+	// stuck 握手后不再读取，open 请求得不到应答
+	pc1, pc2 := packet.Pipe()
+	defer pc1.Close()
+	go s.ServeConn(pc2)
+	_, err := admit.Handshake(pc1, "stuck", "", s.password)
+	assert.Nil(t, err)
+	waitDomain(t, s, "stuck")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = s.DialContext(ctx, "stuck:80")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+}
@@ -0,0 +1,272 @@
+package switcher
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/net-agent/flex/v3/node"
+	"github.com/net-agent/flex/v3/packet"
+)
+
+var (
+	errTunnelExists     = errors.New("tunnel exists")
+	errTunnelNotFound   = errors.New("tunnel not found")
+	errTunnelNotAllowed = errors.New("tunnel port not allowed")
+	errTunnelNoFreePort = errors.New("no free tunnel port")
+)
+
+// maxTunnelPortAttempts 节点不指定端口时最多尝试的端口数。范围很大或大多已被占用时
+// 不逐个监听整个范围，避免长时间占用消息处理
+const maxTunnelPortAttempts = 16
+
+// TunnelPolicy limits the public ports nodes may open with tunnel.open.
+// Tunnels added by the operator with AddTunnel are not limited.
+type TunnelPolicy struct {
+	BindHost string // 监听地址，为空时监听所有地址
+	MinPort  uint16 // 允许节点申请的端口范围，MaxPort 为 0 时禁止节点申请
+	MaxPort  uint16
+}
+
+func (p TunnelPolicy) allows(port uint16) bool {
+	return p.MaxPort != 0 && port >= p.MinPort && port <= p.MaxPort
+}
+
+// candidatePorts 返回申请 port 时依次尝试的端口：指定端口时只有它本身，
+// 否则是范围内最多 maxTunnelPortAttempts 个不重复的随机端口
+func (p TunnelPolicy) candidatePorts(port uint16) []uint16 {
+	if port != 0 || p.MaxPort == 0 {
+		return []uint16{port}
+	}
+	lo := int(max(p.MinPort, 1))
+	n := int(p.MaxPort) - lo + 1
+	if n <= 0 {
+		return nil
+	}
+	ports := make([]uint16, 0, min(n, maxTunnelPortAttempts))
+	if n <= maxTunnelPortAttempts {
+		for _, i := range rand.Perm(n) {
+			ports = append(ports, uint16(lo+i))
+		}
+		return ports
+	}
+	seen := make(map[int]bool, maxTunnelPortAttempts)
+	for len(ports) < maxTunnelPortAttempts {
+		i := rand.IntN(n)
+		if !seen[i] {
+			seen[i] = true
+			ports = append(ports, uint16(lo+i))
+		}
+	}
+	return ports
+}
+
+// TunnelInfo describes a public TCP port forwarded to a flex address.
+type TunnelInfo struct {
+	Addr        string `json:"addr"`
+	Target      string `json:"target"`
+	Owner       string `json:"owner,omitempty"` // 通过 tunnel.open 申请的节点
+	ActiveConns int64  `json:"active_conns"`
+	TotalConns  int64  `json:"total_conns"`
+}
+
+// tunnel 在真实的 TCP 端口上接受连接，为每个连接向 target 建立 stream 并双向转发
+type tunnel struct {
+	s        *Server
+	listener net.Listener
+	port     uint16
+	target   string
+	owner    *Context // nil 表示由 AddTunnel 创建
+
+	active atomic.Int64
+	total  atomic.Int64
+}
+
+func (t *tunnel) serve() {
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			return
+		}
+		go t.forward(conn)
+	}
+}
+
+func (t *tunnel) forward(conn net.Conn) {
+	t.active.Add(1)
+	t.total.Add(1)
+	defer t.active.Add(-1)
+
+	st, err := t.s.Dial(t.target)
+	if err != nil {
+		t.s.logger.Warn("tunnel dial failed", "addr", t.listener.Addr(), "target", t.target, "error", err)
+		conn.Close()
+		return
+	}
+	node.Join(conn, st)
+}
+
+func (t *tunnel) info() TunnelInfo {
+	info := TunnelInfo{
+		Addr:        t.listener.Addr().String(),
+		Target:      t.target,
+		ActiveConns: t.active.Load(),
+		TotalConns:  t.total.Load(),
+	}
+	if t.owner != nil {
+		info.Owner = t.owner.Domain
+	}
+	return info
+}
+
+type tunnelTable struct {
+	mu      sync.Mutex
+	policy  TunnelPolicy
+	tunnels map[string]*tunnel // listener addr -> tunnel
+}
+
+// AddTunnel listens on the TCP address addr and forwards every accepted
+// connection to target through a flex stream opened with Dial, so target must
+// be served by a node connected to this switcher. It returns the bound address.
+func (s *Server) AddTunnel(addr, target string) (string, error) {
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		return "", err
+	}
+	t, err := s.openTunnel(addr, target, nil)
+	if err != nil {
+		return "", err
+	}
+	return t.listener.Addr().String(), nil
+}
+
+// RemoveTunnel closes the tunnel listening on addr, as returned by AddTunnel.
+// Forwarded connections stay open until either side closes them.
+func (s *Server) RemoveTunnel(addr string) error {
+	s.tunnels.mu.Lock()
+	t, ok := s.tunnels.tunnels[addr]
+	delete(s.tunnels.tunnels, addr)
+	s.tunnels.mu.Unlock()
+	if !ok {
+		return errTunnelNotFound
+	}
+	return t.listener.Close()
+}
+
+// GetTunnels returns the open tunnels sorted by address.
+func (s *Server) GetTunnels() []TunnelInfo {
+	s.tunnels.mu.Lock()
+	infos := make([]TunnelInfo, 0, len(s.tunnels.tunnels))
+	for _, t := range s.tunnels.tunnels {
+		infos = append(infos, t.info())
+	}
+	s.tunnels.mu.Unlock()
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Addr < infos[j].Addr })
+	return infos
+}
+
+// SetTunnelPolicy sets the ports nodes may open with tunnel.open. By default
+// nodes cannot open tunnels.
+func (s *Server) SetTunnelPolicy(policy TunnelPolicy) {
+	s.tunnels.mu.Lock()
+	s.tunnels.policy = policy
+	s.tunnels.mu.Unlock()
+}
+
+func (s *Server) openTunnel(addr, target string, owner *Context) (*tunnel, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	_, portStr, _ := net.SplitHostPort(l.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+	t := &tunnel{s: s, listener: l, port: uint16(port), target: target, owner: owner}
+
+	s.tunnels.mu.Lock()
+	key := l.Addr().String()
+	if _, exists := s.tunnels.tunnels[key]; exists {
+		s.tunnels.mu.Unlock()
+		l.Close()
+		return nil, errTunnelExists
+	}
+	s.tunnels.tunnels[key] = t
+	s.tunnels.mu.Unlock()
+
+	s.logger.Info("tunnel opened", "addr", key, "target", target)
+	go t.serve()
+	return t, nil
+}
+
+// closeTunnels 关闭 owner 申请的隧道，owner 为 nil 时关闭所有隧道
+func (s *Server) closeTunnels(owner *Context) {
+	s.tunnels.mu.Lock()
+	var closing []*tunnel
+	for key, t := range s.tunnels.tunnels {
+		if owner == nil || t.owner == owner {
+			closing = append(closing, t)
+			delete(s.tunnels.tunnels, key)
+		}
+	}
+	s.tunnels.mu.Unlock()
+	for _, t := range closing {
+		t.listener.Close()
+	}
+}
+
+func (s *Server) handleTunnelOpen(caller *Context, msg *packet.Message) (any, error) {
+	var req packet.TunnelRequest
+	if err := msg.Unmarshal(&req); err != nil {
+		return nil, err
+	}
+	s.tunnels.mu.Lock()
+	policy := s.tunnels.policy
+	s.tunnels.mu.Unlock()
+
+	target := fmt.Sprintf("%v:%v", caller.Domain, req.TargetPort)
+	ports := policy.candidatePorts(req.Port)
+
+	for _, port := range ports {
+		if !policy.allows(port) {
+			return nil, errTunnelNotAllowed
+		}
+		t, err := s.openTunnel(net.JoinHostPort(policy.BindHost, strconv.Itoa(int(port))), target, caller)
+		if err != nil {
+			if len(ports) > 1 {
+				continue
+			}
+			return nil, err
+		}
+		// 节点可能在申请过程中断开
+		if !caller.isAttached() {
+			s.closeTunnels(caller)
+			return nil, errNilContextConn
+		}
+		return packet.TunnelResponse{Addr: t.listener.Addr().String(), Port: t.port}, nil
+	}
+	return nil, errTunnelNoFreePort
+}
+
+func (s *Server) handleTunnelClose(caller *Context, msg *packet.Message) (any, error) {
+	var req packet.TunnelRequest
+	if err := msg.Unmarshal(&req); err != nil {
+		return nil, err
+	}
+	s.tunnels.mu.Lock()
+	var found *tunnel
+	for key, t := range s.tunnels.tunnels {
+		if t.owner == caller && t.port == req.Port {
+			found = t
+			delete(s.tunnels.tunnels, key)
+			break
+		}
+	}
+	s.tunnels.mu.Unlock()
+	if found == nil {
+		return nil, errTunnelNotFound
+	}
+	return nil, found.listener.Close()
+}
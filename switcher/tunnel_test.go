@@ -0,0 +1,143 @@
+package switcher
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func assertTunnelEcho(t *testing.T, addr string) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer conn.Close()
+	payload := []byte("hello tunnel")
+	conn.Write(payload)
+	buf := make([]byte, len(payload))
+	_, err = io.ReadFull(conn, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, payload, buf)
+}
+
+// freePort 返回一个当前空闲的本地 TCP 端口
+func freePort(t *testing.T) uint16 {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return uint16(l.Addr().(*net.TCPAddr).Port)
+}
+
+func TestAddTunnel(t *testing.T) {
+	s := NewServer("testpswd", nil, nil)
+	n := connectTestNode(t, s, "node-a")
+	serveEcho(t, n, 80)
+
+	addr, err := s.AddTunnel("127.0.0.1:0", "node-a:80")
+	if !assert.Nil(t, err) {
+		return
+	}
+	assertTunnelEcho(t, addr)
+	assertTunnelEcho(t, addr)
+
+	tunnels := s.GetTunnels()
+	if assert.Equal(t, 1, len(tunnels)) {
+		assert.Equal(t, addr, tunnels[0].Addr)
+		assert.Equal(t, "node-a:80", tunnels[0].Target)
+		assert.Equal(t, int64(2), tunnels[0].TotalConns)
+		assert.Empty(t, tunnels[0].Owner)
+	}
+
+	// 目标不可达时关闭连接
+	bad, err := s.AddTunnel("127.0.0.1:0", "notexists:80")
+	assert.Nil(t, err)
+	conn, err := net.Dial("tcp", bad)
+	if assert.Nil(t, err) {
+		_, err = io.ReadAll(conn)
+		assert.Nil(t, err)
+		conn.Close()
+	}
+
+	_, err = s.AddTunnel("127.0.0.1:0", "no-port")
+	assert.NotNil(t, err)
+	assert.Nil(t, s.RemoveTunnel(addr))
+	assert.NotNil(t, s.RemoveTunnel(addr))
+	_, err = net.Dial("tcp", addr)
+	assert.NotNil(t, err)
+
+	s.Close()
+	assert.Equal(t, 0, len(s.GetTunnels()))
+}
+
+func TestNodeTunnel(t *testing.T) {
+	s := NewServer("testpswd", nil, nil)
+	n := connectTestNode(t, s, "node-a")
+	serveEcho(t, n, 80)
+
+	// 默认禁止节点申请
+	port := freePort(t)
+	_, err := n.OpenTunnel(port, 80)
+	assert.NotNil(t, err)
+
+	s.SetTunnelPolicy(TunnelPolicy{BindHost: "127.0.0.1", MinPort: port, MaxPort: port})
+	_, err = n.OpenTunnel(port+1, 80)
+	assert.NotNil(t, err)
+
+	resp, err := n.OpenTunnel(0, 80)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, port, resp.Port)
+	assert.Equal(t, "127.0.0.1:"+strconv.Itoa(int(port)), resp.Addr)
+	assertTunnelEcho(t, resp.Addr)
+	if tunnels := s.GetTunnels(); assert.Equal(t, 1, len(tunnels)) {
+		assert.Equal(t, "node-a", tunnels[0].Owner)
+		assert.Equal(t, "node-a:80", tunnels[0].Target)
+	}
+
+	// 端口已被占用
+	_, err = n.OpenTunnel(port, 80)
+	assert.NotNil(t, err)
+
+	// 只能关闭自己申请的隧道
+	other := connectTestNode(t, s, "other")
+	assert.NotNil(t, other.CloseTunnel(port))
+	assert.Nil(t, n.CloseTunnel(port))
+	assert.Equal(t, 0, len(s.GetTunnels()))
+
+	// 节点断开后自动关闭
+	_, err = n.OpenTunnel(port, 80)
+	assert.Nil(t, err)
+	n.Close()
+	assert.Eventually(t, func() bool {
+		return len(s.GetTunnels()) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestTunnelCandidatePorts(t *testing.T) {
+	p := TunnelPolicy{MinPort: 1000, MaxPort: 0xffff}
+	assert.Equal(t, []uint16{2000}, p.candidatePorts(2000))
+
+	// 范围很大时只尝试有限个不重复的随机端口
+	ports := p.candidatePorts(0)
+	assert.Equal(t, maxTunnelPortAttempts, len(ports))
+	seen := make(map[uint16]bool)
+	for _, port := range ports {
+		assert.True(t, p.allows(port))
+		assert.False(t, seen[port])
+		seen[port] = true
+	}
+
+	// 范围较小时尝试全部端口
+	p = TunnelPolicy{MinPort: 0, MaxPort: 3}
+	assert.ElementsMatch(t, []uint16{1, 2, 3}, p.candidatePorts(0))
+	assert.Nil(t, TunnelPolicy{MinPort: 10, MaxPort: 5}.candidatePorts(0))
+}